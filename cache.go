@@ -0,0 +1,174 @@
+package streamql
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+
+	"github.com/aybabtme/streamql/lang/ast"
+	"github.com/aybabtme/streamql/lang/check"
+	"github.com/aybabtme/streamql/lang/grammar"
+)
+
+// Fingerprint returns a hash of the query that doesn't change with
+// its whitespace, its redundant parentheses or the way its members
+// are quoted: `.a+(.b*2)` has the same fingerprint as `.a + .b * 2`.
+// It returns an error if the syntax of the query is invalid.
+func Fingerprint(query string) (string, error) {
+	tree, err := grammar.Parse(strings.NewReader(query))
+	if err != nil {
+		return "", err
+	}
+	return fingerprint(tree), nil
+}
+
+// fingerprint hashes the canonical text of the tree, which is the
+// same for all the queries that parse to it.
+func fingerprint(tree *ast.AST) string {
+	sum := sha256.Sum256([]byte(ast.Format(tree)))
+	return hex.EncodeToString(sum[:])
+}
+
+// maxSpellings is how many different texts of the same query a Cache
+// remembers, so that it finds them without parsing them.
+const maxSpellings = 4
+
+// A Cache keeps the queries that were compiled most recently, by
+// their fingerprint, so that they don't need to be compiled again. The
+// texts that were given for them are remembered too, so that a query
+// given again with the same text isn't even parsed. It is safe for
+// concurrent use.
+type Cache struct {
+	// Limits are those of CompileWithLimits, if they're set. They
+	// mustn't change once the cache is used.
+	Limits *check.Limits
+
+	size int
+
+	mu sync.Mutex
+	// recent lists the entries from the most recently used to the
+	// least
+	recent  *list.List
+	entries map[string]*list.Element
+	// texts are the same entries, by the texts of their queries
+	texts map[string]*list.Element
+}
+
+type cacheEntry struct {
+	fingerprint string
+	query       *Query
+	// texts that were given for the query, from the oldest
+	texts []string
+}
+
+// NewCache returns a cache of up to size queries.
+func NewCache(size int) *Cache {
+	if size < 1 {
+		size = 1
+	}
+	return &Cache{
+		size:    size,
+		recent:  list.New(),
+		entries: make(map[string]*list.Element),
+		texts:   make(map[string]*list.Element),
+	}
+}
+
+// Compile is like the CompileWithLimits function, with the limits of
+// the cache, but returns the query from the cache if one with the same
+// fingerprint was compiled before.
+// Queries that fail to compile aren't kept.
+func (c *Cache) Compile(query string) (*Query, error) {
+	if q, ok := c.getText(query); ok {
+		return q, nil
+	}
+	tree, err := parse(query, c.Limits)
+	if err != nil {
+		return nil, err
+	}
+	key := fingerprint(tree)
+	if q, ok := c.get(key, query); ok {
+		return q, nil
+	}
+	// compile without holding the lock, the queries are independent
+	q, err := compile(tree, c.Limits)
+	if err != nil {
+		return nil, err
+	}
+	return c.add(key, query, q), nil
+}
+
+// Len returns the number of queries in the cache.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.recent.Len()
+}
+
+// getText returns the query that was compiled from the same text.
+func (c *Cache) getText(text string) (*Query, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.texts[text]
+	if !ok {
+		return nil, false
+	}
+	c.recent.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).query, true
+}
+
+// get returns the query of the fingerprint, and remembers that it was
+// given as text.
+func (c *Cache) get(key, text string) (*Query, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.recent.MoveToFront(elem)
+	c.addText(elem, text)
+	return elem.Value.(*cacheEntry).query, true
+}
+
+// add puts q in the cache, unless another call compiled the same query
+// in the meantime, in which case it returns that one.
+func (c *Cache) add(key, text string, q *Query) *Query {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.recent.MoveToFront(elem)
+		c.addText(elem, text)
+		return elem.Value.(*cacheEntry).query
+	}
+	elem := c.recent.PushFront(&cacheEntry{fingerprint: key, query: q})
+	c.entries[key] = elem
+	c.addText(elem, text)
+	if c.recent.Len() > c.size {
+		oldest := c.recent.Back()
+		c.recent.Remove(oldest)
+		entry := oldest.Value.(*cacheEntry)
+		delete(c.entries, entry.fingerprint)
+		for _, text := range entry.texts {
+			delete(c.texts, text)
+		}
+	}
+	return q
+}
+
+// addText remembers that the query of elem was given as text, and
+// forgets the oldest of its texts if it has too many.
+func (c *Cache) addText(elem *list.Element, text string) {
+	if _, ok := c.texts[text]; ok {
+		return
+	}
+	entry := elem.Value.(*cacheEntry)
+	if len(entry.texts) == maxSpellings {
+		delete(c.texts, entry.texts[0])
+		entry.texts = entry.texts[1:]
+	}
+	entry.texts = append(entry.texts, text)
+	c.texts[text] = elem
+}
@@ -0,0 +1,157 @@
+package streamql
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/aybabtme/streamql/lang/check"
+)
+
+func TestFingerprint(t *testing.T) {
+	same := [][]string{
+		{`.a + .b * 2`, `.a+(.b*2)`, "(.a +\n\t(.b * 2))"},
+		{`.user.id`, `."user"["id"]`, ` .user .id `},
+		{`select(.a) | .b`, `select( .a )|.b`},
+	}
+	var prints []string
+	for _, queries := range same {
+		want, err := Fingerprint(queries[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, query := range queries[1:] {
+			got, err := Fingerprint(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("%q and %q: want the same fingerprint, got %s and %s", queries[0], query, want, got)
+			}
+		}
+		prints = append(prints, want)
+	}
+	for i := range prints {
+		for j := i + 1; j < len(prints); j++ {
+			if prints[i] == prints[j] {
+				t.Errorf("%q and %q: want different fingerprints", same[i][0], same[j][0])
+			}
+		}
+	}
+	if _, err := Fingerprint(`.a |`); err == nil {
+		t.Error("want an error for an invalid query")
+	}
+}
+
+func TestCache(t *testing.T) {
+	c := NewCache(2)
+	a, err := c.Compile(`.a + 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Compile(`.b`); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := c.Compile(`(.a+1)`); again != a {
+		t.Error("want the cached query for an equivalent query")
+	}
+	if _, err := c.Compile(`nope(.c)`); err == nil {
+		t.Fatal("want an error for an unknown function")
+	}
+	if got := c.Len(); got != 2 {
+		t.Errorf("want 2 queries in the cache, got %d", got)
+	}
+
+	// `.a + 1` was used more recently than `.b`, so `.b` is evicted
+	if _, err := c.Compile(`.c`); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := c.Compile(`.a + 1`); again != a {
+		t.Error("want the most recently used query to stay in the cache")
+	}
+	if got := c.Len(); got != 2 {
+		t.Errorf("want 2 queries in the cache, got %d", got)
+	}
+}
+
+func TestCacheTexts(t *testing.T) {
+	c := NewCache(2)
+	a, err := c.Compile(`.a + 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{`.a+1`, `(.a + 1)`, ` .a + 1`, `.a +1`, `.a + 1 `} {
+		if again, _ := c.Compile(text); again != a {
+			t.Errorf("%q: want the cached query for an equivalent query", text)
+		}
+	}
+	if got := len(c.texts); got != maxSpellings {
+		t.Errorf("want %d texts of the query, got %d", maxSpellings, got)
+	}
+	if _, ok := c.getText(`.a + 1`); ok {
+		t.Error("want the oldest text of the query to be forgotten")
+	}
+	if again, ok := c.getText(`.a + 1 `); !ok || again != a {
+		t.Error("want the cached query for the same text, without parsing it")
+	}
+
+	// texts are evicted with their query
+	for _, text := range []string{`.b`, `.c`} {
+		if _, err := c.Compile(text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(c.texts); got != 2 {
+		t.Errorf("want 2 texts in the cache, got %d", got)
+	}
+	if _, ok := c.getText(`.a+1`); ok {
+		t.Error("want the texts of an evicted query to be forgotten")
+	}
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	c := NewCache(4)
+	queries := []string{`.a`, `.b`, `.a | .b`, `select(.c)`, `.d + 1`, ` .a `}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, err := c.Compile(queries[(i+j)%len(queries)]); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := c.Len(); got != 4 {
+		t.Errorf("want 4 queries in the cache, got %d", got)
+	}
+}
+
+func TestCacheLimits(t *testing.T) {
+	c := NewCache(2)
+	c.Limits = &check.Limits{MaxGenerators: 1}
+	if _, err := c.Compile(`.a[]`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Compile(`.a[] | .b[]`); err == nil {
+		t.Error("want an error for a query past the limits")
+	}
+}
+
+func BenchmarkCacheCompile(b *testing.B) {
+	c := NewCache(16)
+	query := `select(.user.id > 10 && startswith(.user.name, "a")) | .user.email`
+	if _, err := c.Compile(query); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Compile(query); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -10,10 +10,12 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/aybabtme/streamql/lang/check"
 	"github.com/aybabtme/streamql/lang/grammar"
 	"github.com/aybabtme/streamql/lang/msg"
 	"github.com/aybabtme/streamql/lang/msg/gomsg"
 	"github.com/aybabtme/streamql/lang/msg/msgutil"
+	"github.com/aybabtme/streamql/lang/optimize"
 	"github.com/aybabtme/streamql/lang/vm"
 	"github.com/aybabtme/streamql/lang/vm/astvm"
 )
@@ -26,9 +28,20 @@ func main() {
 	query := strings.Join(flag.Args(), " ")
 
 	tree, err := grammar.Parse(strings.NewReader(query))
-	if err != nil {
+	if errs, ok := err.(grammar.ErrorList); ok {
+		for _, err := range errs {
+			log.Printf("invalid query: %v\n%s", err, err.Snippet)
+		}
+		os.Exit(1)
+	} else if err != nil {
 		log.Fatalf("invalid query: %v", err)
 	}
+	if errs, ok := check.Check(tree, astvm.Builtin).(check.ErrorList); ok {
+		for _, err := range errs {
+			log.Printf("invalid query: %v", err)
+		}
+		os.Exit(1)
+	}
 
 	in := os.Stdin
 	out := os.Stdout
@@ -76,7 +89,7 @@ func main() {
 		}
 	}()
 
-	engine := astvm.Interpreter(tree, &vm.Options{})
+	engine := astvm.Interpreter(optimize.Optimize(tree), &vm.Options{})
 	engine.Run(
 		builder,
 		func() (msg.Msg, bool, error) { msg, more := <-inc; return msg, more, nil },
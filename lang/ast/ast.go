@@ -1,9 +1,29 @@
 package ast
 
+import "fmt"
+
 type AST struct {
 	Expr *Expr `json:"expr,omitempty"`
 }
 
+// Pos is a position in a query.
+type Pos struct {
+	Offset int `json:"offset"` // in bytes, starting at 0
+	Line   int `json:"line"`   // starting at 1
+	Column int `json:"column"` // in runes, starting at 1
+}
+
+func (p Pos) String() string { return fmt.Sprintf("%d:%d", p.Line, p.Column) }
+
+// Span is the part of a query that a node was parsed from. End is
+// just past the last character of the node.
+type Span struct {
+	Start Pos `json:"start"`
+	End   Pos `json:"end"`
+}
+
+func (s Span) String() string { return fmt.Sprintf("%v-%v", s.Start, s.End) }
+
 type Expr struct {
 	// oneof
 	Literal        *Literal        `json:"literal,omitempty"`
@@ -12,6 +32,8 @@ type Expr struct {
 	BinaryOperator *BinaryOperator `json:"binary_operator,omitempty"`
 	FuncCall       *FuncCall       `json:"func_call,omitempty"`
 	Next           *Expr           `json:"next,omitempty"`
+
+	Span *Span `json:"span,omitempty"`
 }
 
 type Literal struct {
@@ -28,6 +50,8 @@ type Selector struct {
 	Noop   *NoopSelector   `json:"noop,omitempty"`
 	Member *MemberSelector `json:"member,omitempty"`
 	Slice  *SliceSelector  `json:"slice,omitempty"`
+
+	Span *Span `json:"span,omitempty"`
 }
 
 type NoopSelector struct{}
@@ -44,6 +68,8 @@ type SliceSelector struct {
 type FuncCall struct {
 	Name string  `json:"name,omitempty"`
 	Args []*Expr `json:"args,omitempty"`
+
+	Span *Span `json:"span,omitempty"`
 }
 
 type UnaryOperator struct {
@@ -62,6 +88,7 @@ type BinaryOperator struct {
 	NumSub    *OpNumSub    `json:"sub,omitempty"`
 	NumDiv    *OpNumDiv    `json:"div,omitempty"`
 	NumMul    *OpNumMul    `json:"mul,omitempty"`
+	NumMod    *OpNumMod    `json:"mod,omitempty"`
 	CmpEq     *OpCmpEq     `json:"eq,omitempty"`
 	CmpNotEq  *OpCmpNotEq  `json:"not_eq,omitempty"`
 	CmpGt     *OpCmpGt     `json:"gt,omitempty"`
@@ -77,6 +104,7 @@ type OpNumAdd struct{}
 type OpNumSub struct{}
 type OpNumDiv struct{}
 type OpNumMul struct{}
+type OpNumMod struct{}
 type OpCmpEq struct{}
 type OpCmpNotEq struct{}
 type OpCmpGt struct{}
@@ -0,0 +1,335 @@
+package ast
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Format returns the canonical text of a query. The text parses back
+// to the same tree, minus the spans, and only has the parentheses it
+// needs.
+//
+// Trees that the parser can't produce, like a pipeline used as the
+// operand of an operator or a negative number literal, are printed
+// as best as can be but won't parse back to the same tree.
+func Format(tree *AST) string {
+	p := &printer{}
+	if tree.Expr != nil {
+		p.expr(tree.Expr, precLowest)
+	}
+	return p.buf.String()
+}
+
+// FormatIndent is like Format, but puts each stage of a pipeline on
+// its own line. The arguments of a function call that hold pipelines
+// are put on their own lines too, with one more indent than the call.
+func FormatIndent(tree *AST, indent string) string {
+	p := &printer{multiline: true, indent: indent}
+	if tree.Expr != nil {
+		p.expr(tree.Expr, precLowest)
+	}
+	return p.buf.String()
+}
+
+// precedence of the operators, as declared in the grammar. Oddly, each
+// arithmetic operator has its own level: `a + b - c` is `a + (b - c)`.
+const (
+	precLowest = iota
+	precPipe
+	precOr
+	precAnd
+	precNot
+	precEq
+	precCmp
+	precAdd
+	precSub
+	precMul
+	precDiv
+	precMod
+	precAtom
+)
+
+type printer struct {
+	buf bytes.Buffer
+
+	multiline bool
+	indent    string
+	depth     int
+}
+
+// expr prints e and the pipeline that follows it. follow is the
+// precedence of the operator that will be printed right after it.
+func (p *printer) expr(e *Expr, follow int) {
+	if e.Next == nil {
+		p.node(e, follow)
+		return
+	}
+	p.node(e, precPipe)
+	if p.multiline {
+		p.newline()
+		p.buf.WriteString("| ")
+	} else {
+		p.buf.WriteString(" | ")
+	}
+	p.expr(e.Next, follow)
+}
+
+// operand prints e where an operator of precedence `ctx` expects an
+// operand, with parentheses if it would be parsed otherwise.
+func (p *printer) operand(e *Expr, ctx int, rhs bool, follow int) {
+	if !needsParens(e, ctx, rhs, follow) {
+		p.expr(e, follow)
+		return
+	}
+	p.buf.WriteByte('(')
+	p.expr(e, precLowest)
+	p.buf.WriteByte(')')
+}
+
+func needsParens(e *Expr, ctx int, rhs bool, follow int) bool {
+	if e.Next != nil {
+		return true
+	}
+	switch {
+	case e.UnaryOperator != nil:
+		// a prefix operator takes in everything after it that binds
+		// tighter than itself
+		return follow > precNot
+	case e.BinaryOperator != nil:
+		if isNeg(e.BinaryOperator) {
+			return follow > precSub
+		}
+		prec := binaryPrec(e.BinaryOperator)
+		if rhs {
+			return prec <= ctx
+		}
+		return prec < ctx || (prec == ctx && (prec == precEq || prec == precCmp))
+	}
+	return false
+}
+
+func (p *printer) node(e *Expr, follow int) {
+	switch {
+	case e.Literal != nil:
+		p.literal(e.Literal)
+	case e.Selector != nil:
+		p.buf.WriteByte('.')
+		p.selector(e.Selector, true)
+	case e.UnaryOperator != nil:
+		p.buf.WriteString("!")
+		p.operand(e.UnaryOperator.Arg, precNot, true, follow)
+	case e.BinaryOperator != nil:
+		p.binaryOperator(e.BinaryOperator, follow)
+	case e.FuncCall != nil:
+		p.funcCall(e.FuncCall)
+	}
+}
+
+func (p *printer) literal(l *Literal) {
+	switch {
+	case l.Bool != nil:
+		p.buf.WriteString(strconv.FormatBool(*l.Bool))
+	case l.String != nil:
+		p.buf.WriteString(strconv.Quote(*l.String))
+	case l.Int != nil:
+		p.buf.WriteString(strconv.FormatInt(*l.Int, 10))
+	case l.Float != nil:
+		s := strconv.FormatFloat(*l.Float, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eEnN") {
+			// otherwise it would be read back as an integer
+			s += ".0"
+		}
+		p.buf.WriteString(s)
+	case l.Null != nil:
+		p.buf.WriteString("null")
+	}
+}
+
+// selector prints s, after the leading dot of the query has been
+// printed if s is the first in its chain.
+func (p *printer) selector(s *Selector, first bool) {
+	var child *Selector
+	switch {
+	case s.Member != nil:
+		if name, ok := memberName(s.Member.Index); ok {
+			if !first {
+				p.buf.WriteByte('.')
+			}
+			p.buf.WriteString(name)
+		} else {
+			p.buf.WriteByte('[')
+			p.expr(s.Member.Index, precLowest)
+			p.buf.WriteByte(']')
+		}
+		child = s.Member.Child
+	case s.Slice != nil:
+		p.buf.WriteByte('[')
+		if s.Slice.From != nil || s.Slice.To != nil {
+			if s.Slice.From != nil {
+				p.expr(s.Slice.From, precLowest)
+			}
+			p.buf.WriteByte(':')
+			if s.Slice.To != nil {
+				p.expr(s.Slice.To, precLowest)
+			}
+		}
+		p.buf.WriteByte(']')
+		child = s.Slice.Child
+	}
+	if child != nil {
+		p.selector(child, false)
+	}
+}
+
+// memberName returns how a member index can be written after a dot,
+// if it can be.
+func memberName(index *Expr) (string, bool) {
+	if index.Next != nil || index.Literal == nil || index.Literal.String == nil {
+		return "", false
+	}
+	name := *index.Literal.String
+	if isIdentifier(name) {
+		return name, true
+	}
+	return strconv.Quote(name), true
+}
+
+func isIdentifier(name string) bool {
+	switch name {
+	case "", "true", "false", "null":
+		return false
+	}
+	for i, r := range name {
+		if r == '_' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r)) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+func (p *printer) binaryOperator(b *BinaryOperator, follow int) {
+	if isNeg(b) {
+		p.buf.WriteByte('-')
+		p.operand(b.RHS, precSub, true, follow)
+		return
+	}
+	prec := binaryPrec(b)
+	p.operand(b.LHS, prec, false, prec)
+	p.buf.WriteByte(' ')
+	p.buf.WriteString(binarySymbol(b))
+	p.buf.WriteByte(' ')
+	p.operand(b.RHS, prec, true, follow)
+}
+
+// isNeg tells if b is how the parser writes a negation, `-x` being
+// read as `0 - x`.
+func isNeg(b *BinaryOperator) bool {
+	if b.NumSub == nil || b.LHS == nil || b.LHS.Next != nil {
+		return false
+	}
+	lit := b.LHS.Literal
+	return lit != nil && lit.Int != nil && *lit.Int == 0
+}
+
+func binaryPrec(b *BinaryOperator) int {
+	switch {
+	case b.LogOr != nil:
+		return precOr
+	case b.LogAnd != nil:
+		return precAnd
+	case b.CmpEq != nil, b.CmpNotEq != nil:
+		return precEq
+	case b.CmpGt != nil, b.CmpGtOrEq != nil, b.CmpLs != nil, b.CmpLsOrEq != nil:
+		return precCmp
+	case b.NumAdd != nil:
+		return precAdd
+	case b.NumSub != nil:
+		return precSub
+	case b.NumMul != nil:
+		return precMul
+	case b.NumDiv != nil:
+		return precDiv
+	case b.NumMod != nil:
+		return precMod
+	}
+	return precAtom
+}
+
+func binarySymbol(b *BinaryOperator) string {
+	switch {
+	case b.LogOr != nil:
+		return "||"
+	case b.LogAnd != nil:
+		return "&&"
+	case b.CmpEq != nil:
+		return "=="
+	case b.CmpNotEq != nil:
+		return "!="
+	case b.CmpGt != nil:
+		return ">"
+	case b.CmpGtOrEq != nil:
+		return ">="
+	case b.CmpLs != nil:
+		return "<"
+	case b.CmpLsOrEq != nil:
+		return "<="
+	case b.NumAdd != nil:
+		return "+"
+	case b.NumSub != nil:
+		return "-"
+	case b.NumMul != nil:
+		return "*"
+	case b.NumDiv != nil:
+		return "/"
+	case b.NumMod != nil:
+		return "%"
+	}
+	return "?"
+}
+
+func (p *printer) funcCall(f *FuncCall) {
+	p.buf.WriteString(f.Name)
+	if len(f.Args) == 0 {
+		return
+	}
+	p.buf.WriteByte('(')
+	if p.multiline && hasPipeline(f.Args) {
+		p.depth++
+		for i, arg := range f.Args {
+			if i > 0 {
+				p.buf.WriteByte(',')
+			}
+			p.newline()
+			p.expr(arg, precLowest)
+		}
+		p.depth--
+		p.newline()
+	} else {
+		for i, arg := range f.Args {
+			if i > 0 {
+				p.buf.WriteString(", ")
+			}
+			p.expr(arg, precLowest)
+		}
+	}
+	p.buf.WriteByte(')')
+}
+
+func hasPipeline(args []*Expr) bool {
+	for _, arg := range args {
+		if arg.Next != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *printer) newline() {
+	p.buf.WriteByte('\n')
+	for i := 0; i < p.depth; i++ {
+		p.buf.WriteString(p.indent)
+	}
+}
@@ -0,0 +1,171 @@
+package ast_test
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aybabtme/streamql/lang/ast"
+	"github.com/aybabtme/streamql/lang/grammar"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		args string
+		want string
+	}{
+		{args: ``, want: ``},
+		{args: `.`, want: `.`},
+		{args: ` .hello `, want: `.hello`},
+		{args: `.["hello"]`, want: `.hello`},
+		{args: `."an awkward key"."true"`, want: `."an awkward key"."true"`},
+		{args: `.héllo.日本語`, want: `.héllo.日本語`},
+		{args: `.a[0][ 1 : 2 ][:3][4:][].b`, want: `.a[0][1:2][:3][4:][].b`},
+		{args: `.[.a|.b]`, want: `.[.a | .b]`},
+		{args: `true|false|null`, want: `true | false | null`},
+		{args: `"a\tbé"`, want: `"a\tbé"`},
+		{args: `0x10 + 1_000`, want: `16 + 1000`},
+		{args: `1.0 + 1e3 + .5 + 1e-7`, want: `1.0 + 1000.0 + 0.5 + 1e-07`},
+		{args: `1 + 2 - 3`, want: `1 + 2 - 3`},
+		{args: `(1 + 2) - 3`, want: `(1 + 2) - 3`},
+		{args: `1 - (2 - 3)`, want: `1 - (2 - 3)`},
+		{args: `((1 - 2)) - 3`, want: `1 - 2 - 3`},
+		{args: `(1 * 2) / 3`, want: `(1 * 2) / 3`},
+		{args: `(1 / 2) * 3`, want: `1 / 2 * 3`},
+		{args: `(1 == 2) == 3`, want: `(1 == 2) == 3`},
+		{args: `1 > 2 == 3 < 4`, want: `1 > 2 == 3 < 4`},
+		{args: `(.a || .b) && !(.c && .d)`, want: `(.a || .b) && !(.c && .d)`},
+		{args: `(!.a) == .b`, want: `(!.a) == .b`},
+		{args: `!(.a == .b)`, want: `!.a == .b`},
+		{args: `-1`, want: `-1`},
+		{args: `0 - 1`, want: `-1`},
+		{args: `-(1 + 2)`, want: `-(1 + 2)`},
+		{args: `-1 * 2`, want: `-1 * 2`},
+		{args: `(-1) * 2`, want: `(-1) * 2`},
+		{args: `2 * -1`, want: `2 * -1`},
+		{args: `(2 * -1) * 3`, want: `2 * (-1) * 3`},
+		{args: `1 - -1`, want: `1 - -1`},
+		{args: `--1`, want: `--1`},
+		{args: `!!true`, want: `!!true`},
+		{args: `select(.a,1)|max(.[]) |length`, want: `select(.a, 1) | max(.[]) | length`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			tree := mustParse(t, tt.args)
+			got := ast.Format(tree)
+			if got != tt.want {
+				t.Errorf("want=%s", tt.want)
+				t.Errorf(" got=%s", got)
+			}
+			checkRoundTrip(t, tree, got)
+		})
+	}
+}
+
+func TestFormatIndent(t *testing.T) {
+	tree := mustParse(t, `.a | select(.b | length > 2, .c) | map(.d, .e | .f) | .g`)
+	want := strings.Join([]string{
+		`.a`,
+		`| select(`,
+		`  .b`,
+		`  | length > 2,`,
+		`  .c`,
+		`)`,
+		`| map(`,
+		`  .d,`,
+		`  .e`,
+		`  | .f`,
+		`)`,
+		`| .g`,
+	}, "\n")
+	got := ast.FormatIndent(tree, "  ")
+	if got != want {
+		t.Errorf("want=\n%s", want)
+		t.Errorf(" got=\n%s", got)
+	}
+	checkRoundTrip(t, tree, got)
+}
+
+// TestFormatRandomTrees checks that the parentheses that are put, or
+// left out, don't change the meaning of a query.
+func TestFormatRandomTrees(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	for i := 0; i < 2000; i++ {
+		tree := &ast.AST{Expr: randomExpr(r, 4)}
+		checkRoundTrip(t, tree, ast.Format(tree))
+		if t.Failed() {
+			return
+		}
+	}
+}
+
+func randomExpr(r *rand.Rand, depth int) *ast.Expr {
+	if depth == 0 || r.Intn(4) == 0 {
+		v := int64(r.Intn(9) + 1)
+		return &ast.Expr{Literal: &ast.Literal{Int: &v}}
+	}
+	lhs, rhs := randomExpr(r, depth-1), randomExpr(r, depth-1)
+	switch r.Intn(14) {
+	case 0:
+		return &ast.Expr{UnaryOperator: &ast.UnaryOperator{Arg: rhs, LogNot: &ast.OpLogNot{}}}
+	case 1:
+		zero := int64(0)
+		lhs = &ast.Expr{Literal: &ast.Literal{Int: &zero}}
+		return &ast.Expr{BinaryOperator: &ast.BinaryOperator{LHS: lhs, RHS: rhs, NumSub: &ast.OpNumSub{}}}
+	}
+	ops := []ast.BinaryOperator{
+		{LogAnd: &ast.OpLogAnd{}}, {LogOr: &ast.OpLogOr{}},
+		{NumAdd: &ast.OpNumAdd{}}, {NumSub: &ast.OpNumSub{}},
+		{NumMul: &ast.OpNumMul{}}, {NumDiv: &ast.OpNumDiv{}},
+		{NumMod: &ast.OpNumMod{}},
+		{CmpEq: &ast.OpCmpEq{}}, {CmpNotEq: &ast.OpCmpNotEq{}},
+		{CmpGt: &ast.OpCmpGt{}}, {CmpGtOrEq: &ast.OpCmpGtOrEq{}},
+		{CmpLs: &ast.OpCmpLs{}}, {CmpLsOrEq: &ast.OpCmpLsOrEq{}},
+	}
+	op := ops[r.Intn(len(ops))]
+	op.LHS, op.RHS = lhs, rhs
+	return &ast.Expr{BinaryOperator: &op}
+}
+
+func mustParse(t *testing.T, query string) *ast.AST {
+	tree, err := grammar.Parse(strings.NewReader(query))
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return tree
+}
+
+// checkRoundTrip checks that text parses back to tree, ignoring where
+// the nodes were found.
+func checkRoundTrip(t *testing.T, tree *ast.AST, text string) {
+	got, err := grammar.Parse(strings.NewReader(text))
+	if err != nil {
+		t.Errorf("%s: %v", text, err)
+		return
+	}
+	stripSpans(tree)
+	stripSpans(got)
+	if !reflect.DeepEqual(tree, got) {
+		want, _ := json.Marshal(tree)
+		got, _ := json.Marshal(got)
+		t.Errorf("%s doesn't parse back to the same tree", text)
+		t.Errorf("want=%s", want)
+		t.Errorf(" got=%s", got)
+	}
+}
+
+func stripSpans(tree *ast.AST) {
+	ast.Inspect(tree, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Expr:
+			n.Span = nil
+		case *ast.Selector:
+			n.Span = nil
+		case *ast.FuncCall:
+			n.Span = nil
+		}
+		return true
+	})
+}
@@ -0,0 +1,251 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Version is the version of the format that Marshal writes trees in.
+// It changes whenever a tree in the new format can't be read by the
+// code of the previous versions. The format is described by the JSON
+// schema in ast.schema.json. Version 2 added the `%` operator.
+const Version = 2
+
+// oldestVersion is the oldest version of the format that Unmarshal
+// still reads. The trees of every version since are ones of the latest.
+const oldestVersion = 1
+
+// document is what Marshal writes: a tree, along with the version of
+// its format.
+type document struct {
+	Version int  `json:"version"`
+	AST     *AST `json:"ast"`
+}
+
+// Marshal encodes a tree in JSON, so that it can be sent to another
+// program. It returns an error if the tree isn't valid.
+func Marshal(tree *AST) ([]byte, error) {
+	if err := Validate(tree); err != nil {
+		return nil, err
+	}
+	return json.Marshal(&document{Version: Version, AST: tree})
+}
+
+// Unmarshal decodes a tree encoded by Marshal. It returns an error if
+// the tree isn't valid, or was written in a version of the format that
+// it doesn't know.
+func Unmarshal(data []byte) (*AST, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var doc document
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("ast: can't decode tree: %v", err)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("ast: can't decode tree: data after the tree")
+	}
+	if doc.Version < oldestVersion || doc.Version > Version {
+		return nil, fmt.Errorf("ast: can't decode tree: version %d of the format isn't supported, only versions %d to %d are", doc.Version, oldestVersion, Version)
+	}
+	if doc.AST == nil {
+		return nil, &InvalidTreeError{Path: "ast", Msg: "no tree"}
+	}
+	if err := Validate(doc.AST); err != nil {
+		return nil, err
+	}
+	return doc.AST, nil
+}
+
+// InvalidTreeError describes why a tree isn't valid.
+type InvalidTreeError struct {
+	// Path leads to the invalid node, with the JSON names of the fields
+	// that hold it, like `expr.binary_operator.lhs`.
+	Path string
+	// Msg describes what's wrong with the node.
+	Msg string
+}
+
+func (e *InvalidTreeError) Error() string {
+	return fmt.Sprintf("ast: invalid tree at %s: %s", e.Path, e.Msg)
+}
+
+// Validate checks that a tree could have been parsed from a query:
+// each node has exactly one of the fields that are exclusive set, and
+// all the fields that it needs. Interpreters can assume that the trees
+// they run are valid.
+func Validate(tree *AST) error {
+	if tree == nil {
+		return &InvalidTreeError{Path: "ast", Msg: "no tree"}
+	}
+	v := &validator{path: []string{"ast"}}
+	if tree.Expr != nil {
+		v.expr("expr", tree.Expr)
+	}
+	return v.err
+}
+
+type validator struct {
+	path []string
+	err  error
+}
+
+func (v *validator) errorf(format string, args ...interface{}) {
+	if v.err == nil {
+		v.err = &InvalidTreeError{Path: strings.Join(v.path, "."), Msg: fmt.Sprintf(format, args...)}
+	}
+}
+
+func (v *validator) enter(field string) { v.path = append(v.path, field) }
+func (v *validator) leave()             { v.path = v.path[:len(v.path)-1] }
+
+// oneOf checks that exactly one of the fields of a node that are
+// exclusive is set.
+func (v *validator) oneOf(what string, set ...bool) {
+	n := 0
+	for _, isSet := range set {
+		if isSet {
+			n++
+		}
+	}
+	switch {
+	case n == 0:
+		v.errorf("no %s set", what)
+	case n > 1:
+		v.errorf("%d %ss set, want only one", n, what)
+	}
+}
+
+// required checks that a field of a node that holds an expression is
+// set, and validates the expression if it is.
+func (v *validator) required(field string, e *Expr) {
+	if e == nil {
+		v.enter(field)
+		v.errorf("no expression")
+		v.leave()
+		return
+	}
+	v.expr(field, e)
+}
+
+func (v *validator) expr(field string, e *Expr) {
+	v.enter(field)
+	defer v.leave()
+	v.span(e.Span)
+	v.oneOf("expression",
+		e.Literal != nil,
+		e.Selector != nil,
+		e.UnaryOperator != nil,
+		e.BinaryOperator != nil,
+		e.FuncCall != nil,
+	)
+	switch {
+	case e.Literal != nil:
+		v.literal(e.Literal)
+	case e.Selector != nil:
+		v.selector("selector", e.Selector)
+	case e.UnaryOperator != nil:
+		v.unaryOperator(e.UnaryOperator)
+	case e.BinaryOperator != nil:
+		v.binaryOperator(e.BinaryOperator)
+	case e.FuncCall != nil:
+		v.funcCall(e.FuncCall)
+	}
+	if e.Next != nil && v.err == nil {
+		v.expr("next", e.Next)
+	}
+}
+
+func (v *validator) literal(l *Literal) {
+	v.enter("literal")
+	defer v.leave()
+	v.oneOf("literal value",
+		l.Bool != nil,
+		l.String != nil,
+		l.Int != nil,
+		l.Float != nil,
+		l.Null != nil,
+	)
+}
+
+func (v *validator) selector(field string, s *Selector) {
+	v.enter(field)
+	defer v.leave()
+	v.span(s.Span)
+	v.oneOf("selector", s.Noop != nil, s.Member != nil, s.Slice != nil)
+	var child *Selector
+	switch {
+	case s.Member != nil:
+		v.enter("member")
+		defer v.leave()
+		v.required("index", s.Member.Index)
+		child = s.Member.Child
+	case s.Slice != nil:
+		v.enter("slice")
+		defer v.leave()
+		if s.Slice.From != nil {
+			v.expr("from", s.Slice.From)
+		}
+		if s.Slice.To != nil {
+			v.expr("to", s.Slice.To)
+		}
+		child = s.Slice.Child
+	}
+	if child != nil && v.err == nil {
+		v.selector("child", child)
+	}
+}
+
+func (v *validator) unaryOperator(o *UnaryOperator) {
+	v.enter("unary_operator")
+	defer v.leave()
+	v.oneOf("operator", o.LogNot != nil)
+	v.required("arg", o.Arg)
+}
+
+func (v *validator) binaryOperator(o *BinaryOperator) {
+	v.enter("binary_operator")
+	defer v.leave()
+	v.oneOf("operator",
+		o.LogAnd != nil,
+		o.LogOr != nil,
+		o.NumAdd != nil,
+		o.NumSub != nil,
+		o.NumDiv != nil,
+		o.NumMul != nil,
+		o.NumMod != nil,
+		o.CmpEq != nil,
+		o.CmpNotEq != nil,
+		o.CmpGt != nil,
+		o.CmpGtOrEq != nil,
+		o.CmpLs != nil,
+		o.CmpLsOrEq != nil,
+	)
+	v.required("lhs", o.LHS)
+	v.required("rhs", o.RHS)
+}
+
+func (v *validator) funcCall(f *FuncCall) {
+	v.enter("func_call")
+	defer v.leave()
+	v.span(f.Span)
+	if f.Name == "" {
+		v.errorf("no function name")
+	}
+	for i, arg := range f.Args {
+		v.required(fmt.Sprintf("args[%d]", i), arg)
+	}
+}
+
+func (v *validator) span(s *Span) {
+	if s == nil {
+		return
+	}
+	switch {
+	case s.Start.Offset < 0 || s.Start.Line < 1 || s.Start.Column < 1:
+		v.errorf("span starts at an invalid position %d:%d, offset %d", s.Start.Line, s.Start.Column, s.Start.Offset)
+	case s.End.Offset < s.Start.Offset || s.End.Line < s.Start.Line:
+		v.errorf("span ends at %v, before it starts at %v", s.End, s.Start)
+	}
+}
@@ -0,0 +1,202 @@
+package ast_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aybabtme/streamql/lang/ast"
+)
+
+func TestMarshal(t *testing.T) {
+	queries := []string{
+		``,
+		`.`,
+		`.a[0][1:2][:3][4:][].b | ."c d"`,
+		`true | false | null | "s" | 1 | 2.5`,
+		`!(.a && .b || .c) == (1 + 2 - -3 * 4 / 5 >= .d)`,
+		`select(.a != 1, .b < 2) | length | has("x") | .[.a | .b]`,
+		`.a % 2 * 3`,
+	}
+	for _, query := range queries {
+		t.Run(query, func(t *testing.T) {
+			tree := mustParse(t, query)
+			data, err := ast.Marshal(tree)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ast.Unmarshal(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tree, got) {
+				t.Errorf("want=%s", ast.Format(tree))
+				t.Errorf(" got=%s", ast.Format(got))
+			}
+		})
+	}
+}
+
+func TestUnmarshalOldVersion(t *testing.T) {
+	// the trees of version 1 are the same, without the `%` operator
+	tree := mustParse(t, `select(.a != 1) | .b[0]`)
+	data, err := ast.Marshal(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = []byte(strings.Replace(string(data), `"version":2`, `"version":1`, 1))
+	got, err := ast.Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tree, got) {
+		t.Errorf("want=%s", ast.Format(tree))
+		t.Errorf(" got=%s", ast.Format(got))
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{
+			data: `{"version":3,"ast":{}}`,
+			want: `ast: can't decode tree: version 3 of the format isn't supported, only versions 1 to 2 are`,
+		},
+		{
+			data: `{"ast":{}}`,
+			want: `ast: can't decode tree: version 0 of the format isn't supported, only versions 1 to 2 are`,
+		},
+		{
+			data: `{"version":1}`,
+			want: `ast: invalid tree at ast: no tree`,
+		},
+		{
+			data: `{"version":1,"ast":{"expr":{"literal":{"bool":true}, "lol":1}}}`,
+			want: `ast: can't decode tree: json: unknown field "lol"`,
+		},
+		{
+			data: `{"version":1,"ast":{}} {}`,
+			want: `ast: can't decode tree: data after the tree`,
+		},
+		{
+			data: `{"version":1,"ast":{"expr":{}}}`,
+			want: `ast: invalid tree at ast.expr: no expression set`,
+		},
+		{
+			data: `{"version":1,"ast":{"expr":{"literal":{"bool":true},"selector":{"noop":{}}}}}`,
+			want: `ast: invalid tree at ast.expr: 2 expressions set, want only one`,
+		},
+		{
+			data: `{"version":1,"ast":{"expr":{"literal":{"bool":true},"next":{"literal":{}}}}}`,
+			want: `ast: invalid tree at ast.expr.next.literal: no literal value set`,
+		},
+		{
+			data: `{"version":1,"ast":{"expr":{"selector":{"member":{"index":{"literal":{"int64":1}},"child":{"member":{}}}}}}}`,
+			want: `ast: invalid tree at ast.expr.selector.member.child.member.index: no expression`,
+		},
+		{
+			data: `{"version":1,"ast":{"expr":{"binary_operator":{"lhs":{"literal":{"int64":1}},"rhs":{"literal":{"int64":1}}}}}}`,
+			want: `ast: invalid tree at ast.expr.binary_operator: no operator set`,
+		},
+		{
+			data: `{"version":1,"ast":{"expr":{"binary_operator":{"add":{},"lhs":{"literal":{"int64":1}}}}}}`,
+			want: `ast: invalid tree at ast.expr.binary_operator.rhs: no expression`,
+		},
+		{
+			data: `{"version":1,"ast":{"expr":{"unary_operator":{"arg":{"literal":{"bool":true}}}}}}`,
+			want: `ast: invalid tree at ast.expr.unary_operator: no operator set`,
+		},
+		{
+			data: `{"version":1,"ast":{"expr":{"func_call":{"args":[{"literal":{"bool":true}}]}}}}`,
+			want: `ast: invalid tree at ast.expr.func_call: no function name`,
+		},
+		{
+			data: `{"version":1,"ast":{"expr":{"func_call":{"name":"f","args":[{"selector":{"noop":{}}},null]}}}}`,
+			want: `ast: invalid tree at ast.expr.func_call.args[1]: no expression`,
+		},
+		{
+			data: `{"version":1,"ast":{"expr":{"selector":{"noop":{}},"span":{"start":{"offset":3,"line":1,"column":4},"end":{"offset":1,"line":1,"column":2}}}}}`,
+			want: `ast: invalid tree at ast.expr: span ends at 1:2, before it starts at 1:4`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.data, func(t *testing.T) {
+			tree, err := ast.Unmarshal([]byte(tt.data))
+			if err == nil {
+				t.Fatalf("want an error, got %s", ast.Format(tree))
+			}
+			if got := err.Error(); got != tt.want {
+				t.Errorf("want=%s", tt.want)
+				t.Errorf(" got=%s", got)
+			}
+		})
+	}
+}
+
+// TestSchema checks that the JSON schema describes the fields of the
+// types of the tree.
+func TestSchema(t *testing.T) {
+	data, err := ioutil.ReadFile("ast.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Properties struct {
+			Version struct {
+				Enum []int `json:"enum"`
+			} `json:"version"`
+		} `json:"properties"`
+		Defs map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+	// Unmarshal reads every version since the first
+	var versions []int
+	for v := 1; v <= ast.Version; v++ {
+		versions = append(versions, v)
+	}
+	if want, got := versions, schema.Properties.Version.Enum; !reflect.DeepEqual(want, got) {
+		t.Errorf("want versions %v in the schema, got %v", want, got)
+	}
+	types := map[string]interface{}{
+		"ast":             ast.AST{},
+		"pos":             ast.Pos{},
+		"span":            ast.Span{},
+		"expr":            ast.Expr{},
+		"literal":         ast.Literal{},
+		"selector":        ast.Selector{},
+		"member_selector": ast.MemberSelector{},
+		"slice_selector":  ast.SliceSelector{},
+		"func_call":       ast.FuncCall{},
+		"unary_operator":  ast.UnaryOperator{},
+		"binary_operator": ast.BinaryOperator{},
+	}
+	for name, v := range types {
+		def, ok := schema.Defs[name]
+		if !ok {
+			t.Errorf("%s: not in the schema", name)
+			continue
+		}
+		var want, got []string
+		typ := reflect.TypeOf(v)
+		for i := 0; i < typ.NumField(); i++ {
+			want = append(want, strings.Split(typ.Field(i).Tag.Get("json"), ",")[0])
+		}
+		for prop := range def.Properties {
+			got = append(got, prop)
+		}
+		sort.Strings(want)
+		sort.Strings(got)
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: want properties %q, got %q", name, want, got)
+		}
+	}
+}
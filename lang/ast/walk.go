@@ -0,0 +1,242 @@
+package ast
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Node is any node of a tree: *AST, *Expr, *Literal, *Selector,
+// *NoopSelector, *MemberSelector, *SliceSelector, *FuncCall,
+// *UnaryOperator or *BinaryOperator.
+type Node interface {
+	node()
+}
+
+func (*AST) node()            {}
+func (*Expr) node()           {}
+func (*Literal) node()        {}
+func (*Selector) node()       {}
+func (*NoopSelector) node()   {}
+func (*MemberSelector) node() {}
+func (*SliceSelector) node()  {}
+func (*FuncCall) node()       {}
+func (*UnaryOperator) node()  {}
+func (*BinaryOperator) node() {}
+
+// A Visitor's Visit method is called by Walk for each node it finds. If
+// the visitor w it returns isn't nil, Walk visits each of the children
+// of the node with w, then calls w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses a tree depth first, starting with node. The children
+// of an expression are visited before the rest of its pipeline, and
+// the children of a selector before the selector chained to it.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *AST:
+		if n.Expr != nil {
+			Walk(v, n.Expr)
+		}
+
+	case *Expr:
+		switch {
+		case n.Literal != nil:
+			Walk(v, n.Literal)
+		case n.Selector != nil:
+			Walk(v, n.Selector)
+		case n.UnaryOperator != nil:
+			Walk(v, n.UnaryOperator)
+		case n.BinaryOperator != nil:
+			Walk(v, n.BinaryOperator)
+		case n.FuncCall != nil:
+			Walk(v, n.FuncCall)
+		}
+		if n.Next != nil {
+			Walk(v, n.Next)
+		}
+
+	case *Selector:
+		switch {
+		case n.Noop != nil:
+			Walk(v, n.Noop)
+		case n.Member != nil:
+			Walk(v, n.Member)
+		case n.Slice != nil:
+			Walk(v, n.Slice)
+		}
+
+	case *MemberSelector:
+		if n.Index != nil {
+			Walk(v, n.Index)
+		}
+		if n.Child != nil {
+			Walk(v, n.Child)
+		}
+
+	case *SliceSelector:
+		if n.From != nil {
+			Walk(v, n.From)
+		}
+		if n.To != nil {
+			Walk(v, n.To)
+		}
+		if n.Child != nil {
+			Walk(v, n.Child)
+		}
+
+	case *FuncCall:
+		for _, arg := range n.Args {
+			Walk(v, arg)
+		}
+
+	case *UnaryOperator:
+		if n.Arg != nil {
+			Walk(v, n.Arg)
+		}
+
+	case *BinaryOperator:
+		if n.LHS != nil {
+			Walk(v, n.LHS)
+		}
+		if n.RHS != nil {
+			Walk(v, n.RHS)
+		}
+
+	case *Literal, *NoopSelector:
+		// no children
+
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
+	}
+
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses a tree in the same order as Walk. It calls f for
+// each node it finds, and visits the children of the node if f returns
+// true. Once the children are visited, f is called with nil.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// Rewrite traverses a tree in the same order as Walk, but calls f on
+// the children of a node before the node itself. The node returned by
+// f replaces the node it was given, so it must be of the same type.
+// Rewrite changes the tree in place and returns its new root.
+//
+// An expression is replaced along with the rest of its pipeline: to
+// keep the pipeline, the new expression must keep the Next of the old
+// one.
+func Rewrite(node Node, f func(Node) Node) Node {
+	switch n := node.(type) {
+	case *AST:
+		if n.Expr != nil {
+			n.Expr = rewriteExpr(n.Expr, f)
+		}
+
+	case *Expr:
+		switch {
+		case n.Literal != nil:
+			n.Literal = rewrite(n.Literal, f).(*Literal)
+		case n.Selector != nil:
+			n.Selector = rewriteSelector(n.Selector, f)
+		case n.UnaryOperator != nil:
+			n.UnaryOperator = rewrite(n.UnaryOperator, f).(*UnaryOperator)
+		case n.BinaryOperator != nil:
+			n.BinaryOperator = rewrite(n.BinaryOperator, f).(*BinaryOperator)
+		case n.FuncCall != nil:
+			n.FuncCall = rewrite(n.FuncCall, f).(*FuncCall)
+		}
+		if n.Next != nil {
+			n.Next = rewriteExpr(n.Next, f)
+		}
+
+	case *Selector:
+		switch {
+		case n.Noop != nil:
+			n.Noop = rewrite(n.Noop, f).(*NoopSelector)
+		case n.Member != nil:
+			n.Member = rewrite(n.Member, f).(*MemberSelector)
+		case n.Slice != nil:
+			n.Slice = rewrite(n.Slice, f).(*SliceSelector)
+		}
+
+	case *MemberSelector:
+		if n.Index != nil {
+			n.Index = rewriteExpr(n.Index, f)
+		}
+		if n.Child != nil {
+			n.Child = rewriteSelector(n.Child, f)
+		}
+
+	case *SliceSelector:
+		if n.From != nil {
+			n.From = rewriteExpr(n.From, f)
+		}
+		if n.To != nil {
+			n.To = rewriteExpr(n.To, f)
+		}
+		if n.Child != nil {
+			n.Child = rewriteSelector(n.Child, f)
+		}
+
+	case *FuncCall:
+		for i, arg := range n.Args {
+			n.Args[i] = rewriteExpr(arg, f)
+		}
+
+	case *UnaryOperator:
+		if n.Arg != nil {
+			n.Arg = rewriteExpr(n.Arg, f)
+		}
+
+	case *BinaryOperator:
+		if n.LHS != nil {
+			n.LHS = rewriteExpr(n.LHS, f)
+		}
+		if n.RHS != nil {
+			n.RHS = rewriteExpr(n.RHS, f)
+		}
+
+	case *Literal, *NoopSelector:
+		// no children
+
+	default:
+		panic(fmt.Sprintf("ast.Rewrite: unexpected node type %T", n))
+	}
+
+	return f(node)
+}
+
+// rewrite is Rewrite, but checks that the node was replaced by one of
+// the same type.
+func rewrite(node Node, f func(Node) Node) Node {
+	repl := Rewrite(node, f)
+	if reflect.TypeOf(repl) != reflect.TypeOf(node) {
+		panic(fmt.Sprintf("ast.Rewrite: %T replaced by %T", node, repl))
+	}
+	return repl
+}
+
+func rewriteExpr(e *Expr, f func(Node) Node) *Expr {
+	return rewrite(e, f).(*Expr)
+}
+
+func rewriteSelector(s *Selector, f func(Node) Node) *Selector {
+	return rewrite(s, f).(*Selector)
+}
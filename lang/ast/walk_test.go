@@ -0,0 +1,130 @@
+package ast_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aybabtme/streamql/lang/ast"
+)
+
+func TestInspect(t *testing.T) {
+	tree := mustParse(t, `.a[1:2].b | f(!.c, -1) | . + 1`)
+
+	var got []string
+	ast.Inspect(tree, func(n ast.Node) bool {
+		if n != nil {
+			got = append(got, strings.TrimPrefix(fmt.Sprintf("%T", n), "*ast."))
+		}
+		return true
+	})
+	want := []string{
+		"AST",
+		"Expr", "Selector", "MemberSelector",
+		"Expr", "Literal", // a
+		"Selector", "SliceSelector",
+		"Expr", "Literal", // 1
+		"Expr", "Literal", // 2
+		"Selector", "MemberSelector",
+		"Expr", "Literal", // b
+		"Expr", "FuncCall",
+		"Expr", "UnaryOperator",
+		"Expr", "Selector", "MemberSelector",
+		"Expr", "Literal", // c
+		"Expr", "BinaryOperator",
+		"Expr", "Literal", // 0
+		"Expr", "Literal", // 1
+		"Expr", "BinaryOperator",
+		"Expr", "Selector", "NoopSelector",
+		"Expr", "Literal", // 1
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want=%v", want)
+		t.Errorf(" got=%v", got)
+	}
+}
+
+func TestInspectSkipsChildren(t *testing.T) {
+	tree := mustParse(t, `f(1, 2) | g(3)`)
+
+	var calls []string
+	ast.Inspect(tree, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncCall:
+			calls = append(calls, n.Name)
+			return false
+		case *ast.Literal:
+			t.Errorf("visited literal %d in a function call", *n.Int)
+		}
+		return true
+	})
+	if want := []string{"f", "g"}; !reflect.DeepEqual(want, calls) {
+		t.Errorf("want=%v, got=%v", want, calls)
+	}
+}
+
+type depthVisitor struct {
+	depth, max *int
+}
+
+func (v depthVisitor) Visit(n ast.Node) ast.Visitor {
+	if n == nil {
+		*v.depth--
+		return nil
+	}
+	*v.depth++
+	if *v.depth > *v.max {
+		*v.max = *v.depth
+	}
+	return v
+}
+
+func TestWalk(t *testing.T) {
+	var depth, max int
+	ast.Walk(depthVisitor{&depth, &max}, mustParse(t, `.a.b`))
+	if depth != 0 {
+		t.Errorf("want every node to be left, got depth %d", depth)
+	}
+	// AST > Expr > Selector > MemberSelector > Selector > MemberSelector > Expr > Literal
+	if want := 8; max != want {
+		t.Errorf("want max depth %d, got %d", want, max)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	tree := mustParse(t, `.a | select(.b > 1 + 2) | .[1 + 2:]`)
+
+	// fold additions of integers, which must happen bottom-up
+	got := ast.Rewrite(tree, func(n ast.Node) ast.Node {
+		e, ok := n.(*ast.Expr)
+		if !ok || e.BinaryOperator == nil || e.BinaryOperator.NumAdd == nil {
+			return n
+		}
+		lhs, rhs := e.BinaryOperator.LHS.Literal, e.BinaryOperator.RHS.Literal
+		if lhs == nil || lhs.Int == nil || rhs == nil || rhs.Int == nil {
+			return n
+		}
+		sum := *lhs.Int + *rhs.Int
+		return &ast.Expr{Literal: &ast.Literal{Int: &sum}, Next: e.Next}
+	})
+
+	if want, got := `.a | select(.b > 3) | .[3:]`, ast.Format(got.(*ast.AST)); want != got {
+		t.Errorf("want=%s", want)
+		t.Errorf(" got=%s", got)
+	}
+}
+
+func TestRewriteWrongType(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("want a panic")
+		}
+	}()
+	ast.Rewrite(mustParse(t, `1`), func(n ast.Node) ast.Node {
+		if _, ok := n.(*ast.Literal); ok {
+			return &ast.Selector{Noop: &ast.NoopSelector{}}
+		}
+		return n
+	})
+}
@@ -0,0 +1,359 @@
+// Package check finds mistakes in a query before it runs, like calls
+// to functions that don't exist or operators used on messages of the
+// wrong type.
+package check
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/aybabtme/streamql/lang/ast"
+	"github.com/aybabtme/streamql/lang/msg"
+)
+
+// Error is a mistake found in a query.
+type Error struct {
+	// Span is where the mistake is in the query, if it is known.
+	Span *ast.Span
+	// Msg describes the mistake.
+	Msg string
+}
+
+func (e *Error) Error() string {
+	if e.Span == nil {
+		return e.Msg
+	}
+	return fmt.Sprintf("%v: %s", e.Span.Start, e.Msg)
+}
+
+// ErrorList is a list of mistakes found in a query, in the order they
+// appear in the query.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// Unwrap lets errors.As find the *Error in the list.
+func (l ErrorList) Unwrap() []error {
+	errs := make([]error, len(l))
+	for i, err := range l {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Funcs describes the function `name`, or returns false if there's no
+// such function.
+type Funcs func(name string) (*Func, bool)
+
+// Config of a check.
+type Config struct {
+	// Funcs tells which functions exist.
+	Funcs Funcs
+	// Schema describes the messages that the query will run on, if it
+	// is known.
+	Schema *Schema
+	// Limits bound the size of the query, if they are set. A query that
+	// goes past them isn't checked any further.
+	Limits *Limits
+}
+
+// Info is what was learned about a query while checking it.
+type Info struct {
+	// Types maps each expression of a query to the types of the
+	// messages it can emit. The types of an expression that starts a
+	// pipeline are those of its first stage.
+	Types map[*ast.Expr]Types
+}
+
+// Check looks for mistakes in a tree, using funcs to know which
+// functions exist. It returns an ErrorList if there are any.
+func Check(tree *ast.AST, funcs Funcs) error {
+	_, err := (&Config{Funcs: funcs}).Check(tree)
+	return err
+}
+
+// Check looks for mistakes in a tree, and infers the types of its
+// expressions. It returns an ErrorList if there are any mistakes.
+func (conf *Config) Check(tree *ast.AST) (*Info, error) {
+	c := &checker{
+		conf: conf,
+		info: &Info{Types: make(map[*ast.Expr]Types)},
+	}
+	if tree.Expr != nil && conf.Limits != nil {
+		c.limit(tree)
+	}
+	if tree.Expr != nil && len(c.errs) == 0 {
+		c.expr(tree.Expr, conf.Schema)
+	}
+	if len(c.errs) != 0 {
+		return c.info, c.errs
+	}
+	return c.info, nil
+}
+
+type checker struct {
+	conf *Config
+	info *Info
+	errs ErrorList
+}
+
+func (c *checker) errorf(span *ast.Span, format string, args ...interface{}) {
+	c.errs = append(c.errs, &Error{Span: span, Msg: fmt.Sprintf(format, args...)})
+}
+
+// expr infers what a pipeline starting with e emits, when it runs on
+// the messages described by in.
+func (c *checker) expr(e *ast.Expr, in *Schema) *Schema {
+	out := c.stage(e, in)
+	c.info.Types[e] = out.types()
+	if e.Next != nil {
+		return c.expr(e.Next, out)
+	}
+	return out
+}
+
+func (c *checker) stage(e *ast.Expr, in *Schema) *Schema {
+	switch {
+	case e.Literal != nil:
+		return &Schema{Types: literalType(e.Literal)}
+	case e.Selector != nil:
+		return c.selector(e.Selector, e.Span, in)
+	case e.UnaryOperator != nil:
+		c.operand(e.UnaryOperator.Arg, in, "argument of a logical not", TypesOf(msg.TypeBool))
+		return &Schema{Types: TypesOf(msg.TypeBool)}
+	case e.BinaryOperator != nil:
+		return &Schema{Types: c.binaryOperator(e.BinaryOperator, e.Span, in)}
+	case e.FuncCall != nil:
+		return c.funcCall(e.FuncCall, e.Span, in)
+	}
+	return nil
+}
+
+func literalType(l *ast.Literal) Types {
+	switch {
+	case l.Bool != nil:
+		return TypesOf(msg.TypeBool)
+	case l.String != nil:
+		return TypesOf(msg.TypeString)
+	case l.Int != nil:
+		return TypesOf(msg.TypeInt)
+	case l.Float != nil:
+		return TypesOf(msg.TypeFloat)
+	case l.Null != nil:
+		return TypesOf(msg.TypeNull)
+	}
+	return AnyType
+}
+
+// selector infers what s emits when it selects in the messages
+// described by in.
+func (c *checker) selector(s *ast.Selector, span *ast.Span, in *Schema) *Schema {
+	if s.Span != nil {
+		span = s.Span
+	}
+	var (
+		out   *Schema
+		child *ast.Selector
+	)
+	switch {
+	case s.Noop != nil:
+		return in
+
+	case s.Member != nil:
+		child = s.Member.Child
+		got := in.types()
+		if got&TypesOf(msg.TypeObject, msg.TypeArray) == 0 {
+			c.errorf(span, "index is not defined on %v (can be done on an object or an array)", got)
+			return &Schema{Types: AnyType}
+		}
+		var want Types
+		if got.Has(msg.TypeObject) {
+			want |= TypesOf(msg.TypeString)
+		}
+		if got.Has(msg.TypeArray) {
+			want |= TypesOf(msg.TypeInt)
+		}
+		c.operand(s.Member.Index, in, fmt.Sprintf("index of %v", got), want)
+		switch got {
+		case TypesOf(msg.TypeObject):
+			if lit := s.Member.Index.Literal; lit != nil && lit.String != nil {
+				out = in.member(*lit.String)
+			}
+		case TypesOf(msg.TypeArray):
+			out = in.elems()
+		}
+
+	case s.Slice != nil:
+		child = s.Slice.Child
+		if got := in.types(); !got.Has(msg.TypeArray) {
+			c.errorf(span, "slice is not defined on %v (can be done on an array)", got)
+			return &Schema{Types: AnyType}
+		}
+		if s.Slice.From != nil {
+			c.operand(s.Slice.From, in, "slice from", TypesOf(msg.TypeInt))
+		}
+		if s.Slice.To != nil {
+			c.operand(s.Slice.To, in, "slice to", TypesOf(msg.TypeInt))
+		}
+		out = in.elems()
+	}
+
+	if child != nil {
+		return c.selector(child, span, out)
+	}
+	if out == nil {
+		out = &Schema{}
+	}
+	return out
+}
+
+func (c *checker) funcCall(f *ast.FuncCall, span *ast.Span, in *Schema) *Schema {
+	if f.Span != nil {
+		span = f.Span
+	}
+	fn, ok := c.conf.Funcs(f.Name)
+	if !ok {
+		c.errorf(span, "unknown function %q", f.Name)
+		return &Schema{}
+	}
+	if !hasArity(fn, len(f.Args)) {
+		arityString := strconv.Itoa(fn.Arities[0])
+		for i, arity := range fn.Arities[1:] {
+			if i == len(fn.Arities)-2 {
+				arityString += " or "
+			} else {
+				arityString += ", "
+			}
+			arityString += strconv.Itoa(arity)
+		}
+		c.errorf(span, "function %q requires %s arguments, %d were given", f.Name, arityString, len(f.Args))
+		return &Schema{}
+	}
+
+	// the arguments that aren't given are the current message
+	implicit := fn.Implicit(len(f.Args))
+	var first *Schema
+	for i, want := range fn.Params {
+		var got *Schema
+		if i-implicit >= len(f.Args) {
+			// an optional argument that was left out
+			break
+		}
+		switch {
+		case i < implicit:
+			got = in
+			if types := got.types(); types&want == 0 {
+				c.errorf(span, "function %s is not defined on %v (can be done on %v)", f.Name, types, want)
+			}
+		case fn.OnElems(i):
+			elems := first.elems()
+			if fn.Generator && implicit == 0 {
+				elems = first
+			}
+			got = c.operand(f.Args[i-implicit], elems, "argument of function "+f.Name, want)
+		default:
+			got = c.operand(f.Args[i-implicit], in, "argument of function "+f.Name, want)
+		}
+		if i == 0 {
+			first = got
+		}
+	}
+	if fn.Passthrough {
+		if fn.Result == 0 {
+			return first
+		}
+		return first.filter(fn.Result)
+	}
+	if fn.Elem {
+		if elems := first.elems(); elems != nil {
+			return elems
+		}
+		return &Schema{}
+	}
+	return &Schema{Types: fn.Result}
+}
+
+func hasArity(fn *Func, n int) bool {
+	for _, arity := range fn.Arities {
+		if arity == n {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *checker) binaryOperator(o *ast.BinaryOperator, span *ast.Span, in *Schema) Types {
+	name, want := operands(o)
+	lhs := c.operand(o.LHS, in, "left of "+name, want).types() & want
+	rhs := c.operand(o.RHS, in, "right of "+name, want).types() & want
+
+	obj := TypesOf(msg.TypeObject)
+	switch {
+	case (o.NumAdd != nil || o.NumMul != nil) && (lhs == obj) != (rhs == obj) && lhs&rhs&obj == 0:
+		// objects are only merged with objects
+		c.errorf(span, "%s can't be between %v and %v", name, lhs, rhs)
+		return AnyType &^ TypesOf(msg.TypeNull)
+	case o.NumAdd != nil:
+		return additionType(lhs, rhs)
+	case o.NumMul != nil:
+		return multiplicationType(lhs, rhs)
+	case o.NumSub != nil, o.NumDiv != nil, o.NumMod != nil:
+		return arithmeticType(lhs, rhs)
+	}
+	return TypesOf(msg.TypeBool)
+}
+
+// operand checks that the expression e, used as the `what` of an
+// operator or function, can emit one of the types wanted. It returns
+// what e emits.
+func (c *checker) operand(e *ast.Expr, in *Schema, what string, want Types) *Schema {
+	got := c.expr(e, in)
+	if got.types()&want == 0 {
+		c.errorf(e.Span, "%s can't be %v (can be %v)", what, got.types(), want)
+		// don't report the same mistake again for what uses the operand
+		return &Schema{Types: want}
+	}
+	return got
+}
+
+// operands returns how an operator is described in errors, and the
+// types of operands it can work on.
+func operands(o *ast.BinaryOperator) (string, Types) {
+	switch {
+	case o.LogAnd != nil:
+		return "a logical and", TypesOf(msg.TypeBool)
+	case o.LogOr != nil:
+		return "a logical or", TypesOf(msg.TypeBool)
+	case o.NumAdd != nil:
+		return "an addition", numbers | TypesOf(msg.TypeString, msg.TypeObject)
+	case o.NumSub != nil:
+		return "a subtraction", numbers
+	case o.NumMul != nil:
+		return "a multiplication", numbers | TypesOf(msg.TypeObject)
+	case o.NumDiv != nil:
+		return "a division", numbers
+	case o.NumMod != nil:
+		return "a modulo", numbers
+	case o.CmpEq != nil:
+		return "an equality", AnyType
+	case o.CmpNotEq != nil:
+		return "a non-equality", AnyType
+	case o.CmpGt != nil:
+		return "a greater-than comparison", AnyType
+	case o.CmpGtOrEq != nil:
+		return "a greater-than-or-equal comparison", AnyType
+	case o.CmpLs != nil:
+		return "a less-than comparison", AnyType
+	case o.CmpLsOrEq != nil:
+		return "a less-than-or-equal comparison", AnyType
+	}
+	return "an operator", AnyType
+}
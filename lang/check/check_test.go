@@ -0,0 +1,276 @@
+package check_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aybabtme/streamql/lang/check"
+	"github.com/aybabtme/streamql/lang/grammar"
+	"github.com/aybabtme/streamql/lang/msg"
+	"github.com/aybabtme/streamql/lang/vm/astvm"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		args string
+		want []string
+	}{
+		{args: `.`},
+		{args: `.a | select(.b > 2) | length`},
+		{args: `"a" + 1 + 2.0`},
+		{args: `-1 * 2 / 3.0 - 1`},
+		{args: `. == "a" && !(.b < 2)`},
+		{args: `"a" > "b" || 1 >= 2.0`},
+		{args: `has(., "a") || contains("a", "b")`},
+		{args: `sub("a", "b") | gsub(., "a", "b") | sub(., "a", "b", "gi")`},
+		{args: `sub(1, "a", "b")`, want: []string{`1:5: argument of function sub can't be an int (can be a string)`}},
+		{args: `gsub(., "a", "b", 1)`, want: []string{`1:19: argument of function gsub can't be an int (can be a string)`}},
+		{args: `join(", ") | split(1)`, want: []string{`1:20: argument of function split can't be an int (can be a string)`}},
+		{
+			args: `foo(.a) | length(1, 2, 3)`,
+			want: []string{
+				`1:1: unknown function "foo"`,
+				`1:11: function "length" requires 0 or 1 arguments, 3 were given`,
+			},
+		},
+		{args: `regexp("a")`, want: []string{`1:1: function "regexp" requires 2 arguments, 1 were given`}},
+		{args: `select`, want: []string{`1:1: function "select" requires 1 or 2 arguments, 0 were given`}},
+		{args: `"a" - 1`, want: []string{`1:1: left of a subtraction can't be a string (can be an int or a float)`}},
+		{args: `1 * ("a" + 1)`, want: []string{`1:5: right of a multiplication can't be a string (can be an object, an int or a float)`}},
+		{args: `1 + true`, want: []string{`1:5: right of an addition can't be a bool (can be an object, a string, an int or a float)`}},
+		{args: `!1`, want: []string{`1:2: argument of a logical not can't be an int (can be a bool)`}},
+		{args: `1 && .a`, want: []string{`1:1: left of a logical and can't be an int (can be a bool)`}},
+		{args: `. == null`},
+		{args: `select(.x != null) | .x`},
+		{args: `"a" < 1`},
+		{args: `null < false`},
+		{args: `select(1 - "b" > 2) | . | !"c"`, want: []string{
+			`1:12: right of a subtraction can't be a string (can be an int or a float)`,
+			`1:28: argument of a logical not can't be a string (can be a bool)`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			tree, err := grammar.Parse(strings.NewReader(tt.args))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = check.Check(tree, astvm.Builtin)
+			var got []string
+			if errs, ok := err.(check.ErrorList); ok {
+				for _, err := range errs {
+					got = append(got, err.Error())
+				}
+			} else if err != nil {
+				t.Fatalf("want an ErrorList, got %T", err)
+			}
+			if !reflect.DeepEqual(tt.want, got) {
+				t.Errorf("want=%q", tt.want)
+				t.Errorf(" got=%q", got)
+			}
+		})
+	}
+}
+
+func TestErrorListUnwrap(t *testing.T) {
+	tree, err := grammar.Parse(strings.NewReader(`nope`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cerr *check.Error
+	if !errors.As(check.Check(tree, astvm.Builtin), &cerr) {
+		t.Fatal("want an *Error")
+	}
+	if cerr.Span == nil || cerr.Span.End.Offset != 4 {
+		t.Errorf("want the span of the call, got %v", cerr.Span)
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	var (
+		str = &check.Schema{Types: check.TypesOf(msg.TypeString)}
+		num = &check.Schema{Types: check.TypesOf(msg.TypeInt)}
+	)
+	schema := &check.Schema{
+		Types: check.TypesOf(msg.TypeObject),
+		Members: map[string]*check.Schema{
+			"count": num,
+			"name":  str,
+			"tags":  {Types: check.TypesOf(msg.TypeArray), Elems: str},
+			"items": {Types: check.TypesOf(msg.TypeArray), Elems: &check.Schema{
+				Types:   check.TypesOf(msg.TypeObject),
+				Members: map[string]*check.Schema{"price": num},
+			}},
+			"any": {},
+		},
+	}
+	tests := []struct {
+		args string
+		want []string
+	}{
+		{args: `.count - 1`},
+		{args: `.count + "x"`},
+		{args: `.name | length`},
+		{args: `.tags[0] + "x" | length`},
+		{args: `.tags[1:] < "b"`},
+		{args: `.any | .unknown - 1`},
+		{args: `select(.count > 2) | .name`},
+		{args: `.count - "x"`, want: []string{`1:10: right of a subtraction can't be a string (can be an int or a float)`}},
+		{args: `.name * 2`, want: []string{`1:1: left of a multiplication can't be a string (can be an object, an int or a float)`}},
+		{args: `.count | length`, want: []string{`1:10: function length is not defined on an int (can be done on an object, an array or a string)`}},
+		{args: `.tags.a`, want: []string{`1:7: index of an array can't be a string (can be an int)`}},
+		{args: `.name[0]`, want: []string{`1:6: index is not defined on a string (can be done on an object or an array)`}},
+		{args: `.[1:2]`, want: []string{`1:1: slice is not defined on an object (can be done on an array)`}},
+		{args: `.tags[0] - 1`, want: []string{`1:1: left of a subtraction can't be a string (can be an int or a float)`}},
+		{args: `. | keys | .[0]`, want: nil},
+		{args: `length > "a"`},
+		{args: `.items > .tags`},
+		{args: `. >= .any`},
+		{args: `.tags > .count`},
+		{args: `.items[0] + .items[1] | .price - 1`},
+		{args: `.items[0] * .any | .price - 1`},
+		{args: `.items[0] + .count`, want: []string{`1:1: an addition can't be between an object and an int`}},
+		{args: `.count * .items[0]`, want: []string{`1:1: a multiplication can't be between an int and an object`}},
+		{args: `to_entries | from_entries | keys_unsorted`},
+		{args: `pick(.count, .items) | .count`},
+		{args: `.any | objects | keys`},
+		{args: `.any | numbers | . - 1`},
+		{args: `.count | strings | length`},
+		{args: `.any | booleans | length`, want: []string{`1:19: function length is not defined on a bool (can be done on an object, an array or a string)`}},
+		{args: `.any | numbers | length`, want: []string{`1:18: function length is not defined on an int or a float (can be done on an object, an array or a string)`}},
+		{args: `type(.count) + "s"`},
+		{args: `isint - 1`, want: []string{`1:1: left of a subtraction can't be a bool (can be an int or a float)`}},
+		{args: `to_entries(.tags)`, want: []string{`1:12: argument of function to_entries can't be an array (can be an object)`}},
+		{args: `.tags | flatten(., 1)`},
+		{args: `.tags | flatten(1)`, want: []string{`1:17: argument of function flatten can't be an int (can be an array)`}},
+		{args: `min_by(.items, .price) | .price - 1`},
+		{args: `.items | max_by(.price * 2) | .price`},
+		{args: `min_by(.tags, .) | length`},
+		{args: `min_by(.tags, .price)`, want: []string{`1:15: index is not defined on a string (can be done on an object or an array)`}},
+		{args: `max_by(.tags, length) - 1`, want: []string{`1:1: left of a subtraction can't be a string (can be an int or a float)`}},
+		{args: `sort_by(.items, .price) | .[0].price - 1`},
+		{args: `any(.items[], .price > 1)`},
+		{args: `.items | all(.price > 1)`},
+		{args: `all(.tags[], . > "a")`},
+		{args: `all(.tags[], . - 1)`, want: []string{`1:14: left of a subtraction can't be a string (can be an int or a float)`, `1:14: argument of function all can't be an int or a float (can be a bool)`}},
+		{args: `map(.tags, . - 1)`, want: []string{`1:12: left of a subtraction can't be a string (can be an int or a float)`}},
+		{args: `.count % 2 - 1`},
+		{args: `.name % 2`, want: []string{`1:1: left of a modulo can't be a string (can be an int or a float)`}},
+		{args: `hash(.items) % 64 - 1`},
+		{args: `sha256(.count) - 1`, want: []string{`1:1: left of a subtraction can't be a string (can be an int or a float)`}},
+		{args: `select(in_cidr(.name, "10.0.0.0/8") && !is_private(.name)) | ip_version(.name) - 4`},
+		{args: `in_cidr(.count, "10.0.0.0/8")`, want: []string{`1:9: argument of function in_cidr can't be an int (can be a string)`}},
+		{args: `select(url_parse(.name) | .path | startswith("/api")) | .count`},
+		{args: `query_parse(.name) | keys | url_encode(.[0])`},
+		{args: `url_parse(.name) | length - 1`},
+		{args: `url_parse(.name) - 1`, want: []string{`1:1: left of a subtraction can't be an object (can be an int or a float)`}},
+		{args: `sort(.count)`, want: []string{`1:6: argument of function sort can't be an int (can be an array)`}},
+		{args: `select(fromdateiso8601(.name) > now - duration("5m")) | .count`},
+		{args: `strptime(.name, "%F") | mktime | todate | length`},
+		{args: `todate(.name)`, want: []string{`1:8: argument of function todate can't be a string (can be an array, an int or a float)`}},
+		{args: `now | length`, want: []string{`1:7: function length is not defined on a float (can be done on an object, an array or a string)`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			tree, err := grammar.Parse(strings.NewReader(tt.args))
+			if err != nil {
+				t.Fatal(err)
+			}
+			conf := &check.Config{Funcs: astvm.Builtin, Schema: schema}
+			_, err = conf.Check(tree)
+			var got []string
+			if errs, ok := err.(check.ErrorList); ok {
+				for _, err := range errs {
+					got = append(got, err.Error())
+				}
+			} else if err != nil {
+				t.Fatalf("want an ErrorList, got %T", err)
+			}
+			if !reflect.DeepEqual(tt.want, got) {
+				t.Errorf("want=%q", tt.want)
+				t.Errorf(" got=%q", got)
+			}
+		})
+	}
+}
+
+func TestCheckInfo(t *testing.T) {
+	tree, err := grammar.Parse(strings.NewReader(`.a | length + 1.5 | . > 2`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &check.Config{Funcs: astvm.Builtin}
+	info, err := conf.Check(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for e := tree.Expr; e != nil; e = e.Next {
+		got = append(got, info.Types[e].String())
+	}
+	want := []string{"anything", "a float", "a bool"}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want=%q", want)
+		t.Errorf(" got=%q", got)
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	tests := []struct {
+		args   string
+		limits check.Limits
+		want   []string
+	}{
+		{args: `.a | .b | .c`, limits: check.Limits{MaxDepth: 1}},
+		{args: `(.a + 1) * 2`, limits: check.Limits{MaxDepth: 3}},
+		{args: `(.a + 1) * 2`, limits: check.Limits{MaxDepth: 2}, want: []string{`1:2: expression is nested 3 deep, more than the limit of 2`}},
+		{args: `.a[.b[.c]]`, limits: check.Limits{MaxDepth: 2}, want: []string{`1:7: expression is nested 3 deep, more than the limit of 2`}},
+		{args: `.a.b`, limits: check.Limits{MaxNodes: 9}},
+		{args: `.a.b`, limits: check.Limits{MaxNodes: 8}, want: []string{`1:1: query has 9 nodes, more than the limit of 8`}},
+		{args: `regexp(.a, "abc")`, limits: check.Limits{MaxPatternSize: 3}},
+		{args: `regexp(.a, "abcd") || regexp(.b, "abcde")`, limits: check.Limits{MaxPatternSize: 3}, want: []string{
+			`1:12: pattern is 4 bytes long, more than the limit of 3`,
+			`1:34: pattern is 5 bytes long, more than the limit of 3`,
+		}},
+		{args: `sub("abcd", "") | sub(., "abc", "abcd", "g")`, limits: check.Limits{MaxPatternSize: 3}, want: []string{
+			`1:5: pattern is 4 bytes long, more than the limit of 3`,
+		}},
+		{args: `.a[] | .b[]`, limits: check.Limits{MaxGenerators: 2}},
+		{args: `.a[] + .b[] + .c[]`, limits: check.Limits{MaxGenerators: 1}},
+		{args: `.a[] | select(.b[] > 1)`, limits: check.Limits{MaxGenerators: 2}},
+		{args: `.a[] | select(.b[] > 1) | .c[1:]`, limits: check.Limits{MaxGenerators: 2}, want: []string{`1:29: 3 generators are nested, more than the limit of 2`}},
+		{args: `select(.b[] > 1) | .c[] | .d[]`, limits: check.Limits{MaxGenerators: 2}, want: []string{`1:29: 3 generators are nested, more than the limit of 2`}},
+		{args: `.a[] | scan(.b, "x")`, limits: check.Limits{MaxGenerators: 2}},
+		{args: `.a[] | scan(.b, "x")`, limits: check.Limits{MaxGenerators: 1}, want: []string{`1:8: 2 generators are nested, more than the limit of 1`}},
+		{args: `.a[] | splits(.b, ",") | .c[]`, limits: check.Limits{MaxGenerators: 2}, want: []string{`1:28: 3 generators are nested, more than the limit of 2`}},
+		{args: `map(.a, .b[]) | .c[]`, limits: check.Limits{MaxGenerators: 1}, want: []string{`1:19: 2 generators are nested, more than the limit of 1`}},
+		{args: `.[.a[]] | .b[]`, limits: check.Limits{MaxGenerators: 1}, want: []string{`1:13: 2 generators are nested, more than the limit of 1`}},
+		{args: `.a[] | .b[].c[]`, limits: check.Limits{MaxGenerators: 2}, want: []string{`1:14: 3 generators are nested, more than the limit of 2`}},
+		{args: `.a[] | .[.b[] | .c[]]`, limits: check.Limits{MaxGenerators: 2}, want: []string{`1:19: 3 generators are nested, more than the limit of 2`}},
+		{args: `nope((1 + 2) * 3)`, limits: check.Limits{MaxDepth: 2}, want: []string{`1:6: expression is nested 3 deep, more than the limit of 2`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			tree, err := grammar.Parse(strings.NewReader(tt.args))
+			if err != nil {
+				t.Fatal(err)
+			}
+			conf := &check.Config{Funcs: astvm.Builtin, Limits: &tt.limits}
+			_, err = conf.Check(tree)
+			var got []string
+			if errs, ok := err.(check.ErrorList); ok {
+				for _, err := range errs {
+					got = append(got, err.Error())
+				}
+			} else if err != nil {
+				t.Fatalf("want an ErrorList, got %T", err)
+			}
+			if !reflect.DeepEqual(tt.want, got) {
+				t.Errorf("want=%q", tt.want)
+				t.Errorf(" got=%q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package check
+
+import (
+	"github.com/aybabtme/streamql/lang/ast"
+)
+
+// Limits bound how big and how costly a query can be. A limit that is
+// zero isn't checked.
+type Limits struct {
+	// MaxDepth is how deep expressions can be nested in one another,
+	// as operands, arguments or indexes that aren't literals. The
+	// stages of a pipeline aren't nested: `.a | .b` has a depth of 1,
+	// and `(.a + 1) * 2` a depth of 3.
+	MaxDepth int
+	// MaxNodes is how many nodes the tree of a query can have, as
+	// visited by ast.Walk.
+	MaxNodes int
+	// MaxPatternSize is how long, in bytes, a regular expression given
+	// as a literal can be. Those that aren't literals are only known
+	// when the query runs, and aren't checked.
+	MaxPatternSize int
+	// MaxGenerators is how many generators can be nested in one
+	// another. A generator emits several messages for each one it gets,
+	// like the slice in `.[]` or `.[1:3]` or the function scan, and
+	// everything after it runs once for each of them: `.a[] | .b[]`
+	// nests 2 generators. So does `select(.a[] > 1) | .b[]`, as an
+	// expression with a generator in it is one too.
+	MaxGenerators int
+}
+
+// limiter finds where a query goes past its limits. It reports each
+// limit at most once, at the first place it's gone past.
+type limiter struct {
+	c      *checker
+	limits *Limits
+
+	depthErr, generatorsErr bool
+}
+
+func (c *checker) limit(tree *ast.AST) {
+	limits := c.conf.Limits
+	if max := limits.MaxNodes; max > 0 {
+		nodes := 0
+		ast.Inspect(tree.Expr, func(node ast.Node) bool {
+			if node != nil {
+				nodes++
+			}
+			return true
+		})
+		if nodes > max {
+			c.errorf(tree.Expr.Span, "query has %d nodes, more than the limit of %d", nodes, max)
+		}
+	}
+	l := &limiter{c: c, limits: limits}
+	l.expr(tree.Expr, 1, 0)
+}
+
+// expr checks the pipeline starting at e, whose first stage is at
+// depth and runs nested in as many generators. It returns how many
+// generators the last stage is nested in.
+func (l *limiter) expr(e *ast.Expr, depth, generators int) int {
+	for ; e != nil; e = e.Next {
+		if max := l.limits.MaxDepth; max > 0 && depth > max && !l.depthErr {
+			l.depthErr = true
+			l.c.errorf(e.Span, "expression is nested %d deep, more than the limit of %d", depth, max)
+		}
+		switch {
+		case e.Selector != nil:
+			generators = l.selector(e.Selector, e.Span, depth, generators)
+		case e.UnaryOperator != nil:
+			generators = l.expr(e.UnaryOperator.Arg, depth+1, generators)
+		case e.BinaryOperator != nil:
+			lhs := l.expr(e.BinaryOperator.LHS, depth+1, generators)
+			rhs := l.expr(e.BinaryOperator.RHS, depth+1, generators)
+			generators = maxInt(lhs, rhs)
+		case e.FuncCall != nil:
+			generators = l.funcCall(e.FuncCall, e.Span, depth, generators)
+		}
+	}
+	return generators
+}
+
+func (l *limiter) selector(s *ast.Selector, span *ast.Span, depth, generators int) int {
+	for s != nil {
+		if s.Span != nil {
+			span = s.Span
+		}
+		switch {
+		case s.Member != nil:
+			// the name of a member isn't nested in anything
+			if s.Member.Index.Literal == nil {
+				generators = maxInt(generators, l.expr(s.Member.Index, depth+1, generators))
+			}
+			s = s.Member.Child
+		case s.Slice != nil:
+			nested := generators
+			if s.Slice.From != nil {
+				nested = maxInt(nested, l.expr(s.Slice.From, depth+1, generators))
+			}
+			if s.Slice.To != nil {
+				nested = maxInt(nested, l.expr(s.Slice.To, depth+1, generators))
+			}
+			generators = nested
+			generators = l.generator(span, generators)
+			s = s.Slice.Child
+		default:
+			s = nil
+		}
+	}
+	return generators
+}
+
+// funcCall checks the call f and its arguments. It returns how many
+// generators what it emits is nested in: as many as the argument nested
+// in the most, and one more if the function is a generator itself.
+func (l *limiter) funcCall(f *ast.FuncCall, span *ast.Span, depth, generators int) int {
+	nested := generators
+	for _, arg := range f.Args {
+		nested = maxInt(nested, l.expr(arg, depth+1, generators))
+	}
+	fn, ok := l.c.conf.Funcs(f.Name)
+	if !ok {
+		return nested
+	}
+	if fn.Many {
+		nested = l.generator(span, nested)
+	}
+	max := l.limits.MaxPatternSize
+	if max <= 0 {
+		return nested
+	}
+	implicit := fn.Implicit(len(f.Args))
+	for _, i := range fn.Patterns {
+		if i < implicit || i-implicit >= len(f.Args) {
+			continue
+		}
+		pattern := f.Args[i-implicit]
+		if lit := pattern.Literal; lit != nil && lit.String != nil && pattern.Next == nil && len(*lit.String) > max {
+			l.c.errorf(pattern.Span, "pattern is %d bytes long, more than the limit of %d", len(*lit.String), max)
+		}
+	}
+	return nested
+}
+
+// generator counts one more generator than those it's nested in, and
+// returns how many there are.
+func (l *limiter) generator(span *ast.Span, generators int) int {
+	generators++
+	if max := l.limits.MaxGenerators; max > 0 && generators > max && !l.generatorsErr {
+		l.generatorsErr = true
+		l.c.errorf(span, "%d generators are nested, more than the limit of %d", generators, max)
+	}
+	return generators
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
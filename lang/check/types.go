@@ -0,0 +1,235 @@
+package check
+
+import (
+	"strings"
+
+	"github.com/aybabtme/streamql/lang/msg"
+)
+
+// Types is a set of the types that a message can have.
+type Types uint8
+
+var allTypes = []msg.Type{
+	msg.TypeObject, msg.TypeArray, msg.TypeString, msg.TypeInt,
+	msg.TypeFloat, msg.TypeBool, msg.TypeNull,
+}
+
+var (
+	// AnyType is the set of all the types.
+	AnyType = TypesOf(allTypes...)
+
+	numbers = TypesOf(msg.TypeInt, msg.TypeFloat)
+)
+
+// TypesOf returns the set of the types given.
+func TypesOf(ts ...msg.Type) Types {
+	var set Types
+	for _, t := range ts {
+		set |= 1 << t
+	}
+	return set
+}
+
+// Has tells if t is in the set.
+func (set Types) Has(t msg.Type) bool { return set&(1<<t) != 0 }
+
+// Types returns the types in the set.
+func (set Types) Types() []msg.Type {
+	var ts []msg.Type
+	for _, t := range allTypes {
+		if set.Has(t) {
+			ts = append(ts, t)
+		}
+	}
+	return ts
+}
+
+func (set Types) String() string {
+	if set == AnyType {
+		return "anything"
+	}
+	var names []string
+	for _, t := range set.Types() {
+		names = append(names, typeName(t))
+	}
+	switch len(names) {
+	case 0:
+		return "nothing"
+	case 1:
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+func typeName(t msg.Type) string {
+	switch t {
+	case msg.TypeObject:
+		return "an object"
+	case msg.TypeArray:
+		return "an array"
+	case msg.TypeString:
+		return "a string"
+	case msg.TypeInt:
+		return "an int"
+	case msg.TypeFloat:
+		return "a float"
+	case msg.TypeBool:
+		return "a bool"
+	case msg.TypeNull:
+		return "null"
+	}
+	return t.String()
+}
+
+// Schema describes the messages that a query runs on. A nil *Schema
+// means that nothing is known about them.
+type Schema struct {
+	// Types are the types the message can have. Zero means that it can
+	// be of any type.
+	Types Types
+	// Members describes the members of an object, by name. Nothing is
+	// known about the members that aren't listed.
+	Members map[string]*Schema
+	// Elems describes the elements of an array.
+	Elems *Schema
+}
+
+// types returns the types a message described by s can have.
+func (s *Schema) types() Types {
+	if s == nil || s.Types == 0 {
+		return AnyType
+	}
+	return s.Types
+}
+
+// filter is the schema of s when it's one of the types.
+func (s *Schema) filter(types Types) *Schema {
+	if s.types()&types == 0 {
+		return &Schema{Types: types}
+	}
+	out := &Schema{Types: s.types() & types}
+	if s != nil {
+		out.Members, out.Elems = s.Members, s.Elems
+	}
+	return out
+}
+
+func (s *Schema) member(name string) *Schema {
+	if s == nil {
+		return nil
+	}
+	return s.Members[name]
+}
+
+func (s *Schema) elems() *Schema {
+	if s == nil {
+		return nil
+	}
+	return s.Elems
+}
+
+// Func describes a function that a query can call.
+type Func struct {
+	// Arities are the numbers of arguments the function can be called
+	// with.
+	Arities []int
+	// Params are the types each argument can have when the function is
+	// called with the most arguments. When called with fewer, the last
+	// Optional arguments are left out first, then the first arguments
+	// are implicitly the current message.
+	Params []Types
+	// Optional is how many of the last Params can be left out.
+	Optional int
+	// Result are the types of what the function emits.
+	Result Types
+	// Passthrough is set if the function emits its first argument,
+	// instead of something of the Result types. If Result is set too,
+	// the first argument is only emitted when it's of those types.
+	Passthrough bool
+	// Patterns are the indexes in Params of the arguments that are
+	// regular expressions.
+	Patterns []int
+	// Each are the indexes in Params of the arguments that run on each
+	// element of the first argument, an array, instead of on the
+	// current message.
+	Each []int
+	// Generator is set if the first argument, when it's given, is a
+	// generator: the Each arguments run on each message it emits,
+	// instead of on the elements of an array.
+	Generator bool
+	// Elem is set if the function emits one of the elements of its
+	// first argument, instead of something of the Result types.
+	Elem bool
+	// Many is set if the function can emit several messages for each
+	// one it gets, like the slice in `.[]`.
+	Many bool
+	// Compares is set if the function compares the elements of its
+	// first argument whole, like sort, so that all of them are read
+	// even if only some of what it emits is.
+	Compares bool
+	// Groups is set if the function emits arrays of arrays of the
+	// elements of its first argument, like group_by, instead of
+	// something of the Result types.
+	Groups bool
+}
+
+// OnElems tells if the argument at index i in Params runs on each
+// element of the first argument, or on each message it emits if it's a
+// Generator.
+func (f *Func) OnElems(i int) bool {
+	for _, j := range f.Each {
+		if i == j {
+			return true
+		}
+	}
+	return false
+}
+
+// Implicit is how many of the first Params are implicitly the current
+// message when the function is called with n arguments.
+func (f *Func) Implicit(n int) int {
+	if implicit := len(f.Params) - f.Optional - n; implicit > 0 {
+		return implicit
+	}
+	return 0
+}
+
+// additionType is the type of an addition: numbers are added together,
+// anything added to a string is turned into a string, and objects are
+// merged.
+func additionType(lhs, rhs Types) Types {
+	var out Types
+	str := TypesOf(msg.TypeString)
+	if lhs.Has(msg.TypeString) && rhs&(numbers|str) != 0 ||
+		rhs.Has(msg.TypeString) && lhs&numbers != 0 {
+		out |= str
+	}
+	return out | mergeType(lhs, rhs) | arithmeticType(lhs, rhs)
+}
+
+// multiplicationType is the type of a multiplication: numbers are
+// multiplied together, and objects are merged.
+func multiplicationType(lhs, rhs Types) Types {
+	return mergeType(lhs, rhs) | arithmeticType(lhs, rhs)
+}
+
+// mergeType is the type of a merge of objects.
+func mergeType(lhs, rhs Types) Types {
+	if lhs.Has(msg.TypeObject) && rhs.Has(msg.TypeObject) {
+		return TypesOf(msg.TypeObject)
+	}
+	return 0
+}
+
+// arithmeticType is the type of an arithmetic operation on numbers: an
+// int if both sides are ints, a float otherwise.
+func arithmeticType(lhs, rhs Types) Types {
+	var out Types
+	if lhs.Has(msg.TypeInt) && rhs.Has(msg.TypeInt) {
+		out |= TypesOf(msg.TypeInt)
+	}
+	if lhs&numbers != 0 && rhs&numbers != 0 && (lhs.Has(msg.TypeFloat) || rhs.Has(msg.TypeFloat)) {
+		out |= TypesOf(msg.TypeFloat)
+	}
+	return out
+}
@@ -0,0 +1,114 @@
+package grammar
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// SyntaxError describes a mistake found while parsing a query.
+type SyntaxError struct {
+	// Offset is the byte offset of the offending token in the query.
+	Offset int
+	// Line and Column are the 1-based position of the offending
+	// token. Columns count runes, not bytes.
+	Line, Column int
+
+	// Token is the text of the offending token, or empty if the
+	// query ended too soon.
+	Token string
+	// Expected lists the tokens that would have been valid instead
+	// of Token, if they are known.
+	Expected []string
+	// Msg describes the mistake.
+	Msg string
+	// Snippet is the line of the query where the mistake is, with a
+	// caret under the offending token.
+	Snippet string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+// ErrorList is a list of mistakes found while parsing a query,
+// in the order they appear in the query.
+type ErrorList []*SyntaxError
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// Unwrap lets errors.As find the *SyntaxError in the list.
+func (l ErrorList) Unwrap() []error {
+	errs := make([]error, len(l))
+	for i, err := range l {
+		errs[i] = err
+	}
+	return errs
+}
+
+// describe returns a human readable name for a token, as used
+// in error messages.
+func describe(id string) string {
+	switch id {
+	case tokEOF:
+		return "end of query"
+	case tokNull:
+		return "null"
+	case tokBool:
+		return "boolean"
+	case tokIdentifier:
+		return "identifier"
+	case tokString:
+		return "string"
+	case tokInt:
+		return "integer"
+	case tokFloat:
+		return "float"
+	}
+	return fmt.Sprintf("%q", id)
+}
+
+// unexpected describes the offending token of an error.
+func unexpected(id, lit string) string {
+	switch id {
+	case tokEOF:
+		return "end of query"
+	case tokIdentifier, tokString, tokInt, tokFloat:
+		return fmt.Sprintf("%s %s", describe(id), lit)
+	}
+	return fmt.Sprintf("%q", lit)
+}
+
+// snippet returns the line of src that contains offset, followed by
+// a line with a caret under offset.
+func snippet(src string, offset int) string {
+	start := strings.LastIndexByte(src[:offset], '\n') + 1
+	end := strings.IndexByte(src[offset:], '\n')
+	if end < 0 {
+		end = len(src)
+	} else {
+		end += offset
+	}
+	line := src[start:end]
+
+	var buf bytes.Buffer
+	buf.WriteString(line)
+	buf.WriteByte('\n')
+	for _, r := range src[start:offset] {
+		if r == '\t' {
+			buf.WriteByte('\t')
+		} else {
+			buf.WriteByte(' ')
+		}
+	}
+	buf.WriteByte('^')
+	return buf.String()
+}
@@ -1,4 +0,0 @@
-package grammar
-
-//go:generate nex -o lexer.go lexer.nex
-//go:generate goyacc -o parser.go parser.y
@@ -88,6 +88,11 @@ func TestTokenize(t *testing.T) {
 			args: `/`,
 			want: []tok{{tokNumDiv, `/`}},
 		},
+		{
+			name: `tokNumMod`,
+			args: `%`,
+			want: []tok{{tokNumMod, `%`}},
+		},
 		{
 			name: `tokCmpEq`,
 			args: `==`,
@@ -125,6 +130,9 @@ func TestTokenize(t *testing.T) {
 		{`tokIdentifier`, `hello`, []tok{{tokIdentifier, `hello`}}},
 		{`tokIdentifier`, `hello_hello`, []tok{{tokIdentifier, `hello_hello`}}},
 		{`tokIdentifier`, `_hello01`, []tok{{tokIdentifier, `_hello01`}}},
+		{`tokIdentifier`, `héllo`, []tok{{tokIdentifier, `héllo`}}},
+		{`tokIdentifier`, `日本語`, []tok{{tokIdentifier, `日本語`}}},
+		{`tokIdentifier`, `ключ_2`, []tok{{tokIdentifier, `ключ_2`}}},
 		{
 			name: `true bool`,
 			args: `true`,
@@ -159,6 +167,17 @@ func TestTokenize(t *testing.T) {
 		{`-1.0`, "-1.0", []tok{{tokNumSub, "-"}, {tokFloat, "1.0"}}},
 		{`-42.42`, "-42.42", []tok{{tokNumSub, "-"}, {tokFloat, "42.42"}}},
 
+		{`hex`, "0x1F", []tok{{tokInt, "0x1F"}}},
+		{`hex`, "0XdeadBEEF", []tok{{tokInt, "0XdeadBEEF"}}},
+		{`underscores`, "1_000_000", []tok{{tokInt, "1_000_000"}}},
+		{`underscores`, "0x_ff", []tok{{tokInt, "0"}, {tokIdentifier, "x_ff"}}},
+		{`exponent`, "1e9", []tok{{tokFloat, "1e9"}}},
+		{`exponent`, "1E-9", []tok{{tokFloat, "1E-9"}}},
+		{`exponent`, "4.2e+10", []tok{{tokFloat, "4.2e+10"}}},
+		{`leading dot`, ".5", []tok{{tokFloat, ".5"}}},
+		{`leading dot`, ".5e3", []tok{{tokFloat, ".5e3"}}},
+		{`underscores`, "1_000.000_1", []tok{{tokFloat, "1_000.000_1"}}},
+
 		{`1.0-1.0`, "1.0-1.0", []tok{
 			{tokFloat, "1.0"},
 			{tokNumSub, "-"},
@@ -196,6 +215,12 @@ func TestTokenize(t *testing.T) {
 			want: []tok{{tokString, `"hello \"world\",\r\x00\u12af\U12afAF12"`}},
 		},
 
+		{
+			name: `quoted member`,
+			args: `."x-request-id"`,
+			want: []tok{{tokDot, `.`}, {tokString, `"x-request-id"`}},
+		},
+
 		{
 			name: `actual query`,
 			args: `.hello[0:1] | select(.is_red && .size == "large")`,
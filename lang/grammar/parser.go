@@ -1,879 +1,794 @@
-//line parser.y:2
 package grammar
 
-import __yyfmt__ "fmt"
-
-//line parser.y:2
 import (
-	"github.com/aybabtme/streamql/lang/ast"
+	"fmt"
 	"io"
-)
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"unicode/utf8"
 
-var implicitSliceIdx = struct{}{}
-
-//line parser.y:55
-type yySymType struct {
-	yys  int
-	node interface{}
-
-	curID int
-	cur   tok
-	err   error
-}
-
-const Dot = 57346
-const LeftBracket = 57347
-const RightBracket = 57348
-const LeftParens = 57349
-const RightParens = 57350
-const Colon = 57351
-const Pipe = 57352
-const Comma = 57353
-const Null = 57354
-const Bool = 57355
-const Identifier = 57356
-const String = 57357
-const Int = 57358
-const Float = 57359
-const LogOr = 57360
-const LogAnd = 57361
-const LogNot = 57362
-const CmpEq = 57363
-const CmpNotEq = 57364
-const CmpGt = 57365
-const CmpGtOrEq = 57366
-const CmpLs = 57367
-const CmpLsOrEq = 57368
-const NumAdd = 57369
-const NumSub = 57370
-const NumMul = 57371
-const NumDiv = 57372
-
-var yyToknames = [...]string{
-	"$end",
-	"error",
-	"$unk",
-	"Dot",
-	"LeftBracket",
-	"RightBracket",
-	"LeftParens",
-	"RightParens",
-	"Colon",
-	"Pipe",
-	"Comma",
-	"Null",
-	"Bool",
-	"Identifier",
-	"String",
-	"Int",
-	"Float",
-	"LogOr",
-	"LogAnd",
-	"LogNot",
-	"CmpEq",
-	"CmpNotEq",
-	"CmpGt",
-	"CmpGtOrEq",
-	"CmpLs",
-	"CmpLsOrEq",
-	"NumAdd",
-	"NumSub",
-	"NumMul",
-	"NumDiv",
-}
-var yyStatenames = [...]string{}
-
-const yyEofCode = 1
-const yyErrCode = 2
-const yyInitialStackSize = 16
-
-//line parser.y:125
-
-func cast(y yyLexer) *ast.AST { return y.(*Lexer).parseResult.(*ast.AST) }
-
-func Parse(r io.Reader) (tree *ast.AST, err error) {
-	tree = new(ast.AST)
-	lex := NewLexerWithInit(r, func(l *Lexer) { l.parseResult = tree })
-	// defer func() {
-	//     r := recover()
-	//     if r != nil {
-	//         err = fmt.Errorf("%v", r)
-	//     }
-	// }()
-	yyParse(lex)
-	return tree, err
-}
-
-//line yacctab:1
-var yyExca = [...]int{
-	-1, 1,
-	1, -1,
-	-2, 0,
-	-1, 46,
-	21, 0,
-	22, 0,
-	-2, 37,
-	-1, 47,
-	21, 0,
-	22, 0,
-	-2, 38,
-	-1, 48,
-	23, 0,
-	24, 0,
-	25, 0,
-	26, 0,
-	-2, 39,
-	-1, 49,
-	23, 0,
-	24, 0,
-	25, 0,
-	26, 0,
-	-2, 40,
-	-1, 50,
-	23, 0,
-	24, 0,
-	25, 0,
-	26, 0,
-	-2, 41,
-	-1, 51,
-	23, 0,
-	24, 0,
-	25, 0,
-	26, 0,
-	-2, 42,
-}
-
-const yyPrivate = 57344
-
-const yyLast = 378
-
-var yyAct = [...]int{
-
-	52, 60, 23, 61, 2, 19, 62, 25, 26, 27,
-	28, 29, 30, 21, 22, 24, 23, 70, 33, 36,
-	37, 59, 39, 40, 41, 42, 43, 44, 45, 46,
-	47, 48, 49, 50, 51, 18, 56, 21, 22, 24,
-	23, 24, 23, 20, 19, 38, 25, 26, 27, 28,
-	29, 30, 21, 22, 24, 23, 66, 58, 64, 7,
-	6, 69, 5, 72, 73, 22, 24, 23, 77, 76,
-	53, 54, 78, 81, 32, 82, 35, 4, 34, 83,
-	87, 88, 3, 31, 1, 90, 91, 92, 74, 0,
-	93, 75, 18, 0, 0, 0, 0, 0, 0, 0,
-	20, 19, 0, 25, 26, 27, 28, 29, 30, 21,
-	22, 24, 23, 67, 0, 0, 68, 18, 0, 0,
-	0, 0, 0, 0, 0, 20, 19, 0, 25, 26,
-	27, 28, 29, 30, 21, 22, 24, 23, 89, 0,
-	0, 0, 18, 0, 0, 0, 0, 0, 0, 0,
-	20, 19, 0, 25, 26, 27, 28, 29, 30, 21,
-	22, 24, 23, 86, 0, 0, 0, 18, 0, 0,
-	0, 0, 0, 0, 0, 20, 19, 0, 25, 26,
-	27, 28, 29, 30, 21, 22, 24, 23, 85, 0,
-	0, 0, 18, 0, 0, 0, 0, 0, 0, 0,
-	20, 19, 0, 25, 26, 27, 28, 29, 30, 21,
-	22, 24, 23, 80, 0, 0, 0, 18, 0, 0,
-	0, 0, 0, 0, 0, 20, 19, 0, 25, 26,
-	27, 28, 29, 30, 21, 22, 24, 23, 18, 71,
-	0, 0, 0, 0, 0, 0, 20, 19, 0, 25,
-	26, 27, 28, 29, 30, 21, 22, 24, 23, 13,
-	0, 63, 15, 0, 65, 0, 0, 12, 8, 17,
-	9, 10, 11, 0, 0, 14, 0, 0, 0, 0,
-	0, 0, 0, 16, 25, 26, 27, 28, 29, 30,
-	21, 22, 24, 23, 13, 0, 55, 15, 0, 57,
-	0, 0, 12, 8, 17, 9, 10, 11, 0, 0,
-	14, 13, 0, 84, 15, 0, 0, 0, 16, 12,
-	8, 17, 9, 10, 11, 0, 0, 14, 13, 0,
-	79, 15, 0, 0, 0, 16, 12, 8, 17, 9,
-	10, 11, 0, 0, 14, 13, 0, 0, 15, 0,
-	0, 0, 16, 12, 8, 17, 9, 10, 11, 0,
-	0, 14, 0, 0, 0, 0, 0, 0, 0, 16,
-	27, 28, 29, 30, 21, 22, 24, 23,
-}
-var yyPact = [...]int{
-
-	341, -1000, 25, -1000, -1000, -1000, -1000, -1000, -1000, -1000,
-	-1000, -1000, -1000, 69, 341, 341, 341, 38, 341, 341,
-	341, 341, 341, 341, 341, 341, 341, 341, 341, 341,
-	341, 66, 290, 263, 49, 13, 25, 12, 341, 25,
-	263, -14, 37, 12, -1000, -28, 347, 347, 10, 10,
-	10, 10, -1000, -8, 255, 66, 107, 341, -1000, -1000,
-	9, 228, 66, 66, 82, 341, -1000, 66, 324, 207,
-	-1000, 341, -1000, -1000, 66, 307, 182, -1000, 157, 66,
-	66, -1000, -1000, 132, 66, 66, 66, -1000, -1000, 66,
-	-1000, -1000, -1000, -1000,
-}
-var yyPgo = [...]int{
-
-	0, 84, 3, 82, 77, 62, 60, 59, 0, 1,
-}
-var yyR1 = [...]int{
-
-	0, 1, 1, 2, 2, 2, 2, 2, 2, 3,
-	3, 3, 3, 3, 4, 4, 4, 4, 4, 4,
-	4, 8, 8, 8, 8, 8, 8, 8, 5, 5,
-	6, 6, 6, 6, 6, 6, 6, 6, 6, 6,
-	6, 6, 6, 6, 7, 7, 9, 9,
-}
-var yyR2 = [...]int{
-
-	0, 1, 0, 1, 1, 1, 1, 1, 3, 1,
-	1, 1, 1, 1, 1, 3, 4, 5, 7, 6,
-	6, 3, 3, 4, 6, 5, 5, 0, 2, 3,
-	3, 3, 2, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 3, 4, 1, 1, 3,
-}
-var yyChk = [...]int{
-
-	-1000, -1, -2, -3, -4, -5, -6, -7, 13, 15,
-	16, 17, 12, 4, 20, 7, 28, 14, 10, 19,
-	18, 27, 28, 30, 29, 21, 22, 23, 24, 25,
-	26, 14, 5, -2, -5, -6, -2, -2, 7, -2,
-	-2, -2, -2, -2, -2, -2, -2, -2, -2, -2,
-	-2, -2, -8, 4, 5, 6, -2, 9, 8, 8,
-	-9, -2, 14, 6, -2, 9, -8, 6, 9, -2,
-	8, 11, -8, -8, 6, 9, -2, -8, -2, 6,
-	6, -9, -8, -2, 6, 6, 6, -8, -8, 6,
-	-8, -8, -8, -8,
-}
-var yyDef = [...]int{
-
-	2, -2, 1, 3, 4, 5, 6, 7, 9, 10,
-	11, 12, 13, 14, 0, 0, 0, 45, 0, 0,
-	0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-	0, 27, 0, 28, 5, 6, 0, 32, 0, 8,
-	30, 31, 33, 34, 35, 36, -2, -2, -2, -2,
-	-2, -2, 15, 0, 0, 27, 0, 0, 29, 43,
-	0, 46, 27, 27, 0, 0, 16, 27, 0, 0,
-	44, 0, 21, 22, 27, 0, 0, 17, 0, 27,
-	27, 47, 23, 0, 27, 27, 27, 20, 19, 27,
-	26, 25, 18, 24,
-}
-var yyTok1 = [...]int{
-
-	1,
-}
-var yyTok2 = [...]int{
-
-	2, 3, 4, 5, 6, 7, 8, 9, 10, 11,
-	12, 13, 14, 15, 16, 17, 18, 19, 20, 21,
-	22, 23, 24, 25, 26, 27, 28, 29, 30,
-}
-var yyTok3 = [...]int{
-	0,
-}
-
-var yyErrorMessages = [...]struct {
-	state int
-	token int
-	msg   string
-}{}
-
-//line yaccpar:1
-
-/*	parser for yacc output	*/
-
-var (
-	yyDebug        = 0
-	yyErrorVerbose = false
+	"github.com/aybabtme/streamql/lang/ast"
 )
 
-type yyLexer interface {
-	Lex(lval *yySymType) int
-	Error(s string)
+// Parse reads a query and returns its syntax tree. If the query
+// is invalid, the error is an ErrorList of all the mistakes that
+// were found.
+func Parse(r io.Reader) (*ast.AST, error) {
+	return ParseWithLimits(r, 0, 0)
 }
 
-type yyParser interface {
-	Parse(yyLexer) int
-	Lookahead() int
+// ParseWithLimits is like Parse, but gives up on the query as soon as
+// it finds that it's nested deeper than maxDepth, or has more than
+// maxNodes nodes, unless they're zero. They're counted as they are by
+// check.Limits, so that a query that's too big for them is rejected
+// before all of it is parsed. The parser doesn't see every place where
+// a query goes past them, so those that are found are checked again
+// after.
+func ParseWithLimits(r io.Reader, maxDepth, maxNodes int) (*ast.AST, error) {
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := newParser(string(src))
+	p.maxDepth, p.maxNodes = maxDepth, maxNodes
+	tree := p.parse()
+	if len(p.errs) != 0 {
+		sort.SliceStable(p.errs, func(i, j int) bool { return p.errs[i].Offset < p.errs[j].Offset })
+		return nil, p.errs
+	}
+	return tree, nil
 }
 
-type yyParserImpl struct {
-	lval  yySymType
-	stack [yyInitialStackSize]yySymType
-	char  int
+// precedence of the binary operators, from the loosest to the
+// tightest. Oddly, each arithmetic operator has its own level: `a + b
+// - c` is `a + (b - c)`.
+const (
+	precNone = iota
+	precPipe // right associative
+	precOr
+	precAnd
+	precNot // of the prefix operator
+	precEq  // non associative
+	precCmp // non associative
+	precAdd
+	precSub // also of the prefix operator
+	precMul
+	precDiv
+	precMod
+)
+
+// maxNesting is about how deep a query can nest expressions,
+// operators and selectors in one another. The parser, and the passes
+// over the tree after it, recurse that deep, and a query nested too
+// deeply would overflow their stack.
+const maxNesting = 10000
+
+func binaryPrec(id string) int {
+	switch id {
+	case tokPipe:
+		return precPipe
+	case tokLogOr:
+		return precOr
+	case tokLogAnd:
+		return precAnd
+	case tokCmpEq, tokCmpNotEq:
+		return precEq
+	case tokCmpGt, tokCmpGtOrEq, tokCmpLs, tokCmpLsOrEq:
+		return precCmp
+	case tokNumAdd:
+		return precAdd
+	case tokNumSub:
+		return precSub
+	case tokNumMul:
+		return precMul
+	case tokNumDiv:
+		return precDiv
+	case tokNumMod:
+		return precMod
+	}
+	return precNone
 }
 
-func (p *yyParserImpl) Lookahead() int {
-	return p.char
+// parser reads a query by recursive descent. It goes on after a
+// mistake to find more of them, and reports each one with the tokens
+// that were expected instead.
+type parser struct {
+	src  string
+	lex  *lexer
+	errs ErrorList
+
+	// byte offset at which each line of src starts
+	lines []int
+	// whether src is all ASCII, so that columns are byte offsets
+	ascii bool
+
+	tok  lexeme // the token being looked at
+	prev lexeme // the token before it
+	ntok int    // the index of tok
+
+	// the operators being parsed, innermost last
+	frames []frame
+
+	// the tokens that could have followed the last operand, in place
+	// of the token at index afterAt: those that extend the operand,
+	// and the operators
+	afterAt   int
+	extend    []string
+	operators []string
+	// the operators of the precedence noted and above were noted
+	noted int
+
+	// the tokens that can end a context before it has anything in it,
+	// if the token at index emptyAt does
+	emptyAt int
+	empty   []string
+
+	// offset of the last syntax error, so that a token isn't
+	// reported twice
+	lastErr int
+
+	// how deep the node being parsed is nested, and whether parsing
+	// was given up on because of it or of the limits
+	nesting int
+	aborted bool
+
+	// the depth of the expression being parsed and the number of nodes
+	// parsed so far, as check.Limits counts them, and their limits
+	depth, maxDepth int
+	nodes, maxNodes int
 }
 
-func yyNewParser() yyParser {
-	return &yyParserImpl{}
+// frame is an expression being parsed, whose operators all have at
+// least the precedence min.
+type frame struct {
+	min int
+	// the precedence of the non associative operator that was just
+	// parsed in this frame, if any
+	nonassoc int
 }
 
-const yyFlag = -1000
+func newParser(src string) *parser {
+	p := &parser{
+		src:     src,
+		lines:   []int{0},
+		afterAt: -1,
+		emptyAt: -1,
+		lastErr: -1,
+		depth:   1,
+	}
+	p.ascii = true
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\n' {
+			p.lines = append(p.lines, i+1)
+		}
+		if src[i] >= utf8.RuneSelf {
+			p.ascii = false
+		}
+	}
+	p.lex = &lexer{src: src, errorf: func(offset int, token, format string, args ...interface{}) {
+		p.errs = append(p.errs, p.syntaxError(offset, fmt.Sprintf(format, args...), token, nil))
+	}}
+	return p
+}
+
+func (p *parser) next() {
+	p.prev = p.tok
+	if !p.aborted {
+		p.tok = p.lex.next()
+	}
+	p.ntok++
+}
 
-func yyTokname(c int) string {
-	if c >= 1 && c-1 < len(yyToknames) {
-		if yyToknames[c-1] != "" {
-			return yyToknames[c-1]
+func (p *parser) parse() *ast.AST {
+	tree := new(ast.AST)
+	p.next()
+	if p.tok.id == tokEOF {
+		return tree
+	}
+	p.expectEmpty(tokEOF)
+	tree.Expr = p.expr(precNone)
+	for p.tok.id != tokEOF {
+		p.unexpected(tokEOF)
+		// look for more mistakes in the next stages of the pipeline
+		p.next()
+		p.skipTo(tokPipe)
+		if p.tok.id == tokPipe {
+			p.next()
+			p.expr(precNone)
 		}
 	}
-	return __yyfmt__.Sprintf("tok-%v", c)
+	return tree
 }
 
-func yyStatname(s int) string {
-	if s >= 0 && s < len(yyStatenames) {
-		if yyStatenames[s] != "" {
-			return yyStatenames[s]
+// expr parses an expression whose operators have at least the
+// precedence min.
+func (p *parser) expr(min int) *ast.Expr {
+	p.frames = append(p.frames, frame{min: min})
+	nesting := p.nesting
+	defer func() {
+		p.frames = p.frames[:len(p.frames)-1]
+		p.nesting = nesting
+	}()
+	if !p.nest() {
+		return badExpr()
+	}
+
+	first := p.tok
+	start := first.pos
+	lhs := p.operand()
+	wraps := 0
+	for {
+		prec := binaryPrec(p.tok.id)
+		if prec == precNone || prec < min {
+			p.noteOperators(p.frames[len(p.frames)-1])
+			return lhs
+		}
+		op := p.tok
+		f := &p.frames[len(p.frames)-1]
+		if prec == f.nonassoc {
+			kind := "equalities"
+			if prec == precCmp {
+				kind = "comparisons"
+			}
+			p.errorf(op, "syntax error: unexpected %q, %s can't follow each other without parentheses", op.lit, kind)
+		}
+		p.next()
+		var rhs *ast.Expr
+		if prec == precPipe {
+			rhs = p.expr(prec)
+		} else {
+			rhs = p.deeper(prec + 1)
+		}
+		f = &p.frames[len(p.frames)-1]
+		f.nonassoc = precNone
+		if prec == precEq || prec == precCmp {
+			f.nonassoc = prec
+		}
+		lhs = p.binaryOperator(op, lhs, rhs, start)
+		if op.id == tokPipe {
+			continue
+		}
+		// the operands on the left nest one deeper each time
+		wraps++
+		if !p.nest() || !p.checkDepth(first, p.depth+wraps) {
+			return lhs
 		}
 	}
-	return __yyfmt__.Sprintf("state-%v", s)
 }
 
-func yyErrorMessage(state, lookAhead int) string {
-	const TOKSTART = 4
+// deeper parses an expression that is nested in the one being parsed,
+// as an operand or an argument.
+func (p *parser) deeper(min int) *ast.Expr {
+	p.depth++
+	defer func() { p.depth-- }()
+	return p.expr(min)
+}
 
-	if !yyErrorVerbose {
-		return "syntax error"
+// checkDepth gives up on the query if the expression starting at the
+// token lx is nested deeper than the limit, at depth. It returns
+// whether parsing goes on.
+func (p *parser) checkDepth(lx lexeme, depth int) bool {
+	if max := p.maxDepth; max > 0 && depth > max && !p.aborted {
+		p.abortAt(lx, "expression is nested %d deep, more than the limit of %d", depth, max)
 	}
+	return !p.aborted
+}
 
-	for _, e := range yyErrorMessages {
-		if e.state == state && e.token == lookAhead {
-			return "syntax error: " + e.msg
-		}
+// grow counts n more nodes in the tree, and gives up on the query if it
+// has more than the limit. It returns whether parsing goes on.
+func (p *parser) grow(n int) bool {
+	p.nodes += n
+	if max := p.maxNodes; max > 0 && p.nodes > max && !p.aborted {
+		p.abort("query has more nodes than the limit of %d", max)
 	}
+	return !p.aborted
+}
 
-	res := "syntax error: unexpected " + yyTokname(lookAhead)
+// nest notes that the parser goes one level deeper into the tree, and
+// gives up on the query if it's nested too deeply. It returns whether
+// parsing goes on.
+func (p *parser) nest() bool {
+	p.nesting++
+	if p.nesting > maxNesting && !p.aborted {
+		p.abort("syntax error: query is nested more than %d deep", maxNesting)
+	}
+	return !p.aborted
+}
 
-	// To match Bison, suggest at most four expected tokens.
-	expected := make([]int, 0, 4)
+// abort reports a mistake at the current token that parsing can't go
+// on after. The rest of the query is skipped, and no more mistakes
+// are reported.
+func (p *parser) abort(format string, args ...interface{}) {
+	p.abortAt(p.tok, format, args...)
+}
 
-	// Look for shiftable tokens.
-	base := yyPact[state]
-	for tok := TOKSTART; tok-1 < len(yyToknames); tok++ {
-		if n := base + tok; n >= 0 && n < yyLast && yyChk[yyAct[n]] == tok {
-			if len(expected) == cap(expected) {
-				return res
-			}
-			expected = append(expected, tok)
+// abortAt is like abort, but reports the mistake at the token lx.
+func (p *parser) abortAt(lx lexeme, format string, args ...interface{}) {
+	p.errorf(lx, format, args...)
+	p.aborted = true
+	// end the query there
+	p.tok = lexeme{tok: tok{id: tokEOF}, pos: p.tok.pos, end: p.tok.pos}
+}
+
+// noteOperators notes which operators of the frame f could have been
+// found in place of the current token. The frames note theirs from the
+// innermost out, each one those that bind tighter than the operators
+// of the frames further out.
+func (p *parser) noteOperators(f frame) {
+	if p.afterAt != p.ntok {
+		p.afterAt, p.extend, p.operators = p.ntok, nil, nil
+		p.noted = precMod + 1
+	}
+	for _, id := range binaryOperators {
+		prec := binaryPrec(id)
+		if prec >= f.min && prec < p.noted && prec != f.nonassoc {
+			p.operators = append(p.operators, id)
 		}
 	}
+	if f.min < p.noted {
+		p.noted = f.min
+	}
+}
 
-	if yyDef[state] == -2 {
-		i := 0
-		for yyExca[i] != -1 || yyExca[i+1] != state {
-			i += 2
-		}
+// extendWith notes the tokens that could have extended the operand
+// that was just parsed.
+func (p *parser) extendWith(ids ...string) {
+	p.afterAt, p.extend, p.operators = p.ntok, ids, nil
+	p.noted = precMod + 1
+}
 
-		// Look for tokens that we accept or reduce.
-		for i += 2; yyExca[i] >= 0; i += 2 {
-			tok := yyExca[i]
-			if tok < TOKSTART || yyExca[i+1] == 0 {
-				continue
-			}
-			if len(expected) == cap(expected) {
-				return res
-			}
-			expected = append(expected, tok)
-		}
+// expectEmpty notes the tokens that can be found in place of the
+// expression that is about to be parsed.
+func (p *parser) expectEmpty(ids ...string) {
+	p.emptyAt, p.empty = p.ntok, ids
+}
 
-		// If the default action is to accept or reduce, give up.
-		if yyExca[i+1] != 0 {
-			return res
-		}
+func (p *parser) binaryOperator(op lexeme, lhs, rhs *ast.Expr, start int) *ast.Expr {
+	if op.id == tokPipe {
+		lhs.Next = rhs
+		return lhs
 	}
+	p.grow(2)
+	o := &ast.BinaryOperator{LHS: lhs, RHS: rhs}
+	switch op.id {
+	case tokLogOr:
+		o.LogOr = &ast.OpLogOr{}
+	case tokLogAnd:
+		o.LogAnd = &ast.OpLogAnd{}
+	case tokCmpEq:
+		o.CmpEq = &ast.OpCmpEq{}
+	case tokCmpNotEq:
+		o.CmpNotEq = &ast.OpCmpNotEq{}
+	case tokCmpGt:
+		o.CmpGt = &ast.OpCmpGt{}
+	case tokCmpGtOrEq:
+		o.CmpGtOrEq = &ast.OpCmpGtOrEq{}
+	case tokCmpLs:
+		o.CmpLs = &ast.OpCmpLs{}
+	case tokCmpLsOrEq:
+		o.CmpLsOrEq = &ast.OpCmpLsOrEq{}
+	case tokNumAdd:
+		o.NumAdd = &ast.OpNumAdd{}
+	case tokNumSub:
+		o.NumSub = &ast.OpNumSub{}
+	case tokNumMul:
+		o.NumMul = &ast.OpNumMul{}
+	case tokNumDiv:
+		o.NumDiv = &ast.OpNumDiv{}
+	case tokNumMod:
+		o.NumMod = &ast.OpNumMod{}
+	}
+	return &ast.Expr{BinaryOperator: o, Span: p.span(start, p.prev.end)}
+}
 
-	for i, tok := range expected {
-		if i == 0 {
-			res += ", expecting "
-		} else {
-			res += " or "
+// operand parses what an operator applies to: anything but an
+// operation with a binary operator, unless it's in parentheses.
+func (p *parser) operand() *ast.Expr {
+	start := p.tok
+	switch start.id {
+	case tokBool, tokString, tokInt, tokFloat, tokNull:
+		// a literal that is the index of a member isn't nested in it,
+		// so its depth isn't checked here
+	default:
+		if !p.checkDepth(start, p.depth) {
+			return badExpr()
 		}
-		res += yyTokname(tok)
 	}
-	return res
-}
+	switch start.id {
+	case tokLogNot:
+		p.next()
+		p.grow(2)
+		arg := p.deeper(precNot + 1)
+		return &ast.Expr{
+			UnaryOperator: &ast.UnaryOperator{Arg: arg, LogNot: &ast.OpLogNot{}},
+			Span:          p.span(start.pos, p.prev.end),
+		}
+
+	case tokNumSub:
+		// `-x` is read as `0 - x`
+		p.next()
+		p.grow(4)
+		arg := p.deeper(precSub + 1)
+		zero := int64(0)
+		return &ast.Expr{
+			BinaryOperator: &ast.BinaryOperator{
+				LHS:    &ast.Expr{Literal: &ast.Literal{Int: &zero}},
+				RHS:    arg,
+				NumSub: &ast.OpNumSub{},
+			},
+			Span: p.span(start.pos, p.prev.end),
+		}
+
+	case tokLeftParens:
+		return p.parens()
+
+	case tokBool, tokString, tokInt, tokFloat, tokNull:
+		p.next()
+		p.grow(2)
+		p.extendWith()
+		return &ast.Expr{Literal: p.literal(start), Span: p.span(start.pos, start.end)}
+
+	case tokDot:
+		return p.selector()
+
+	case tokIdentifier:
+		return p.funcCall()
+	}
 
-func yylex1(lex yyLexer, lval *yySymType) (char, token int) {
-	token = 0
-	char = lex.Lex(lval)
-	if char <= 0 {
-		token = yyTok1[0]
-		goto out
+	expected := exprStart
+	if p.emptyAt == p.ntok {
+		expected = append(p.empty, exprStart...)
 	}
-	if char < len(yyTok1) {
-		token = yyTok1[char]
-		goto out
+	p.unexpected(expected...)
+	return badExpr()
+}
+
+// badExpr stands in for an expression that couldn't be parsed, so
+// that parsing can go on and find more mistakes.
+func badExpr() *ast.Expr {
+	return &ast.Expr{Selector: &ast.Selector{Noop: &ast.NoopSelector{}}}
+}
+
+func isBad(e *ast.Expr) bool { return e.Span == nil }
+
+// parens parses an operator in parentheses. Nothing else can be put in
+// parentheses.
+func (p *parser) parens() *ast.Expr {
+	start := p.tok
+	p.next()
+	inner := p.expr(precPipe + 1)
+
+	isOperator := inner.UnaryOperator != nil || inner.BinaryOperator != nil
+	switch {
+	case p.tok.id != tokRightParens:
+		p.unexpected(tokRightParens)
+		p.skipTo(tokRightParens)
+	case !isOperator && !isBad(inner):
+		p.errorf(p.tok, "syntax error: unexpected %q, only an operator can be put in parentheses", p.tok.lit)
 	}
-	if char >= yyPrivate {
-		if char < yyPrivate+len(yyTok2) {
-			token = yyTok2[char-yyPrivate]
-			goto out
-		}
+	if p.tok.id == tokRightParens {
+		p.next()
 	}
-	for i := 0; i < len(yyTok3); i += 2 {
-		token = yyTok3[i+0]
-		if token == char {
-			token = yyTok3[i+1]
-			goto out
-		}
+	p.extendWith()
+	inner.Span = p.span(start.pos, p.prev.end)
+	return inner
+}
+
+func (p *parser) literal(lx lexeme) *ast.Literal {
+	switch lx.id {
+	case tokBool:
+		v := lx.lit == "true"
+		return &ast.Literal{Bool: &v}
+	case tokString:
+		v := p.unquote(lx)
+		return &ast.Literal{String: &v}
+	case tokInt:
+		// decimal and hexadecimal, with optional underscores between
+		// digits
+		v, err := strconv.ParseInt(lx.lit, 0, 64)
+		if err != nil {
+			p.fail(lx, invalidNumber("integer", lx.lit, err))
+		}
+		return &ast.Literal{Int: &v}
+	case tokFloat:
+		v, err := strconv.ParseFloat(lx.lit, 64)
+		if err != nil {
+			p.fail(lx, invalidNumber("float", lx.lit, err))
+		}
+		return &ast.Literal{Float: &v}
 	}
+	return &ast.Literal{Null: &struct{}{}}
+}
 
-out:
-	if token == 0 {
-		token = yyTok2[1] /* unknown char */
+func (p *parser) unquote(lx lexeme) string {
+	if lx.bad {
+		return ""
 	}
-	if yyDebug >= 3 {
-		__yyfmt__.Printf("lex %s(%d)\n", yyTokname(token), uint(char))
+	v, err := strconv.Unquote(lx.lit)
+	if err != nil {
+		p.fail(lx, fmt.Errorf("invalid string literal %s", lx.lit))
 	}
-	return char, token
+	return v
 }
 
-func yyParse(yylex yyLexer) int {
-	return yyNewParser().Parse(yylex)
+func invalidNumber(kind, lit string, err error) error {
+	if numErr, ok := err.(*strconv.NumError); ok {
+		err = numErr.Err
+	}
+	return fmt.Errorf("invalid %s literal %q: %v", kind, lit, err)
 }
 
-func (yyrcvr *yyParserImpl) Parse(yylex yyLexer) int {
-	var yyn int
-	var yyVAL yySymType
-	var yyDollar []yySymType
-	_ = yyDollar // silence set and not used
-	yyS := yyrcvr.stack[:]
+// selector parses a selector, starting at its dot.
+func (p *parser) selector() *ast.Expr {
+	dot := p.tok
+	p.next()
+	p.grow(1)
+	var sel *ast.Selector
+	switch p.tok.id {
+	case tokIdentifier, tokString, tokLeftBracket:
+		sel = p.subSelector(dot.pos)
+	default:
+		p.grow(2)
+		p.extendWith(tokIdentifier, tokString, tokLeftBracket)
+		sel = &ast.Selector{Noop: &ast.NoopSelector{}, Span: p.span(dot.pos, dot.end)}
+	}
+	return &ast.Expr{Selector: sel, Span: p.span(dot.pos, p.prev.end)}
+}
 
-	Nerrs := 0   /* number of errors */
-	Errflag := 0 /* error recovery flag */
-	yystate := 0
-	yyrcvr.char = -1
-	yytoken := -1 // yyrcvr.char translated into internal numbering
-	defer func() {
-		// Make sure we report no lookahead when not parsing.
-		yystate = -1
-		yyrcvr.char = -1
-		yytoken = -1
-	}()
-	yyp := -1
-	goto yystack
-
-ret0:
-	return 0
-
-ret1:
-	return 1
-
-yystack:
-	/* put a state and value onto the stack */
-	if yyDebug >= 4 {
-		__yyfmt__.Printf("char %v in %v\n", yyTokname(yytoken), yyStatname(yystate))
-	}
-
-	yyp++
-	if yyp >= len(yyS) {
-		nyys := make([]yySymType, len(yyS)*2)
-		copy(nyys, yyS)
-		yyS = nyys
-	}
-	yyS[yyp] = yyVAL
-	yyS[yyp].yys = yystate
-
-yynewstate:
-	yyn = yyPact[yystate]
-	if yyn <= yyFlag {
-		goto yydefault /* simple state */
-	}
-	if yyrcvr.char < 0 {
-		yyrcvr.char, yytoken = yylex1(yylex, &yyrcvr.lval)
-	}
-	yyn += yytoken
-	if yyn < 0 || yyn >= yyLast {
-		goto yydefault
-	}
-	yyn = yyAct[yyn]
-	if yyChk[yyn] == yytoken { /* valid shift */
-		yyrcvr.char = -1
-		yytoken = -1
-		yyVAL = yyrcvr.lval
-		yystate = yyn
-		if Errflag > 0 {
-			Errflag--
+// subSelector parses a member or a slice, and the selectors chained to
+// it. The selector starts at the offset start, which is that of its dot
+// if it has one.
+func (p *parser) subSelector(start int) *ast.Selector {
+	defer func(nesting int) { p.nesting = nesting }(p.nesting)
+	sel := new(ast.Selector)
+	if !p.nest() || !p.grow(2) {
+		sel.Noop = &ast.NoopSelector{}
+		return sel
+	}
+	var child **ast.Selector
+	if p.tok.id == tokLeftBracket {
+		sel, child = p.brackets()
+	} else {
+		name := p.tok
+		p.next()
+		p.grow(2)
+		index := &ast.Expr{Span: p.span(name.pos, name.end)}
+		if name.id == tokIdentifier {
+			index.Literal = &ast.Literal{String: &name.lit}
+		} else {
+			index.Literal = p.literal(name)
 		}
-		goto yystack
+		sel.Member = &ast.MemberSelector{Index: index}
+		child = &sel.Member.Child
 	}
 
-yydefault:
-	/* default state action */
-	yyn = yyDef[yystate]
-	if yyn == -2 {
-		if yyrcvr.char < 0 {
-			yyrcvr.char, yytoken = yylex1(yylex, &yyrcvr.lval)
+	switch p.tok.id {
+	case tokDot:
+		dot := p.tok
+		p.next()
+		if p.tok.id == tokIdentifier || p.tok.id == tokString {
+			*child = p.subSelector(dot.pos)
+		} else {
+			p.unexpected(tokIdentifier, tokString)
+		}
+	case tokLeftBracket:
+		*child = p.subSelector(p.tok.pos)
+	default:
+		p.extendWith(tokDot, tokLeftBracket)
+	}
+	sel.Span = p.span(start, p.prev.end)
+	return sel
+}
+
+// brackets parses what's in brackets after a selector: `[]`, `[i]`,
+// `[from:to]`, `[from:]` or `[:to]`. It returns where to put the
+// selector chained to it.
+func (p *parser) brackets() (*ast.Selector, **ast.Selector) {
+	p.next()
+	var (
+		sel   = new(ast.Selector)
+		slice = new(ast.SliceSelector)
+	)
+	switch p.tok.id {
+	case tokRightBracket:
+		p.next()
+		sel.Slice = slice
+		return sel, &slice.Child
+
+	case tokColon:
+		p.next()
+		slice.To = p.deeper(precNone)
+		p.closeBrackets()
+		sel.Slice = slice
+		return sel, &slice.Child
+	}
+
+	p.expectEmpty(tokRightBracket, tokColon)
+	index := p.deeper(precNone)
+	switch p.tok.id {
+	case tokColon:
+		p.next()
+		slice.From = index
+		if p.tok.id != tokRightBracket {
+			p.expectEmpty(tokRightBracket)
+			slice.To = p.deeper(precNone)
+		}
+		p.closeBrackets()
+		sel.Slice = slice
+		return sel, &slice.Child
+	case tokRightBracket:
+		p.next()
+	default:
+		p.unexpected(tokRightBracket, tokColon)
+		p.skipTo(tokRightBracket)
+		if p.tok.id == tokRightBracket {
+			p.next()
 		}
+	}
+	sel.Member = &ast.MemberSelector{Index: index}
+	return sel, &sel.Member.Child
+}
+
+func (p *parser) closeBrackets() {
+	if p.tok.id != tokRightBracket {
+		p.unexpected(tokRightBracket)
+		p.skipTo(tokRightBracket)
+	}
+	if p.tok.id == tokRightBracket {
+		p.next()
+	}
+}
 
-		/* look through exception table */
-		xi := 0
+// funcCall parses a call to a function, with arguments in parentheses
+// or without any.
+func (p *parser) funcCall() *ast.Expr {
+	name := p.tok
+	p.next()
+	p.grow(2)
+	call := &ast.FuncCall{Name: name.lit}
+	if p.tok.id != tokLeftParens {
+		p.extendWith(tokLeftParens)
+	} else {
+		p.next()
 		for {
-			if yyExca[xi+0] == -1 && yyExca[xi+1] == yystate {
-				break
+			call.Args = append(call.Args, p.deeper(precNone))
+			if p.tok.id == tokComma {
+				p.next()
+				continue
 			}
-			xi += 2
-		}
-		for xi += 2; ; xi += 2 {
-			yyn = yyExca[xi+0]
-			if yyn < 0 || yyn == yytoken {
-				break
+			if p.tok.id != tokRightParens {
+				p.unexpected(tokComma, tokRightParens)
+				p.skipTo(tokComma, tokRightParens)
+				if p.tok.id == tokComma {
+					p.next()
+					continue
+				}
 			}
+			if p.tok.id == tokRightParens {
+				p.next()
+			}
+			break
 		}
-		yyn = yyExca[xi+1]
-		if yyn < 0 {
-			goto ret0
-		}
+		p.extendWith()
 	}
-	if yyn == 0 {
-		/* error ... attempt to resume parsing */
-		switch Errflag {
-		case 0: /* brand new error */
-			yylex.Error(yyErrorMessage(yystate, yytoken))
-			Nerrs++
-			if yyDebug >= 1 {
-				__yyfmt__.Printf("%s", yyStatname(yystate))
-				__yyfmt__.Printf(" saw %s\n", yyTokname(yytoken))
-			}
-			fallthrough
-
-		case 1, 2: /* incompletely recovered error ... try again */
-			Errflag = 3
-
-			/* find a state where "error" is a legal shift action */
-			for yyp >= 0 {
-				yyn = yyPact[yyS[yyp].yys] + yyErrCode
-				if yyn >= 0 && yyn < yyLast {
-					yystate = yyAct[yyn] /* simulate a shift of "error" */
-					if yyChk[yystate] == yyErrCode {
-						goto yystack
-					}
-				}
+	call.Span = p.span(name.pos, p.prev.end)
+	return &ast.Expr{FuncCall: call, Span: p.span(name.pos, p.prev.end)}
+}
 
-				/* the current p has no shift on "error", pop stack */
-				if yyDebug >= 2 {
-					__yyfmt__.Printf("error recovery pops state %d\n", yyS[yyp].yys)
+// skipTo skips tokens up to one of those given that isn't nested in
+// parentheses or brackets, or up to the end of the query.
+func (p *parser) skipTo(ids ...string) {
+	depth := 0
+	for ; p.tok.id != tokEOF; p.next() {
+		if depth == 0 {
+			for _, id := range ids {
+				if p.tok.id == id {
+					return
 				}
-				yyp--
-			}
-			/* there is no state on the stack with an error shift ... abort */
-			goto ret1
-
-		case 3: /* no shift yet; clobber input char */
-			if yyDebug >= 2 {
-				__yyfmt__.Printf("error recovery discards %s\n", yyTokname(yytoken))
 			}
-			if yytoken == yyEofCode {
-				goto ret1
+		}
+		switch p.tok.id {
+		case tokLeftParens, tokLeftBracket:
+			depth++
+		case tokRightParens, tokRightBracket:
+			if depth > 0 {
+				depth--
 			}
-			yyrcvr.char = -1
-			yytoken = -1
-			goto yynewstate /* try again in the same state */
 		}
 	}
+}
 
-	/* reduction by production yyn */
-	if yyDebug >= 2 {
-		__yyfmt__.Printf("reduce %v in:\n\t%v\n", yyn, yyStatname(yystate))
+// unexpected reports the current token, which isn't one of those
+// given, nor one that could have followed the last operand.
+func (p *parser) unexpected(ids ...string) {
+	want := make(map[string]bool)
+	for _, id := range ids {
+		want[id] = true
+	}
+	if p.afterAt == p.ntok {
+		for _, id := range p.extend {
+			want[id] = true
+		}
+		for _, id := range p.operators {
+			want[id] = true
+		}
+	}
+	var expected []string
+	for _, id := range terminals {
+		if want[id] {
+			expected = append(expected, describe(id))
+		}
 	}
 
-	yynt := yyn
-	yypt := yyp
-	_ = yypt // guard against "declared and not used"
+	if p.aborted {
+		return
+	}
+	msg := "syntax error: unexpected " + unexpected(p.tok.id, p.tok.lit)
+	for i, want := range expected {
+		switch i {
+		case 0:
+			msg += ", expected "
+		case len(expected) - 1:
+			msg += " or "
+		default:
+			msg += ", "
+		}
+		msg += want
+	}
+	if p.tok.pos == p.lastErr {
+		return
+	}
+	p.lastErr = p.tok.pos
+	p.errs = append(p.errs, p.syntaxError(p.tok.pos, msg, p.tok.lit, expected))
+}
 
-	yyp -= yyR2[yyn]
-	// yyp is now the index of $0. Perform the default action. Iff the
-	// reduced production is ε, $1 is possibly out of range.
-	if yyp+1 >= len(yyS) {
-		nyys := make([]yySymType, len(yyS)*2)
-		copy(nyys, yyS)
-		yyS = nyys
+// errorf reports a syntax error at a token, unless there's already
+// one there.
+func (p *parser) errorf(lx lexeme, format string, args ...interface{}) {
+	if lx.pos == p.lastErr || p.aborted {
+		return
 	}
-	yyVAL = yyS[yyp+1]
+	p.lastErr = lx.pos
+	p.errs = append(p.errs, p.syntaxError(lx.pos, fmt.Sprintf(format, args...), lx.lit, nil))
+}
 
-	/* consult goto table to find next state */
-	yyn = yyR1[yyn]
-	yyg := yyPgo[yyn]
-	yyj := yyg + yyS[yyp].yys + 1
+// fail reports a token that is well formed, but invalid.
+func (p *parser) fail(lx lexeme, err error) {
+	if p.aborted {
+		return
+	}
+	p.errs = append(p.errs, p.syntaxError(lx.pos, err.Error(), lx.lit, nil))
+}
 
-	if yyj >= yyLast {
-		yystate = yyAct[yyg]
-	} else {
-		yystate = yyAct[yyj]
-		if yyChk[yystate] != -yyn {
-			yystate = yyAct[yyg]
-		}
+func (p *parser) syntaxError(offset int, msg, token string, expected []string) *SyntaxError {
+	line, col := p.position(offset)
+	return &SyntaxError{
+		Offset:   offset,
+		Line:     line,
+		Column:   col,
+		Token:    token,
+		Expected: expected,
+		Msg:      msg,
+		Snippet:  snippet(p.src, offset),
 	}
-	// dummy call; replaced with literal code
-	switch yynt {
+}
 
-	case 1:
-		yyDollar = yyS[yypt-1 : yypt+1]
-		//line parser.y:64
-		{
-			cast(yylex).Expr = expr(yyDollar[1])
-		}
-	case 3:
-		yyDollar = yyS[yypt-1 : yypt+1]
-		//line parser.y:67
-		{
-			yyVAL = literal(yyDollar[1])
-		}
-	case 4:
-		yyDollar = yyS[yypt-1 : yypt+1]
-		//line parser.y:68
-		{
-			yyVAL = selector(yyDollar[1])
-		}
-	case 5:
-		yyDollar = yyS[yypt-1 : yypt+1]
-		//line parser.y:69
-		{
-			yyVAL = unaryOperator(yyDollar[1])
-		}
-	case 6:
-		yyDollar = yyS[yypt-1 : yypt+1]
-		//line parser.y:70
-		{
-			yyVAL = binaryOperator(yyDollar[1])
-		}
-	case 7:
-		yyDollar = yyS[yypt-1 : yypt+1]
-		//line parser.y:71
-		{
-			yyVAL = funcCall(yyDollar[1])
-		}
-	case 8:
-		yyDollar = yyS[yypt-3 : yypt+1]
-		//line parser.y:72
-		{
-			yyVAL = pipe(yyDollar[1], yyDollar[3])
-		}
-	case 9:
-		yyDollar = yyS[yypt-1 : yypt+1]
-		//line parser.y:75
-		{
-			yyVAL = emitBool(yyDollar[1])
-		}
-	case 10:
-		yyDollar = yyS[yypt-1 : yypt+1]
-		//line parser.y:76
-		{
-			yyVAL = emitString(yyDollar[1])
-		}
-	case 11:
-		yyDollar = yyS[yypt-1 : yypt+1]
-		//line parser.y:77
-		{
-			yyVAL = emitInt(yyDollar[1])
-		}
-	case 12:
-		yyDollar = yyS[yypt-1 : yypt+1]
-		//line parser.y:78
-		{
-			yyVAL = emitFloat(yyDollar[1])
-		}
-	case 13:
-		yyDollar = yyS[yypt-1 : yypt+1]
-		//line parser.y:79
-		{
-			yyVAL = emitNull(yyDollar[1])
-		}
-	case 14:
-		yyDollar = yyS[yypt-1 : yypt+1]
-		//line parser.y:82
-		{
-			yyVAL = emitNopSelector()
-		}
-	case 15:
-		yyDollar = yyS[yypt-3 : yypt+1]
-		//line parser.y:83
-		{
-			yyVAL = emitMemberSelector(yyDollar[2], yyDollar[3])
-		}
-	case 16:
-		yyDollar = yyS[yypt-4 : yypt+1]
-		//line parser.y:84
-		{
-			yyVAL = emitSliceSelectorEach(yyDollar[4])
-		}
-	case 17:
-		yyDollar = yyS[yypt-5 : yypt+1]
-		//line parser.y:85
-		{
-			yyVAL = emitMemberSelector(yyDollar[3], yyDollar[5])
-		}
-	case 18:
-		yyDollar = yyS[yypt-7 : yypt+1]
-		//line parser.y:86
-		{
-			yyVAL = emitSliceSelector(yyDollar[3], yyDollar[5], yyDollar[7])
-		}
-	case 19:
-		yyDollar = yyS[yypt-6 : yypt+1]
-		//line parser.y:87
-		{
-			yyVAL = emitSliceSelector(yySymType{node: implicitSliceIdx}, yyDollar[4], yyDollar[6])
-		}
-	case 20:
-		yyDollar = yyS[yypt-6 : yypt+1]
-		//line parser.y:88
-		{
-			yyVAL = emitSliceSelector(yyDollar[3], yySymType{node: implicitSliceIdx}, yyDollar[6])
-		}
-	case 21:
-		yyDollar = yyS[yypt-3 : yypt+1]
-		//line parser.y:90
-		{
-			yyVAL = emitMemberSelector(yyDollar[2], yyDollar[3])
-		}
-	case 22:
-		yyDollar = yyS[yypt-3 : yypt+1]
-		//line parser.y:91
-		{
-			yyVAL = emitSliceSelectorEach(yyDollar[3])
-		}
-	case 23:
-		yyDollar = yyS[yypt-4 : yypt+1]
-		//line parser.y:92
-		{
-			yyVAL = emitMemberSelector(yyDollar[2], yyDollar[4])
-		}
-	case 24:
-		yyDollar = yyS[yypt-6 : yypt+1]
-		//line parser.y:93
-		{
-			yyVAL = emitSliceSelector(yyDollar[2], yyDollar[4], yyDollar[6])
-		}
-	case 25:
-		yyDollar = yyS[yypt-5 : yypt+1]
-		//line parser.y:94
-		{
-			yyVAL = emitSliceSelector(yySymType{node: implicitSliceIdx}, yyDollar[3], yyDollar[4])
-		}
-	case 26:
-		yyDollar = yyS[yypt-5 : yypt+1]
-		//line parser.y:95
-		{
-			yyVAL = emitSliceSelector(yyDollar[2], yySymType{node: implicitSliceIdx}, yyDollar[5])
-		}
-	case 27:
-		yyDollar = yyS[yypt-0 : yypt+1]
-		//line parser.y:96
-		{
-			yyVAL = yySymType{}
-		}
-	case 28:
-		yyDollar = yyS[yypt-2 : yypt+1]
-		//line parser.y:98
-		{
-			yyVAL = emitOpNot(yyDollar[2])
-		}
-	case 29:
-		yyDollar = yyS[yypt-3 : yypt+1]
-		//line parser.y:99
-		{
-			yyVAL = yyDollar[2]
-		}
-	case 30:
-		yyDollar = yyS[yypt-3 : yypt+1]
-		//line parser.y:102
-		{
-			yyVAL = emitOpAnd(yyDollar[1], yyDollar[3])
-		}
-	case 31:
-		yyDollar = yyS[yypt-3 : yypt+1]
-		//line parser.y:103
-		{
-			yyVAL = emitOpOr(yyDollar[1], yyDollar[3])
-		}
-	case 32:
-		yyDollar = yyS[yypt-2 : yypt+1]
-		//line parser.y:104
-		{
-			yyVAL = emitOpNeg(yyDollar[2])
-		}
-	case 33:
-		yyDollar = yyS[yypt-3 : yypt+1]
-		//line parser.y:105
-		{
-			yyVAL = emitOpAdd(yyDollar[1], yyDollar[3])
-		}
-	case 34:
-		yyDollar = yyS[yypt-3 : yypt+1]
-		//line parser.y:106
-		{
-			yyVAL = emitOpSub(yyDollar[1], yyDollar[3])
-		}
-	case 35:
-		yyDollar = yyS[yypt-3 : yypt+1]
-		//line parser.y:107
-		{
-			yyVAL = emitOpDiv(yyDollar[1], yyDollar[3])
-		}
-	case 36:
-		yyDollar = yyS[yypt-3 : yypt+1]
-		//line parser.y:108
-		{
-			yyVAL = emitOpMul(yyDollar[1], yyDollar[3])
-		}
-	case 37:
-		yyDollar = yyS[yypt-3 : yypt+1]
-		//line parser.y:109
-		{
-			yyVAL = emitOpEq(yyDollar[1], yyDollar[3])
-		}
-	case 38:
-		yyDollar = yyS[yypt-3 : yypt+1]
-		//line parser.y:110
-		{
-			yyVAL = emitOpNotEq(yyDollar[1], yyDollar[3])
-		}
-	case 39:
-		yyDollar = yyS[yypt-3 : yypt+1]
-		//line parser.y:111
-		{
-			yyVAL = emitOpGt(yyDollar[1], yyDollar[3])
-		}
-	case 40:
-		yyDollar = yyS[yypt-3 : yypt+1]
-		//line parser.y:112
-		{
-			yyVAL = emitOpGtOrEq(yyDollar[1], yyDollar[3])
-		}
-	case 41:
-		yyDollar = yyS[yypt-3 : yypt+1]
-		//line parser.y:113
-		{
-			yyVAL = emitOpLs(yyDollar[1], yyDollar[3])
-		}
-	case 42:
-		yyDollar = yyS[yypt-3 : yypt+1]
-		//line parser.y:114
-		{
-			yyVAL = emitOpLsOrEq(yyDollar[1], yyDollar[3])
-		}
-	case 43:
-		yyDollar = yyS[yypt-3 : yypt+1]
-		//line parser.y:115
-		{
-			yyVAL = yyDollar[2]
-		}
-	case 44:
-		yyDollar = yyS[yypt-4 : yypt+1]
-		//line parser.y:118
-		{
-			yyVAL = emitFuncCall(yyDollar[1], yyDollar[3])
-		}
-	case 45:
-		yyDollar = yyS[yypt-1 : yypt+1]
-		//line parser.y:119
-		{
-			yyVAL = emitImplicitFuncCall(yyDollar[1])
-		}
-	case 46:
-		yyDollar = yyS[yypt-1 : yypt+1]
-		//line parser.y:121
-		{
-			yyVAL = emitArg(yyDollar[1])
-		}
-	case 47:
-		yyDollar = yyS[yypt-3 : yypt+1]
-		//line parser.y:122
-		{
-			yyVAL = emitArgs(yyDollar[1], yyDollar[3])
-		}
+func (p *parser) span(start, end int) *ast.Span {
+	return &ast.Span{Start: p.pos(start), End: p.pos(end)}
+}
+
+func (p *parser) pos(offset int) ast.Pos {
+	line, col := p.position(offset)
+	return ast.Pos{Offset: offset, Line: line, Column: col}
+}
+
+// position converts a byte offset into a 1-based line and column.
+func (p *parser) position(offset int) (line, col int) {
+	line = sort.Search(len(p.lines), func(i int) bool { return p.lines[i] > offset }) - 1
+	if p.ascii {
+		return line + 1, offset - p.lines[line] + 1
 	}
-	goto yystack /* stack new state and value */
+	col = utf8.RuneCountInString(p.src[p.lines[line]:offset]) + 1
+	return line + 1, col
 }
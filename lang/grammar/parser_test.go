@@ -2,6 +2,7 @@ package grammar
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"reflect"
 	"strings"
@@ -12,8 +13,6 @@ import (
 
 func TestParse(t *testing.T) {
 	log.SetFlags(log.Lshortfile)
-	// yyDebug = 3
-	yyErrorVerbose = true
 
 	var (
 		mkAST     = func(expr *ast.Expr) *ast.AST { return &ast.AST{Expr: expr} }
@@ -51,6 +50,9 @@ func TestParse(t *testing.T) {
 		opDiv = func(lhs, rhs *ast.Expr) *ast.BinaryOperator {
 			return &ast.BinaryOperator{LHS: lhs, RHS: rhs, NumDiv: &ast.OpNumDiv{}}
 		}
+		opMod = func(lhs, rhs *ast.Expr) *ast.BinaryOperator {
+			return &ast.BinaryOperator{LHS: lhs, RHS: rhs, NumMod: &ast.OpNumMod{}}
+		}
 		opEq = func(lhs, rhs *ast.Expr) *ast.BinaryOperator {
 			return &ast.BinaryOperator{LHS: lhs, RHS: rhs, CmpEq: &ast.OpCmpEq{}}
 		}
@@ -83,6 +85,7 @@ func TestParse(t *testing.T) {
 		_ = opSub
 		_ = opMul
 		_ = opDiv
+		_ = opMod
 		_ = opEq
 		_ = opGt
 		_ = fn
@@ -129,6 +132,19 @@ func TestParse(t *testing.T) {
 		{args: `1`, want: mkAST(exprLit(litInt(1)))},
 		{args: `1.0`, want: mkAST(exprLit(litFloat(1)))},
 		{args: `null`, want: mkAST(exprLit(litNull()))},
+		{args: `0x1F`, want: mkAST(exprLit(litInt(31)))},
+		{args: `1_000_000`, want: mkAST(exprLit(litInt(1000000)))},
+		{args: `1e9`, want: mkAST(exprLit(litFloat(1e9)))},
+		{args: `2.5E-3`, want: mkAST(exprLit(litFloat(2.5e-3)))},
+		{args: `.5`, want: mkAST(exprLit(litFloat(0.5)))},
+		{args: `.[.5]`, want: mkAST(exprSel(
+			selMember(exprLit(litFloat(0.5)), nil),
+		))},
+		{args: `9223372036854775807`, want: mkAST(exprLit(litInt(9223372036854775807)))},
+		{args: `9223372036854775808`, wantErr: true},
+		{args: `0x1_0000_0000_0000_0000`, wantErr: true},
+		{args: `1e999`, wantErr: true},
+		{args: `1 + 1e999`, wantErr: true},
 		{args: ".hello", want: mkAST(exprSel(
 			selMember(exprLit(litString("hello")), nil),
 		))},
@@ -137,6 +153,29 @@ func TestParse(t *testing.T) {
 				selMember(exprLit(litString("bye")), nil),
 			),
 		))},
+		{args: `."hello"`, want: mkAST(exprSel(
+			selMember(exprLit(litString("hello")), nil),
+		))},
+		{args: `."an awkward key"`, want: mkAST(exprSel(
+			selMember(exprLit(litString("an awkward key")), nil),
+		))},
+		{args: `.hello."x-request-id"`, want: mkAST(exprSel(
+			selMember(exprLit(litString("hello")),
+				selMember(exprLit(litString("x-request-id")), nil),
+			),
+		))},
+		{args: `."hello"[0]."bye"`, want: mkAST(exprSel(
+			selMember(exprLit(litString("hello")),
+				selMember(exprLit(litInt(0)),
+					selMember(exprLit(litString("bye")), nil),
+				),
+			),
+		))},
+		{args: ".héllo.日本語", want: mkAST(exprSel(
+			selMember(exprLit(litString("héllo")),
+				selMember(exprLit(litString("日本語")), nil),
+			),
+		))},
 		{args: ".[]", want: mkAST(
 			exprSel(
 				selSlice(nil, nil, nil),
@@ -330,6 +369,12 @@ func TestParse(t *testing.T) {
 				exprSel(selNoop()),
 			)),
 		)},
+		{args: `. % .`, want: mkAST(
+			exprBinOp(opMod(
+				exprSel(selNoop()),
+				exprSel(selNoop()),
+			)),
+		)},
 		{args: `. * .`, want: mkAST(
 			exprBinOp(opMul(
 				exprSel(selNoop()),
@@ -409,6 +454,24 @@ func TestParse(t *testing.T) {
 				exprLit(litInt(3)),
 			)),
 		)},
+		{args: `1 / 2 % 3`, want: mkAST(
+			exprBinOp(opDiv(
+				exprLit(litInt(1)),
+				exprBinOp(opMod(
+					exprLit(litInt(2)),
+					exprLit(litInt(3)),
+				)),
+			)),
+		)},
+		{args: `1 % 2 > 0`, want: mkAST(
+			exprBinOp(opGt(
+				exprBinOp(opMod(
+					exprLit(litInt(1)),
+					exprLit(litInt(2)),
+				)),
+				exprLit(litInt(0)),
+			)),
+		)},
 
 		{args: `1 - (2 + 3)`, want: mkAST(
 			exprBinOp(opSub(
@@ -648,6 +711,9 @@ func TestParse(t *testing.T) {
 				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if got != nil {
+				stripSpans(got)
+			}
 			if !reflect.DeepEqual(got, tt.want) {
 				wantAst, _ := json.MarshalIndent(tt.want, "", "  ")
 				gotAst, _ := json.MarshalIndent(got, "", "  ")
@@ -660,8 +726,6 @@ func TestParse(t *testing.T) {
 
 func TestParseOnly(t *testing.T) {
 	log.SetFlags(log.Lshortfile)
-	// yyDebug = 3
-	yyErrorVerbose = true
 
 	tests := []struct {
 		name    string
@@ -882,3 +946,288 @@ func TestParseOnly(t *testing.T) {
 		})
 	}
 }
+
+func TestParseErrors(t *testing.T) {
+	type pos struct {
+		Line, Column int
+		Msg          string
+	}
+	tests := []struct {
+		name string
+		args string
+		want []pos
+	}{
+		{
+			args: `.a[`,
+			want: []pos{{1, 4, `syntax error: unexpected end of query, expected ".", "]", "(", ":", null, boolean, identifier, string, integer, float, "!" or "-"`}},
+		},
+		{
+			args: `.a ]`,
+			want: []pos{{1, 4, `syntax error: unexpected "]", expected end of query, ".", "[", "|", "||", "&&", "==", "!=", ">", ">=", "<", "<=", "+", "-", "*", "/" or "%"`}},
+		},
+		{
+			args: `true true`,
+			want: []pos{{1, 6, `syntax error: unexpected "true", expected end of query, "|", "||", "&&", "==", "!=", ">", ">=", "<", "<=", "+", "-", "*", "/" or "%"`}},
+		},
+		{
+			args: "select(\n  .héllo == @)",
+			want: []pos{
+				{2, 13, `invalid character "@"`},
+				{2, 14, `syntax error: unexpected ")", expected ".", "(", null, boolean, identifier, string, integer, float, "!" or "-"`},
+			},
+		},
+		{
+			name: "recovers to report several errors",
+			args: "f(1,,2) | g(.a, 9223372036854775808)",
+			want: []pos{
+				{1, 5, `syntax error: unexpected ",", expected ".", "(", null, boolean, identifier, string, integer, float, "!" or "-"`},
+				{1, 17, `invalid integer literal "9223372036854775808": value out of range`},
+			},
+		},
+		{
+			args: `.a < .b <= .c`,
+			want: []pos{{1, 9, `syntax error: unexpected "<=", comparisons can't follow each other without parentheses`}},
+		},
+		{
+			args: `.a == .b != .c`,
+			want: []pos{{1, 10, `syntax error: unexpected "!=", equalities can't follow each other without parentheses`}},
+		},
+		{
+			args: `(.a) | (1 + 2`,
+			want: []pos{
+				{1, 4, `syntax error: unexpected ")", only an operator can be put in parentheses`},
+				{1, 14, `syntax error: unexpected end of query, expected ")", "||", "&&", "==", "!=", ">", ">=", "<", "<=", "+", "-", "*", "/" or "%"`},
+			},
+		},
+		{
+			args: `."a\qb" | "c`,
+			want: []pos{
+				{1, 4, `invalid escape \q in string`},
+				{1, 11, `string isn't terminated`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		if tt.name == "" {
+			tt.name = tt.args
+		}
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := Parse(strings.NewReader(tt.args))
+			if tree != nil {
+				t.Errorf("want no tree, got %#v", tree)
+			}
+			errs, ok := err.(ErrorList)
+			if !ok {
+				t.Fatalf("want an ErrorList, got %T: %v", err, err)
+			}
+			var got []pos
+			for _, err := range errs {
+				got = append(got, pos{err.Line, err.Column, err.Msg})
+			}
+			if !reflect.DeepEqual(tt.want, got) {
+				t.Errorf("want=%+v", tt.want)
+				t.Errorf(" got=%+v", got)
+			}
+		})
+	}
+}
+
+func TestParseDeeplyNested(t *testing.T) {
+	const n = 1000000
+	tests := []struct {
+		name string
+		args string
+	}{
+		{name: "nots", args: strings.Repeat("!", n) + "true"},
+		{name: "negations", args: strings.Repeat("-", n) + "1"},
+		{name: "parentheses", args: strings.Repeat("(!", n) + "true" + strings.Repeat(")", n)},
+		{name: "arguments", args: strings.Repeat("f(", n) + "1" + strings.Repeat(")", n)},
+		{name: "indexes", args: strings.Repeat(".[", n) + "1" + strings.Repeat("]", n)},
+		{name: "selectors", args: strings.Repeat(".a", n)},
+		{name: "operands", args: "1" + strings.Repeat(" - 1", n)},
+		{name: "pipeline", args: "." + strings.Repeat(" | .", n)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.args))
+			errs, ok := err.(ErrorList)
+			if !ok {
+				t.Fatalf("want an ErrorList, got %T: %v", err, err)
+			}
+			if len(errs) != 1 {
+				t.Fatalf("want 1 error, got %d: %v", len(errs), errs)
+			}
+			if want := "syntax error: query is nested more than 10000 deep"; errs[0].Msg != want {
+				t.Errorf("want=%s", want)
+				t.Errorf(" got=%s", errs[0].Msg)
+			}
+		})
+	}
+
+	if _, err := Parse(strings.NewReader(strings.Repeat("!", 1000) + "true")); err != nil {
+		t.Errorf("want a query nested 1000 deep to parse, got %v", err)
+	}
+}
+
+func TestParseWithLimits(t *testing.T) {
+	tests := []struct {
+		args               string
+		maxDepth, maxNodes int
+		want               string
+	}{
+		{args: `!!!true`, maxDepth: 3},
+		{args: `!!!true`, maxDepth: 2, want: `1:3: expression is nested 3 deep, more than the limit of 2`},
+		{args: `.a[.b[.c]]`, maxDepth: 2, want: `1:7: expression is nested 3 deep, more than the limit of 2`},
+		{args: `.["a"] | .b`, maxDepth: 1},
+		{args: `f(1, g(2))`, maxDepth: 2},
+		{args: `f(1, g(h))`, maxDepth: 2, want: `1:8: expression is nested 3 deep, more than the limit of 2`},
+		{args: `1 - 1`, maxDepth: 2},
+		{args: `1 - 1 - 1`, maxDepth: 2, want: `1:1: expression is nested 3 deep, more than the limit of 2`},
+		{args: strings.Repeat("!", 1000000) + "true", maxDepth: 10, want: `1:11: expression is nested 11 deep, more than the limit of 10`},
+		{args: `.a.b`, maxNodes: 9},
+		{args: `.a.b`, maxNodes: 8, want: `1:5: query has more nodes than the limit of 8`},
+		{args: strings.Repeat(".a", 1000000), maxNodes: 100, want: `1:51: query has more nodes than the limit of 100`},
+	}
+	for _, tt := range tests {
+		name := tt.args
+		if len(name) > 20 {
+			name = name[:20]
+		}
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseWithLimits(strings.NewReader(tt.args), tt.maxDepth, tt.maxNodes)
+			var got string
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("want=%s", tt.want)
+				t.Errorf(" got=%s", got)
+			}
+		})
+	}
+}
+
+// TestParseWithLimitsCountsNodes checks that the parser counts the
+// nodes of a tree as ast.Inspect does.
+func TestParseWithLimitsCountsNodes(t *testing.T) {
+	queries := []string{
+		`.`,
+		`.[]`,
+		`-.a`,
+		`select(.a != null) | .x[] | .["y"]`,
+		`.a.b[1:2][.c]["d"][:3][4:] | f(1, -2.5, !true) | (.a + .b) * 3 % 2`,
+	}
+	for _, query := range queries {
+		t.Run(query, func(t *testing.T) {
+			tree, err := Parse(strings.NewReader(query))
+			if err != nil {
+				t.Fatal(err)
+			}
+			nodes := 0
+			ast.Inspect(tree.Expr, func(n ast.Node) bool {
+				if n != nil {
+					nodes++
+				}
+				return true
+			})
+			if _, err := ParseWithLimits(strings.NewReader(query), 0, nodes); err != nil {
+				t.Errorf("want no error with a limit of %d nodes, got %v", nodes, err)
+			}
+			if _, err := ParseWithLimits(strings.NewReader(query), 0, nodes-1); err == nil {
+				t.Errorf("want an error with a limit of %d nodes", nodes-1)
+			}
+		})
+	}
+}
+
+func TestSyntaxErrorSnippet(t *testing.T) {
+	_, err := Parse(strings.NewReader(".a |\n\t.b ) "))
+	var serr *SyntaxError
+	if !errors.As(err, &serr) {
+		t.Fatalf("want a *SyntaxError, got %T: %v", err, err)
+	}
+	if want := "\t.b ) \n\t   ^"; serr.Snippet != want {
+		t.Errorf("want snippet %q, got %q", want, serr.Snippet)
+	}
+	if want := ")"; serr.Token != want {
+		t.Errorf("want token %q, got %q", want, serr.Token)
+	}
+	if want := "2:5: syntax error"; !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("want error to start with %q, got %q", want, err.Error())
+	}
+}
+
+// stripSpans removes the positions from a tree, so that it can be
+// compared to one written by hand.
+func stripSpans(tree *ast.AST) {
+	ast.Inspect(tree, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Expr:
+			n.Span = nil
+		case *ast.Selector:
+			n.Span = nil
+		case *ast.FuncCall:
+			n.Span = nil
+		}
+		return true
+	})
+}
+
+func TestParseSpans(t *testing.T) {
+	span := func(start, end int) *ast.Span {
+		return &ast.Span{
+			Start: ast.Pos{Offset: start, Line: 1, Column: start + 1},
+			End:   ast.Pos{Offset: end, Line: 1, Column: end + 1},
+		}
+	}
+	// .a[1] | f(.b, -2)
+	// 01234567890123456
+	tree, err := Parse(strings.NewReader(".a[1] | f(.b, -2)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sel := tree.Expr.Selector
+	call := tree.Expr.Next.FuncCall
+	tests := []struct {
+		name string
+		want *ast.Span
+		got  *ast.Span
+	}{
+		{"pipe head", span(0, 5), tree.Expr.Span},
+		{"selector", span(0, 5), sel.Span},
+		{"member name", span(1, 2), sel.Member.Index.Span},
+		{"child selector", span(2, 5), sel.Member.Child.Span},
+		{"index", span(3, 4), sel.Member.Child.Member.Index.Span},
+		{"pipe tail", span(8, 17), tree.Expr.Next.Span},
+		{"func call", span(8, 17), call.Span},
+		{"first arg", span(10, 12), call.Args[0].Span},
+		{"second arg", span(14, 16), call.Args[1].Span},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.want, tt.got) {
+			t.Errorf("%s: want %v, got %v", tt.name, tt.want, tt.got)
+		}
+	}
+
+	tree, err = Parse(strings.NewReader(".a |\n  .héllo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ast.Span{
+		Start: ast.Pos{Offset: 7, Line: 2, Column: 3},
+		End:   ast.Pos{Offset: 14, Line: 2, Column: 9},
+	}
+	if got := tree.Expr.Next.Span; !reflect.DeepEqual(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	query := `select(.user.age >= 18 && regexp(.user.name, "^a")) | .events[1:3] | .payload["kind"] | .count * 2 + 1`
+	b.SetBytes(int64(len(query)))
+	for i := 0; i < b.N; i++ {
+		if _, err := Parse(strings.NewReader(query)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
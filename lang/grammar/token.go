@@ -1,6 +1,8 @@
 package grammar
 
 const (
+	tokEOF = ""
+
 	tokDot          = "."
 	tokComma        = ","
 	tokLeftBracket  = "["
@@ -18,6 +20,7 @@ const (
 	tokNumSub = "-"
 	tokNumMul = "*"
 	tokNumDiv = "/"
+	tokNumMod = "%"
 
 	tokCmpEq     = "=="
 	tokCmpNotEq  = "!="
@@ -26,17 +29,12 @@ const (
 	tokCmpLs     = "<"
 	tokCmpLsOrEq = "<="
 
-	tokWS           = "`ws`"
-	tokNull         = "`null`"
-	tokBool         = "`bool`"
-	tokIdentifier   = "`id`"
-	tokString       = "`string`"
-	tokInt          = "`int`"
-	tokFloat        = "`float`"
-	tokCastToBool   = "`(bool) `"
-	tokCastToInt    = "`(int) `"
-	tokCastToFloat  = "`(float) `"
-	tokCastToString = "`(string) `"
+	tokNull       = "`null`"
+	tokBool       = "`bool`"
+	tokIdentifier = "`id`"
+	tokString     = "`string`"
+	tokInt        = "`int`"
+	tokFloat      = "`float`"
 )
 
 type tok struct {
@@ -45,3 +43,46 @@ type tok struct {
 }
 
 func (t *tok) String() string { return t.id }
+
+// lexeme is a token along with where it was found: the byte offsets
+// of its first character, and of the one just past its last.
+type lexeme struct {
+	tok
+	pos, end int
+	// bad is set if the token is invalid, and was already reported
+	// as such by the lexer.
+	bad bool
+}
+
+// terminals are all the tokens, including the end of the query, in
+// the order they're listed in errors.
+var terminals = []string{
+	tokEOF, tokDot, tokLeftBracket, tokRightBracket, tokLeftParens,
+	tokRightParens, tokColon, tokPipe, tokComma, tokNull, tokBool,
+	tokIdentifier, tokString, tokInt, tokFloat, tokLogOr, tokLogAnd,
+	tokLogNot, tokCmpEq, tokCmpNotEq, tokCmpGt, tokCmpGtOrEq, tokCmpLs,
+	tokCmpLsOrEq, tokNumAdd, tokNumSub, tokNumMul, tokNumDiv, tokNumMod,
+}
+
+// exprStart are the tokens an expression can start with.
+var exprStart = []string{
+	tokDot, tokLeftParens, tokNull, tokBool, tokIdentifier, tokString,
+	tokInt, tokFloat, tokLogNot, tokNumSub,
+}
+
+// binaryOperators are the tokens of the binary operators, including
+// the pipe.
+var binaryOperators = []string{
+	tokPipe, tokLogOr, tokLogAnd, tokCmpEq, tokCmpNotEq, tokCmpGt,
+	tokCmpGtOrEq, tokCmpLs, tokCmpLsOrEq, tokNumAdd, tokNumSub, tokNumMul,
+	tokNumDiv, tokNumMod,
+}
+
+// symbols are the tokens that always have the same text, from the
+// longest to the shortest.
+var symbols = []string{
+	tokLogAnd, tokLogOr, tokCmpEq, tokCmpNotEq, tokCmpGtOrEq, tokCmpLsOrEq,
+	tokDot, tokComma, tokLeftBracket, tokRightBracket, tokLeftParens,
+	tokRightParens, tokColon, tokPipe, tokLogNot, tokNumAdd, tokNumSub,
+	tokNumMul, tokNumDiv, tokNumMod, tokCmpGt, tokCmpLs,
+}
@@ -0,0 +1,84 @@
+package msgutil
+
+import (
+	"math"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/aybabtme/streamql/lang/msg"
+)
+
+// AppendCanonical appends the canonical encoding of m to dst. It's the
+// same for all the messages that are Equal, whichever Builder made them:
+// compact JSON, with the keys of objects sorted, and floats without a
+// fraction written as ints. NaN and infinities, which JSON doesn't have,
+// are written `NaN`, `Infinity` and `-Infinity`.
+func AppendCanonical(dst []byte, m msg.Msg) []byte {
+	switch m.Type() {
+	case msg.TypeObject:
+		dst = append(dst, '{')
+		for i, k := range sortedKeys(m) {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			v, _ := m.Member(k)
+			dst = appendQuoted(dst, k)
+			dst = append(dst, ':')
+			dst = AppendCanonical(dst, v)
+		}
+		return append(dst, '}')
+	case msg.TypeArray:
+		dst = append(dst, '[')
+		for i := int64(0); i < m.Len(); i++ {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = AppendCanonical(dst, m.Index(i))
+		}
+		return append(dst, ']')
+	case msg.TypeString:
+		return appendQuoted(dst, m.StringVal())
+	case msg.TypeInt:
+		return strconv.AppendInt(dst, m.IntVal(), 10)
+	case msg.TypeFloat:
+		f := m.FloatVal()
+		switch {
+		case math.IsNaN(f):
+			return append(dst, "NaN"...)
+		case math.IsInf(f, 1):
+			return append(dst, "Infinity"...)
+		case math.IsInf(f, -1):
+			return append(dst, "-Infinity"...)
+		case f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64:
+			return strconv.AppendInt(dst, int64(f), 10)
+		}
+		return strconv.AppendFloat(dst, f, 'g', -1, 64)
+	case msg.TypeBool:
+		return strconv.AppendBool(dst, m.BoolVal())
+	}
+	return append(dst, "null"...)
+}
+
+// appendQuoted appends s as a JSON string, with only the characters that
+// must be escaped escaped. Invalid UTF-8 is replaced by U+FFFD.
+func appendQuoted(dst []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	dst = append(dst, '"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			dst = append(dst, '\\', byte(r))
+		case r == '\n':
+			dst = append(dst, '\\', 'n')
+		case r == '\r':
+			dst = append(dst, '\\', 'r')
+		case r == '\t':
+			dst = append(dst, '\\', 't')
+		case r < 0x20:
+			dst = append(dst, '\\', 'u', '0', '0', hex[r>>4], hex[r&0xf])
+		default:
+			dst = utf8.AppendRune(dst, r)
+		}
+	}
+	return append(dst, '"')
+}
@@ -0,0 +1,75 @@
+package msgutil_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/aybabtme/streamql/lang/msg"
+	"github.com/aybabtme/streamql/lang/msg/gomsg"
+	"github.com/aybabtme/streamql/lang/msg/msgutil"
+)
+
+func TestAppendCanonical(t *testing.T) {
+	build := gomsg.Build()
+	for _, tt := range []struct {
+		v    interface{}
+		want string
+	}{
+		{v: "a\"b\\c\n\x01é", want: `"a\"b\\c\n\u0001é"`},
+		{v: "\xff", want: "\"�\""},
+		{v: 12, want: `12`},
+		{v: -2.0, want: `-2`},
+		{v: 1<<53 + 1, want: `9007199254740993`},
+		{v: float64(1<<53 + 1), want: `9007199254740992`},
+		{v: 1.5, want: `1.5`},
+		{v: 1e100, want: `1e+100`},
+		{v: math.NaN(), want: `NaN`},
+		{v: math.Inf(-1), want: `-Infinity`},
+		{v: true, want: `true`},
+		{v: []interface{}{1, "a", []interface{}{}}, want: `[1,"a",[]]`},
+		{v: map[string]interface{}{"b": 1, "a": map[string]interface{}{"d": 2.0, "c": false}}, want: `{"a":{"c":false,"d":2},"b":1}`},
+	} {
+		m, err := msgutil.FromGo(build, tt.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(msgutil.AppendCanonical(nil, m)); got != tt.want {
+			t.Errorf("AppendCanonical(%#v): want %s, got %s", tt.v, tt.want, got)
+		}
+	}
+}
+
+func TestAppendCanonicalOfEqualMessages(t *testing.T) {
+	build := gomsg.Build()
+	member := func(ob msg.ObjectBuilder, k string, v func(msg.Builder) (msg.Msg, error)) {
+		if err := ob.AddMember(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	one := func(b msg.Builder) (msg.Msg, error) { return b.Int(1) }
+	oneFloat := func(b msg.Builder) (msg.Msg, error) { return b.Float(1) }
+	null := func(b msg.Builder) (msg.Msg, error) { return b.Null() }
+
+	a, err := build.Object(func(ob msg.ObjectBuilder) error {
+		member(ob, "x", one)
+		member(ob, "y", null)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := build.Object(func(ob msg.ObjectBuilder) error {
+		member(ob, "y", null)
+		member(ob, "x", oneFloat)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !msgutil.Equal(a, b) {
+		t.Fatal("messages should be equal")
+	}
+	if ca, cb := msgutil.AppendCanonical(nil, a), msgutil.AppendCanonical(nil, b); string(ca) != string(cb) {
+		t.Errorf("equal messages have different encodings: %s and %s", ca, cb)
+	}
+}
@@ -0,0 +1,148 @@
+package msgutil
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/aybabtme/streamql/lang/msg"
+)
+
+// Compare returns -1, 0 or 1 if a is ordered before, like or after b.
+// Messages of different types are ordered by type:
+//
+//	null < false < true < numbers < strings < arrays < objects
+//
+// Numbers are ordered by value, ints and floats together, with NaN
+// before all the others. Ints and floats are compared exactly, even
+// past 2^53 where floats can't hold all the ints. Strings are ordered
+// byte by byte and arrays element by element. Objects are ordered by
+// their sorted keys first, then by the values of those keys.
+func Compare(a, b msg.Msg) int {
+	if ra, rb := typeRank(a), typeRank(b); ra != rb {
+		return compareInts(int64(ra), int64(rb))
+	}
+	switch a.Type() {
+	case msg.TypeInt, msg.TypeFloat:
+		return compareNumbers(a, b)
+	case msg.TypeString:
+		return strings.Compare(a.StringVal(), b.StringVal())
+	case msg.TypeArray:
+		n := a.Len()
+		if b.Len() < n {
+			n = b.Len()
+		}
+		for i := int64(0); i < n; i++ {
+			if c := Compare(a.Index(i), b.Index(i)); c != 0 {
+				return c
+			}
+		}
+		return compareInts(a.Len(), b.Len())
+	case msg.TypeObject:
+		aKeys, bKeys := sortedKeys(a), sortedKeys(b)
+		for i := 0; i < len(aKeys) && i < len(bKeys); i++ {
+			if c := strings.Compare(aKeys[i], bKeys[i]); c != 0 {
+				return c
+			}
+		}
+		if c := compareInts(int64(len(aKeys)), int64(len(bKeys))); c != 0 {
+			return c
+		}
+		for _, k := range aKeys {
+			av, _ := a.Member(k)
+			bv, _ := b.Member(k)
+			if c := Compare(av, bv); c != 0 {
+				return c
+			}
+		}
+	}
+	return 0
+}
+
+// Equal tells if a and b are alike: of the same type, or both numbers,
+// with equal values, elements and members. It agrees with Compare, so
+// unlike in Go, NaN is equal to NaN.
+func Equal(a, b msg.Msg) bool {
+	return Compare(a, b) == 0
+}
+
+// typeRank is where the type of m is in the order of messages.
+func typeRank(m msg.Msg) int {
+	switch m.Type() {
+	case msg.TypeNull:
+		return 0
+	case msg.TypeBool:
+		if m.BoolVal() {
+			return 2
+		}
+		return 1
+	case msg.TypeInt, msg.TypeFloat:
+		return 3
+	case msg.TypeString:
+		return 4
+	case msg.TypeArray:
+		return 5
+	}
+	return 6
+}
+
+func compareNumbers(a, b msg.Msg) int {
+	switch {
+	case a.Type() == msg.TypeInt && b.Type() == msg.TypeInt:
+		return compareInts(a.IntVal(), b.IntVal())
+	case a.Type() == msg.TypeInt:
+		return compareIntFloat(a.IntVal(), b.FloatVal())
+	case b.Type() == msg.TypeInt:
+		return -compareIntFloat(b.IntVal(), a.FloatVal())
+	}
+	x, y := a.FloatVal(), b.FloatVal()
+	switch {
+	case x < y, math.IsNaN(x) && !math.IsNaN(y):
+		return -1
+	case x > y, math.IsNaN(y) && !math.IsNaN(x):
+		return 1
+	}
+	return 0
+}
+
+// compareIntFloat compares an int and a float exactly, without turning
+// the int into a float, which would round it above 2^53.
+func compareIntFloat(i int64, f float64) int {
+	switch {
+	case math.IsNaN(f):
+		return 1
+	case f >= math.MaxInt64: // 2^63, above all ints
+		return -1
+	case f < math.MinInt64:
+		return 1
+	}
+	trunc := math.Trunc(f)
+	if c := compareInts(i, int64(trunc)); c != 0 {
+		return c
+	}
+	// i is the integral part of f
+	switch {
+	case f > trunc:
+		return -1
+	case f < trunc:
+		return 1
+	}
+	return 0
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func sortedKeys(obj msg.Msg) []string {
+	keys := make([]string, len(obj.Keys()))
+	copy(keys, obj.Keys())
+	sort.Strings(keys)
+	return keys
+}
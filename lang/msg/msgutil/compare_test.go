@@ -0,0 +1,108 @@
+package msgutil_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/aybabtme/streamql/lang/msg"
+	"github.com/aybabtme/streamql/lang/msg/gomsg"
+	"github.com/aybabtme/streamql/lang/msg/msgutil"
+)
+
+func TestCompare(t *testing.T) {
+	build := gomsg.Build()
+	mk := func(v interface{}) msg.Msg {
+		if v == nil {
+			m, err := build.Null()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return m
+		}
+		m, err := msgutil.FromGo(build, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	// in order, each one before the next
+	ordered := []interface{}{
+		nil,
+		false,
+		true,
+		math.NaN(),
+		math.Inf(-1),
+		-1,
+		0.5,
+		1,
+		1.5,
+		float64(1 << 53),
+		1<<53 + 1,
+		float64(1<<53 + 2),
+		math.MaxInt64,
+		float64(math.MaxInt64),
+		math.Inf(1),
+		"",
+		"a",
+		"ab",
+		"b",
+		[]interface{}{},
+		[]interface{}{1},
+		[]interface{}{1, 2},
+		[]interface{}{2},
+		map[string]interface{}{},
+		map[string]interface{}{"a": 2},
+		map[string]interface{}{"a": 1, "b": 1},
+		map[string]interface{}{"a": 2, "b": 1},
+		map[string]interface{}{"b": 0},
+	}
+	for i := range ordered {
+		for j := range ordered {
+			a, b := mk(ordered[i]), mk(ordered[j])
+			want := 0
+			switch {
+			case i < j:
+				want = -1
+			case i > j:
+				want = 1
+			}
+			if got := msgutil.Compare(a, b); got != want {
+				t.Errorf("Compare(%v, %v): want %d, got %d", ordered[i], ordered[j], want, got)
+			}
+			if got := msgutil.Equal(a, b); got != (want == 0) {
+				t.Errorf("Equal(%v, %v): want %v, got %v", ordered[i], ordered[j], want == 0, got)
+			}
+		}
+	}
+}
+
+func TestEqualNumbers(t *testing.T) {
+	build := gomsg.Build()
+	for _, tt := range []struct {
+		a, b interface{}
+		want bool
+	}{
+		{a: 2, b: 2.0, want: true},
+		{a: 2.5, b: 2, want: false},
+		{a: math.NaN(), b: math.NaN(), want: true},
+		{a: 1 << 53, b: float64(1 << 53), want: true},
+		{a: 1<<53 + 1, b: float64(1 << 53), want: false},
+		{a: math.MaxInt64, b: float64(math.MaxInt64), want: false},
+		{a: math.MinInt64, b: float64(math.MinInt64), want: true},
+		{a: []interface{}{1, 2.0}, b: []interface{}{1.0, 2}, want: true},
+		{a: map[string]interface{}{"a": 1}, b: map[string]interface{}{"a": 1.0}, want: true},
+	} {
+		a, err := msgutil.FromGo(build, tt.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := msgutil.FromGo(build, tt.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := msgutil.Equal(a, b); got != tt.want {
+			t.Errorf("Equal(%v, %v): want %v, got %v", tt.a, tt.b, tt.want, got)
+		}
+	}
+}
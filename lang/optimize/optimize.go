@@ -0,0 +1,154 @@
+// Package optimize rewrites queries into cheaper ones that emit the
+// same messages.
+package optimize
+
+import (
+	"math"
+
+	"github.com/aybabtme/streamql/lang/ast"
+	"github.com/aybabtme/streamql/lang/msg"
+	"github.com/aybabtme/streamql/lang/msg/gomsg"
+	"github.com/aybabtme/streamql/lang/vm"
+	"github.com/aybabtme/streamql/lang/vm/astvm"
+)
+
+// Optimize rewrites a tree in place and returns it. It:
+//
+//   - folds the operators on literals into a literal, like `1 + 2`
+//     into `3`,
+//   - removes the stages of a pipeline that do nothing, like `. | x`
+//     into `x`,
+//   - merges the selects that follow each other, like
+//     `select(a) | select(b)` into `select(select(a) | b)`.
+//
+// An optimized query emits the same messages and errors as the
+// original, in the same order.
+func Optimize(tree *ast.AST) *ast.AST {
+	ast.Rewrite(tree, func(node ast.Node) ast.Node {
+		e, ok := node.(*ast.Expr)
+		if !ok {
+			return node
+		}
+		if isNoop(e) && e.Next != nil {
+			return e.Next
+		}
+		if e.Next != nil && isNoop(e.Next) && e.Next.Next == nil {
+			e.Next = nil
+		}
+		fold(e)
+		return mergeSelects(e)
+	})
+	return tree
+}
+
+func isNoop(e *ast.Expr) bool {
+	return e.Selector != nil && e.Selector.Noop != nil
+}
+
+// fold replaces an operator on literals with the literal it evaluates
+// to. The operator is evaluated by the VM, so that the result is
+// exactly what it would have been at runtime. Operators that fail,
+// like a division by zero, are left for the runtime to report, and so
+// are those whose result can't be written as a literal, like a float
+// that overflows to infinity.
+func fold(e *ast.Expr) {
+	var operands []*ast.Expr
+	switch {
+	case e.UnaryOperator != nil:
+		operands = []*ast.Expr{e.UnaryOperator.Arg}
+	case e.BinaryOperator != nil:
+		operands = []*ast.Expr{e.BinaryOperator.LHS, e.BinaryOperator.RHS}
+	default:
+		return
+	}
+	for _, op := range operands {
+		if op.Literal == nil || op.Next != nil {
+			return
+		}
+	}
+
+	stage := &ast.Expr{UnaryOperator: e.UnaryOperator, BinaryOperator: e.BinaryOperator}
+	build := gomsg.Build()
+	in, err := build.Null()
+	if err != nil {
+		return
+	}
+	var out []msg.Msg
+	err = astvm.Interpreter(&ast.AST{Expr: stage}, &vm.Options{Strict: true}).Run(build,
+		func() (msg.Msg, bool, error) {
+			if in == nil {
+				return nil, false, nil
+			}
+			m := in
+			in = nil
+			return m, true, nil
+		},
+		func(m msg.Msg) error {
+			out = append(out, m)
+			return nil
+		},
+	)
+	if err != nil || len(out) != 1 {
+		return
+	}
+	lit, ok := literal(out[0])
+	if !ok {
+		return
+	}
+	e.UnaryOperator = nil
+	e.BinaryOperator = nil
+	e.Literal = lit
+}
+
+func literal(m msg.Msg) (*ast.Literal, bool) {
+	switch m.Type() {
+	case msg.TypeBool:
+		v := m.BoolVal()
+		return &ast.Literal{Bool: &v}, true
+	case msg.TypeString:
+		v := m.StringVal()
+		return &ast.Literal{String: &v}, true
+	case msg.TypeInt:
+		v := m.IntVal()
+		return &ast.Literal{Int: &v}, true
+	case msg.TypeFloat:
+		v := m.FloatVal()
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return nil, false
+		}
+		return &ast.Literal{Float: &v}, true
+	case msg.TypeNull:
+		return &ast.Literal{Null: &struct{}{}}, true
+	}
+	return nil, false
+}
+
+// mergeSelects merges e with the select that follows it, if both are
+// selects of the current message. The condition of the second select
+// only runs on the messages that passed the first one: joining the
+// conditions with a logical and would run it on every message, since
+// a logical and evaluates both of its sides.
+func mergeSelects(e *ast.Expr) *ast.Expr {
+	if !isSelect(e) || e.Next == nil || !isSelect(e.Next) {
+		return e
+	}
+	first, second := e, e.Next
+	cond := &ast.Expr{
+		FuncCall: first.FuncCall,
+		Span:     first.Span,
+		Next:     second.FuncCall.Args[0],
+	}
+	return &ast.Expr{
+		FuncCall: &ast.FuncCall{
+			Name: second.FuncCall.Name,
+			Args: []*ast.Expr{cond},
+			Span: second.FuncCall.Span,
+		},
+		Span: second.Span,
+		Next: second.Next,
+	}
+}
+
+func isSelect(e *ast.Expr) bool {
+	return e.FuncCall != nil && e.FuncCall.Name == "select" && len(e.FuncCall.Args) == 1
+}
@@ -0,0 +1,110 @@
+package optimize_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aybabtme/streamql/lang/ast"
+	"github.com/aybabtme/streamql/lang/grammar"
+	"github.com/aybabtme/streamql/lang/msg"
+	"github.com/aybabtme/streamql/lang/msg/gomsg"
+	"github.com/aybabtme/streamql/lang/optimize"
+	"github.com/aybabtme/streamql/lang/vm"
+	"github.com/aybabtme/streamql/lang/vm/astvm"
+	"github.com/aybabtme/streamql/lang/vm/vmtest"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		args string
+		want string
+	}{
+		{args: `.`, want: `.`},
+		{args: `1 + 2 * 3`, want: `7`},
+		{args: `-1`, want: `-1`},
+		{args: `"a" + 1 + 2.5`, want: `"a12.5"`},
+		{args: `1 / 2.0 > 0 && !false`, want: `true`},
+		{args: `1 == 1.0`, want: `true`},
+		{args: `.a + (1 - 2)`, want: `.a + -1`},
+		{args: `.a[1 + 1:]`, want: `.a[2:]`},
+		{args: `regexp(.a, "a" + "b")`, want: `regexp(.a, "ab")`},
+		{args: `1 / 0`, want: `1 / 0`},
+		{args: `1e308 * 10.0`, want: `1e+308 * 10.0`},
+		{args: `-1e308 * 10.0 - 1`, want: `-1e+308 * 10.0 - 1`},
+		{args: `"a" < 1`, want: `false`},
+		{args: `null == null`, want: `true`},
+		{args: `. | .a | . | .b | .`, want: `.a | .b`},
+		{args: `. | .`, want: `.`},
+		{args: `.[. | .a]`, want: `.[.a]`},
+		{args: `select(.a) | select(.b) | .c`, want: `select(select(.a) | .b) | .c`},
+		{args: `select(.a) | . | select(.b) | select(.c)`, want: `select(select(.a) | select(.b) | .c)`},
+		{args: `select(.a, .b) | select(.c)`, want: `select(.a, .b) | select(.c)`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			tree, err := grammar.Parse(strings.NewReader(tt.args))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ast.Format(optimize.Optimize(tree)); got != tt.want {
+				t.Errorf("want=%s", tt.want)
+				t.Errorf(" got=%s", got)
+			}
+		})
+	}
+}
+
+func TestInterpreter(t *testing.T) {
+	vmtest.Verify(t, func(tree *ast.AST, opts *vm.Options) vm.VM {
+		return astvm.Interpreter(optimize.Optimize(tree), opts)
+	})
+}
+
+func TestSameErrors(t *testing.T) {
+	tests := []string{
+		`1 / 0`,
+		`. | 1 / . | . + 1`,
+		`select(.a) | select(.b > 1)`,
+		`select(.a) | select(.b)`,
+		`regexp("a", "(")`,
+		`"a" - 1`,
+	}
+	bd := gomsg.Build()
+	obj, err := bd.Object(func(ob msg.ObjectBuilder) error {
+		return ob.AddMember("a", func(b msg.Builder) (msg.Msg, error) { return b.Bool(true) })
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	zero, err := bd.Int(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(t *testing.T, tree *ast.AST) string {
+		err := astvm.Interpreter(tree, &vm.Options{Strict: true}).Run(bd,
+			vmtest.ArraySource([]msg.Msg{obj, zero}),
+			func(msg.Msg) error { return nil },
+		)
+		if err == nil {
+			t.Fatal("want an error")
+		}
+		return err.Error()
+	}
+	for _, query := range tests {
+		t.Run(query, func(t *testing.T) {
+			tree, err := grammar.Parse(strings.NewReader(query))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := run(t, tree)
+			tree, err = grammar.Parse(strings.NewReader(query))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := run(t, optimize.Optimize(tree)); got != want {
+				t.Errorf("want=%s", want)
+				t.Errorf(" got=%s", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+// Package projection finds the parts of messages that a query reads,
+// so that the rest of them doesn't need to be decoded.
+package projection
+
+import (
+	"sort"
+
+	"github.com/aybabtme/streamql/lang/ast"
+	"github.com/aybabtme/streamql/lang/check"
+)
+
+// Step is a step of a path into a message: the member of an object
+// named Name, or if Any is set, any member of an object or element of
+// an array.
+type Step struct {
+	Name string
+	Any  bool
+}
+
+// Path leads from the root of a message to one of its parts. The empty
+// path is the whole message.
+type Path []Step
+
+// String returns the path as it would be selected in a query, like
+// `.user.id` or `.tags[]`.
+func (p Path) String() string {
+	if len(p) == 0 {
+		return "."
+	}
+	var root, sel *ast.Selector
+	for _, step := range p {
+		next := &ast.Selector{}
+		if step.Any {
+			next.Slice = &ast.SliceSelector{}
+		} else {
+			name := step.Name
+			next.Member = &ast.MemberSelector{Index: &ast.Expr{Literal: &ast.Literal{String: &name}}}
+		}
+		switch {
+		case root == nil:
+			root = next
+		case sel.Member != nil:
+			sel.Member.Child = next
+		default:
+			sel.Slice.Child = next
+		}
+		sel = next
+	}
+	return ast.Format(&ast.AST{Expr: &ast.Expr{Selector: root}})
+}
+
+// covers tells if a message at path q is part of the message at p.
+func (p Path) covers(q Path) bool {
+	if len(p) > len(q) {
+		return false
+	}
+	for i, step := range p {
+		if !step.Any && (q[i].Any || q[i].Name != step.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// Set is a set of paths, none of which is part of another.
+type Set []Path
+
+// Needs tells if a decoder must decode the part of a message at p,
+// either because it's read by the query, or because it holds a part
+// that is. Everything else can be skipped.
+func (s Set) Needs(p Path) bool {
+	for _, path := range s {
+		n := len(path)
+		if len(p) < n {
+			n = len(p)
+		}
+		needed := true
+		for i := 0; i < n; i++ {
+			if !path[i].Any && !p[i].Any && path[i].Name != p[i].Name {
+				needed = false
+				break
+			}
+		}
+		if needed {
+			return true
+		}
+	}
+	return false
+}
+
+// Paths returns the paths of the parts of its messages that a query
+// reads, using funcs to know how functions read their arguments. The
+// parts a query selects are only read where the selection ends: for
+// `.user.id`, only the id of the user is read, not the other members of
+// the user.
+func Paths(tree *ast.AST, funcs check.Funcs) Set {
+	a := &analyzer{funcs: funcs}
+	if tree.Expr == nil {
+		a.read(loc{ok: true})
+	} else {
+		a.read(a.expr(tree.Expr, loc{ok: true}))
+	}
+	return minimize(a.paths)
+}
+
+// loc is where a message comes from: the part of the input at path, if
+// ok, or otherwise somewhere that isn't part of the input, like the
+// result of an addition. If groups isn't zero, the message is made of
+// the part of the input at path: it's an array of groups, each one
+// like the part at path with one less groups, as what group_by emits.
+type loc struct {
+	path   Path
+	ok     bool
+	groups int
+}
+
+func (l loc) then(step Step) loc {
+	if !l.ok {
+		return l
+	}
+	if l.groups > 0 {
+		// a group is an array of elements of the part at path, so
+		// it's read as the part itself
+		l.groups--
+		return l
+	}
+	path := make(Path, len(l.path), len(l.path)+1)
+	copy(path, l.path)
+	return loc{path: append(path, step), ok: true}
+}
+
+type analyzer struct {
+	funcs check.Funcs
+	paths []Path
+}
+
+// read notes that the message at l is read whole.
+func (a *analyzer) read(l loc) {
+	if l.ok {
+		a.paths = append(a.paths, l.path)
+	}
+}
+
+// expr returns where the messages emitted by the pipeline starting at
+// e come from, when it runs on the message at in.
+func (a *analyzer) expr(e *ast.Expr, in loc) loc {
+	out := a.stage(e, in)
+	if e.Next != nil {
+		return a.expr(e.Next, out)
+	}
+	return out
+}
+
+func (a *analyzer) stage(e *ast.Expr, in loc) loc {
+	switch {
+	case e.Selector != nil:
+		return a.selector(e.Selector, in)
+	case e.UnaryOperator != nil:
+		a.read(a.expr(e.UnaryOperator.Arg, in))
+	case e.BinaryOperator != nil:
+		a.read(a.expr(e.BinaryOperator.LHS, in))
+		a.read(a.expr(e.BinaryOperator.RHS, in))
+	case e.FuncCall != nil:
+		return a.funcCall(e.FuncCall, in)
+	}
+	return loc{}
+}
+
+func (a *analyzer) selector(s *ast.Selector, in loc) loc {
+	var (
+		out   loc
+		child *ast.Selector
+	)
+	switch {
+	case s.Noop != nil:
+		return in
+	case s.Member != nil:
+		child = s.Member.Child
+		index := s.Member.Index
+		if lit := index.Literal; lit != nil && lit.String != nil && index.Next == nil {
+			out = in.then(Step{Name: *lit.String})
+		} else {
+			a.read(a.expr(index, in))
+			out = in.then(Step{Any: true})
+		}
+	case s.Slice != nil:
+		child = s.Slice.Child
+		if s.Slice.From != nil {
+			a.read(a.expr(s.Slice.From, in))
+		}
+		if s.Slice.To != nil {
+			a.read(a.expr(s.Slice.To, in))
+		}
+		out = in.then(Step{Any: true})
+	}
+	if child != nil {
+		return a.selector(child, out)
+	}
+	return out
+}
+
+func (a *analyzer) funcCall(f *ast.FuncCall, in loc) loc {
+	fn, ok := a.funcs(f.Name)
+	if !ok {
+		// can't know what it reads, so it might be everything
+		a.read(in)
+		for _, arg := range f.Args {
+			a.read(a.expr(arg, in))
+		}
+		return loc{}
+	}
+
+	// the arguments that aren't given are the current message
+	implicit := fn.Implicit(len(f.Args))
+	args := make([]loc, 0, len(fn.Params))
+	for i := 0; i < implicit; i++ {
+		args = append(args, in)
+	}
+	iterated := false
+	for i, arg := range f.Args {
+		if fn.OnElems(implicit + i) {
+			// the elements of the first argument are only read by the
+			// arguments that run on them
+			elems := args[0].then(Step{Any: true})
+			if fn.Generator && implicit == 0 {
+				elems = args[0]
+			}
+			a.read(a.expr(arg, elems))
+			args = append(args, loc{})
+			iterated = true
+			continue
+		}
+		args = append(args, a.expr(arg, in))
+	}
+	if fn.Compares && len(args) > 0 {
+		a.read(args[0])
+	}
+	switch {
+	case fn.Groups && len(args) > 0:
+		for _, arg := range args[1:] {
+			a.read(arg)
+		}
+		out := args[0]
+		out.groups++
+		return out
+	case fn.Passthrough && len(args) > 0:
+		for _, arg := range args[1:] {
+			a.read(arg)
+		}
+		return args[0]
+	case fn.Elem && len(args) > 0:
+		for _, arg := range args[1:] {
+			a.read(arg)
+		}
+		return args[0].then(Step{Any: true})
+	}
+	for i, arg := range args {
+		if i == 0 && iterated {
+			continue
+		}
+		a.read(arg)
+	}
+	return loc{}
+}
+
+// minimize sorts the paths and removes those that are part of others.
+func minimize(paths []Path) Set {
+	var set Set
+	for i, p := range paths {
+		covered := false
+		for j, q := range paths {
+			// of two equal paths, keep the first
+			if q.covers(p) && (!p.covers(q) || j < i) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			set = append(set, p)
+		}
+	}
+	sort.Slice(set, func(i, j int) bool { return set[i].String() < set[j].String() })
+	return set
+}
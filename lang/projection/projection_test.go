@@ -0,0 +1,189 @@
+package projection_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aybabtme/streamql/lang/ast"
+	"github.com/aybabtme/streamql/lang/grammar"
+	"github.com/aybabtme/streamql/lang/msg"
+	"github.com/aybabtme/streamql/lang/msg/gomsg"
+	"github.com/aybabtme/streamql/lang/msg/msgutil"
+	"github.com/aybabtme/streamql/lang/projection"
+	"github.com/aybabtme/streamql/lang/vm"
+	"github.com/aybabtme/streamql/lang/vm/astvm"
+	"github.com/aybabtme/streamql/lang/vm/vmtest"
+)
+
+func TestPaths(t *testing.T) {
+	tests := []struct {
+		args string
+		want []string
+	}{
+		{args: ``, want: []string{`.`}},
+		{args: `.`, want: []string{`.`}},
+		{args: `1 + 2`, want: nil},
+		{args: `.user.id`, want: []string{`.user.id`}},
+		{args: `.tags[]`, want: []string{`.tags[]`}},
+		{args: `.tags[1:3]`, want: []string{`.tags[]`}},
+		{args: `.tags[0]`, want: []string{`.tags[]`}},
+		{args: `."a key".b`, want: []string{`."a key".b`}},
+		{args: `.a | .b`, want: []string{`.a.b`}},
+		{args: `.a.b | . + 1`, want: []string{`.a.b`}},
+		{args: `.a == .b.c`, want: []string{`.a`, `.b.c`}},
+		{args: `.a[.k]`, want: []string{`.a[]`}},
+		{args: `.a[.k].b`, want: []string{`.a.k`, `.a[].b`}},
+		{args: `select(.age > 2)`, want: []string{`.`}},
+		{args: `select(.age > 2) | .name`, want: []string{`.age`, `.name`}},
+		{args: `select(.user, .keep) | .id`, want: []string{`.keep`, `.user.id`}},
+		{args: `.user | length`, want: []string{`.user`}},
+		{args: `.user | has("id")`, want: []string{`.user`}},
+		{args: `regexp(.name, .pattern)`, want: []string{`.name`, `.pattern`}},
+		{args: `.a.b | .a`, want: []string{`.a.b.a`}},
+		{args: `.a + .a.b`, want: []string{`.a`}},
+		{args: `.a[] | .b + .c`, want: []string{`.a[].b`, `.a[].c`}},
+		{args: `unknown(.a) | .b`, want: []string{`.`}},
+		{args: `max(.a)`, want: []string{`.a`}},
+		{args: `min_by(.items, .price) | .name`, want: []string{`.items[].name`, `.items[].price`}},
+		{args: `min_by(.items, .price) | .name + pow(.x, 2)`, want: []string{`.items[].name`, `.items[].price`, `.items[].x`}},
+		{args: `sort_by(.items, .price) | .[0].name`, want: []string{`.items[].name`, `.items[].price`}},
+		{args: `.a | sort | .[0].x`, want: []string{`.a`}},
+		{args: `.a | unique | .[0].x`, want: []string{`.a`}},
+		{args: `.a | reverse | .[0].x`, want: []string{`.a[].x`}},
+		{args: `.a | group_by(.k) | .[0][0].x`, want: []string{`.a[].k`, `.a[].x`}},
+		{args: `.a | group_by(.k) | map(.[0].x)`, want: []string{`.a[].k`, `.a[].x`}},
+		{args: `.a | group_by(.k) | length`, want: []string{`.a`}},
+		{args: `map(.items, .price)`, want: []string{`.items[].price`}},
+		{args: `any(.items[], .price > 1)`, want: []string{`.items[].price`}},
+		{args: `.items | all(.price > 1)`, want: []string{`.items[].price`}},
+		{args: `pick(.a, .b.c)`, want: []string{`.a`, `.b.c`}},
+		{args: `.a | objects | .b`, want: []string{`.a.b`}},
+		{args: `type(.a)`, want: []string{`.a`}},
+		{args: `to_entries(.a)`, want: []string{`.a`}},
+		{args: `url_parse(.a.url) | .path`, want: []string{`.a.url`}},
+		{args: `hash(.a.b) % 64`, want: []string{`.a.b`}},
+		{args: `dateadd(.a, .b) | todate`, want: []string{`.a`, `.b`}},
+		{args: `.a + .b | .c`, want: []string{`.a`, `.b`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			tree, err := grammar.Parse(strings.NewReader(tt.args))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, path := range projection.Paths(tree, astvm.Builtin) {
+				got = append(got, path.String())
+			}
+			if !reflect.DeepEqual(tt.want, got) {
+				t.Errorf("want=%q", tt.want)
+				t.Errorf(" got=%q", got)
+			}
+		})
+	}
+}
+
+// TestPathsOnPrunedInput checks that a query emits the same on its
+// input as on the parts of its input that it reads.
+func TestPathsOnPrunedInput(t *testing.T) {
+	input := `{"a": [{"k": 2, "x": 1, "y": "b"}, {"k": 1, "x": 3, "y": "a"}, {"k": 2, "x": 1, "y": "a"}], "b": {"c": 1}}`
+	queries := []string{
+		`.a | sort | .[0].x`,
+		`.a | unique | .[1].x`,
+		`.a | sort_by(.k) | .[0].x`,
+		`.a | unique_by(.k) | .[0].y`,
+		`.a | group_by(.k) | .[0][0].x`,
+		`.a | group_by(.k) | map(length)`,
+		`.a | group_by(.k) | .[1] | sort | .[0].y`,
+		`.a | min_by(.x) | .y`,
+		`.a | reverse | .[0].y`,
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(input), &v); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range queries {
+		t.Run(query, func(t *testing.T) {
+			tree, err := grammar.Parse(strings.NewReader(query))
+			if err != nil {
+				t.Fatal(err)
+			}
+			paths := projection.Paths(tree, astvm.Builtin)
+			want := run(t, tree, v)
+			got := run(t, tree, prune(paths, projection.Path{}, v))
+			if len(want) == 0 || !reflect.DeepEqual(want, got) {
+				t.Errorf("paths=%q", paths)
+				t.Errorf("want=%s", want)
+				t.Errorf(" got=%s", got)
+			}
+		})
+	}
+}
+
+// run runs the query on v, and returns what it emits in JSON.
+func run(t *testing.T, tree *ast.AST, v interface{}) []string {
+	build := gomsg.Build()
+	m, err := msgutil.FromGo(build, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	err = astvm.Interpreter(tree, &vm.Options{Strict: true}).Run(build, vmtest.ArraySource([]msg.Msg{m}), func(m msg.Msg) error {
+		out = append(out, string(msgutil.AppendCanonical(nil, m)))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// prune removes the parts of v, found at path, that paths doesn't need.
+func prune(paths projection.Set, path projection.Path, v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{})
+		for k, elem := range v {
+			elemPath := append(path[:len(path):len(path)], projection.Step{Name: k})
+			if paths.Needs(elemPath) {
+				out[k] = prune(paths, elemPath, elem)
+			}
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		elemPath := append(path[:len(path):len(path)], projection.Step{Any: true})
+		for i, elem := range v {
+			out[i] = prune(paths, elemPath, elem)
+		}
+		return out
+	}
+	return v
+}
+
+func TestNeeds(t *testing.T) {
+	set := projection.Set{
+		{{Name: "user"}, {Name: "id"}},
+		{{Name: "tags"}, {Any: true}},
+	}
+	tests := []struct {
+		path projection.Path
+		want bool
+	}{
+		{projection.Path{}, true},
+		{projection.Path{{Name: "user"}}, true},
+		{projection.Path{{Name: "user"}, {Name: "id"}}, true},
+		{projection.Path{{Name: "user"}, {Name: "id"}, {Name: "x"}}, true},
+		{projection.Path{{Name: "user"}, {Name: "name"}}, false},
+		{projection.Path{{Name: "tags"}, {Name: "x"}}, true},
+		{projection.Path{{Name: "tags"}, {Any: true}, {Name: "x"}}, true},
+		{projection.Path{{Name: "body"}}, false},
+	}
+	for _, tt := range tests {
+		if got := set.Needs(tt.path); got != tt.want {
+			t.Errorf("%v: want %v, got %v", tt.path, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,611 @@
+package astvm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aybabtme/streamql/lang/ast"
+	"github.com/aybabtme/streamql/lang/check"
+	"github.com/aybabtme/streamql/lang/msg"
+	"github.com/aybabtme/streamql/lang/msg/msgutil"
+)
+
+var (
+	arrayType = check.TypesOf(msg.TypeArray)
+
+	sigArrayToArray = &check.Func{
+		Arities:     []int{0, 1},
+		Params:      []check.Types{arrayType},
+		Passthrough: true,
+		Compares:    true,
+	}
+	sigArrayByToArray = &check.Func{
+		Arities:     []int{1, 2},
+		Params:      []check.Types{arrayType, check.AnyType},
+		Each:        []int{1},
+		Passthrough: true,
+	}
+	sigGroupBy = &check.Func{
+		Arities: []int{1, 2},
+		Params:  []check.Types{arrayType, check.AnyType},
+		Each:    []int{1},
+		Result:  arrayType,
+		Groups:  true,
+	}
+	sigReverse = &check.Func{
+		Arities:     []int{0, 1},
+		Params:      []check.Types{check.TypesOf(msg.TypeArray, msg.TypeString)},
+		Passthrough: true,
+	}
+	sigFlatten = &check.Func{
+		Arities:  []int{0, 1, 2},
+		Params:   []check.Types{arrayType, check.TypesOf(msg.TypeInt)},
+		Optional: 1,
+		Result:   arrayType,
+	}
+	sigAdd = &check.Func{
+		Arities: []int{0, 1},
+		Params:  []check.Types{arrayType},
+		Result:  check.TypesOf(msg.TypeInt, msg.TypeFloat, msg.TypeString, msg.TypeObject, msg.TypeNull),
+	}
+	sigAnyAll = &check.Func{
+		Arities:   []int{1, 2},
+		Params:    []check.Types{check.AnyType, check.TypesOf(msg.TypeBool)},
+		Each:      []int{1},
+		Generator: true,
+		Result:    check.TypesOf(msg.TypeBool),
+	}
+	sigMap = &check.Func{
+		Arities: []int{1, 2},
+		Params:  []check.Types{arrayType, check.AnyType},
+		Each:    []int{1},
+		Result:  arrayType,
+	}
+	sigIndex = &check.Func{
+		Arities: []int{1, 2},
+		Params:  []check.Types{check.TypesOf(msg.TypeString, msg.TypeArray), check.AnyType},
+		Result:  check.TypesOf(msg.TypeInt, msg.TypeNull),
+	}
+	sigInside = &check.Func{
+		Arities: []int{1, 2},
+		Params:  []check.Types{check.AnyType, check.AnyType},
+		Result:  check.TypesOf(msg.TypeBool),
+	}
+)
+
+// == sort(array) -> array ==
+// Emits the elements of the array in order: null, false, true, numbers,
+// strings, arrays, then objects.
+func (vm *ASTInterpreter) evalFuncSort(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	_, arr, ok, err := vm.implicitArgOrEvalExpr(build, "function sort", m, 0, args, msg.TypeArray)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	elems := arrayElems(arr)
+	sort.SliceStable(elems, func(i, j int) bool { return msgutil.Compare(elems[i], elems[j]) < 0 })
+	out, err := buildArray(build, elems)
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == sort_by(array, msg.Msg) -> array ==
+// Emits the elements of the array in the order of the expression, run
+// on each of them. Elements for which it's the same keep their order,
+// and those for which it emits nothing are ordered as if it was null.
+func (vm *ASTInterpreter) evalFuncSortBy(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	keyed, ok, err := vm.evalSortedBy(build, m, args, "function sort_by")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	elems := make([]msg.Msg, len(keyed))
+	for i, k := range keyed {
+		elems[i] = k.elem
+	}
+	out, err := buildArray(build, elems)
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == group_by(array, msg.Msg) -> array ==
+// Emits an array of arrays of the elements for which the expression,
+// run on each of them, is the same, in the order of the expression.
+// The elements for which it emits nothing are grouped with those for
+// which it's null.
+func (vm *ASTInterpreter) evalFuncGroupBy(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	keyed, ok, err := vm.evalSortedBy(build, m, args, "function group_by")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	var groups [][]msg.Msg
+	for i, k := range keyed {
+		if i == 0 || !msgutil.Equal(keyed[i-1].key, k.key) {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], k.elem)
+	}
+	out, err := build.Array(func(ab msg.ArrayBuilder) error {
+		for _, group := range groups {
+			err := ab.AddElem(func(b msg.Builder) (msg.Msg, error) {
+				return buildArray(b, group)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == unique(array) -> array ==
+// Emits the elements of the array in order, without those that are
+// equal to another.
+func (vm *ASTInterpreter) evalFuncUnique(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	_, arr, ok, err := vm.implicitArgOrEvalExpr(build, "function unique", m, 0, args, msg.TypeArray)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	elems := arrayElems(arr)
+	sort.SliceStable(elems, func(i, j int) bool { return msgutil.Compare(elems[i], elems[j]) < 0 })
+	var uniq []msg.Msg
+	for i, elem := range elems {
+		if i == 0 || !msgutil.Equal(elems[i-1], elem) {
+			uniq = append(uniq, elem)
+		}
+	}
+	out, err := buildArray(build, uniq)
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == unique_by(array, msg.Msg) -> array ==
+// Emits the first of the elements of the array for which the
+// expression, run on each of them, is the same, in the order of the
+// expression. An expression that emits nothing is taken to be null.
+func (vm *ASTInterpreter) evalFuncUniqueBy(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	keyed, ok, err := vm.evalSortedBy(build, m, args, "function unique_by")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	var uniq []msg.Msg
+	for i, k := range keyed {
+		if i == 0 || !msgutil.Equal(keyed[i-1].key, k.key) {
+			uniq = append(uniq, k.elem)
+		}
+	}
+	out, err := buildArray(build, uniq)
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == reverse(array|string) -> array|string ==
+// Emits the elements of the array, or the letters of the string, from
+// the last to the first.
+func (vm *ASTInterpreter) evalFuncReverse(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	_, arg, ok, err := vm.implicitArgOrEvalExpr(build, "function reverse", m, 0, args, msg.TypeArray, msg.TypeString)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	var out msg.Msg
+	switch arg.Type() {
+	case msg.TypeArray:
+		elems := arrayElems(arg)
+		for i, j := 0, len(elems)-1; i < j; i, j = i+1, j-1 {
+			elems[i], elems[j] = elems[j], elems[i]
+		}
+		out, err = buildArray(build, elems)
+	case msg.TypeString:
+		runes := []rune(arg.StringVal())
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		out, err = build.String(string(runes))
+	}
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == flatten(array, depth int) -> array ==
+// Emits the array with the arrays it holds replaced by their elements,
+// as deep as the depth, or all the way down if it's left out. The depth
+// can only be given after the array, like `flatten(., 1)`: the one
+// argument of `flatten(1)` is the array.
+func (vm *ASTInterpreter) evalFuncFlatten(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	args, arr, ok, err := vm.implicitArgOrEvalExpr(build, "function flatten", m, 0, args, msg.TypeArray)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	depth := int64(-1)
+	if len(args) > 0 {
+		depthMsg, ok, err := vm.evalExprToMsgType(build, m, args[0], "function flatten", msg.TypeInt)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		if depth = depthMsg.IntVal(); depth < 0 {
+			return vm.skipEvalWrongArgValue("function flatten", depthMsg.Type(), "depth can't be negative")
+		}
+	}
+	out, err := buildArray(build, flatten(nil, arr, depth))
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// flatten appends the elements of arr to elems, replacing the arrays
+// by their elements as deep as depth, or all the way if it's negative.
+func flatten(elems []msg.Msg, arr msg.Msg, depth int64) []msg.Msg {
+	for i := int64(0); i < arr.Len(); i++ {
+		elem := arr.Index(i)
+		if elem.Type() == msg.TypeArray && depth != 0 {
+			elems = flatten(elems, elem, depth-1)
+			continue
+		}
+		elems = append(elems, elem)
+	}
+	return elems
+}
+
+// == add(array) -> int|float|string|object|null ==
+// Emits the elements of the array added together, as with `+`, leaving
+// out those that are null. Emits null if there's nothing to add.
+func (vm *ASTInterpreter) evalFuncAdd(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	_, arr, ok, err := vm.implicitArgOrEvalExpr(build, "function add", m, 0, args, msg.TypeArray)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	var sum msg.Msg
+	for i := int64(0); i < arr.Len(); i++ {
+		elem := arr.Index(i)
+		switch elem.Type() {
+		case msg.TypeNull:
+			continue
+		case msg.TypeInt, msg.TypeFloat, msg.TypeString, msg.TypeObject:
+		default:
+			return vm.skipEvalWrongArgValue("function add", arr.Type(), fmt.Sprintf("element %d is a %v", i, elem.Type()))
+		}
+		if sum == nil {
+			sum = elem
+			continue
+		}
+		if sum, err = vm.evalAddition(build, sum, elem); err != nil {
+			return err
+		}
+	}
+	if sum == nil {
+		if sum, err = build.Null(); err != nil {
+			return err
+		}
+	}
+	return sink(sum)
+}
+
+// errStopEarly stops a generator once what it emits isn't needed.
+var errStopEarly = errors.New("stop early")
+
+// == any(msg.Msg, bool) -> bool ==
+// Emits a boolean: if the expression is true for any of the elements of
+// the array. Given a generator, like `any(.[], . > 3)`, it runs on each
+// message the generator emits instead, and stops the generator at the
+// first one for which it's true.
+func (vm *ASTInterpreter) evalFuncAny(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalQuantifier(build, m, args, sink, "function any", true)
+}
+
+// == all(msg.Msg, bool) -> bool ==
+// Emits a boolean: if the expression is true for all the elements of
+// the array. Given a generator, like `all(.[], . > 3)`, it runs on each
+// message the generator emits instead, and stops the generator at the
+// first one for which it's false.
+func (vm *ASTInterpreter) evalFuncAll(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalQuantifier(build, m, args, sink, "function all", false)
+}
+
+// evalQuantifier evaluates any if stopOn is true, or all if it's false:
+// the result is stopOn as soon as the condition is stopOn for a message.
+func (vm *ASTInterpreter) evalQuantifier(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink, action string, stopOn bool) error {
+	defer trace()()
+
+	cond := args[len(args)-1]
+	found := false
+	test := func(elem msg.Msg) error {
+		v, ok, err := vm.evalExprToMsgType(build, elem, cond, action, msg.TypeBool)
+		if err != nil {
+			return err
+		}
+		if ok && v.BoolVal() == stopOn {
+			found = true
+			return errStopEarly
+		}
+		return nil
+	}
+
+	var err error
+	if len(args) == 1 {
+		if m.Type() != msg.TypeArray {
+			return vm.skipEvalWrongType(action, m.Type(), msg.TypeArray)
+		}
+		for i := int64(0); i < m.Len() && err == nil; i++ {
+			err = test(m.Index(i))
+		}
+	} else {
+		err = vm.evalExpr(build, m, args[0], test)
+	}
+	if err != nil && err != errStopEarly {
+		return err
+	}
+	out, err := build.Bool(found == stopOn)
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == map(array, msg.Msg) -> array ==
+// Emits an array of what the expression emits when it runs on each
+// element of the array.
+func (vm *ASTInterpreter) evalFuncMap(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	args, arr, ok, err := vm.implicitArgOrEvalExpr(build, "function map", m, 1, args, msg.TypeArray)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	var mapped []msg.Msg
+	for i := int64(0); i < arr.Len(); i++ {
+		err := vm.evalExpr(build, arr.Index(i), args[0], func(out msg.Msg) error {
+			mapped = append(mapped, out)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	out, err := buildArray(build, mapped)
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == index(string|array, msg.Msg) -> int|null ==
+// Emits where the substring first is in the string, in bytes, or where
+// the element first is in the array. Emits null if it isn't there.
+func (vm *ASTInterpreter) evalFuncIndex(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	args, in, ok, err := vm.implicitArgOrEvalExpr(build, "function index", m, 1, args, msg.TypeString, msg.TypeArray)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	idx := int64(-1)
+	switch in.Type() {
+	case msg.TypeString:
+		sub, ok, err := vm.evalExprToMsgType(build, m, args[0], "function index", msg.TypeString)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		idx = int64(strings.Index(in.StringVal(), sub.StringVal()))
+	case msg.TypeArray:
+		elem, ok, err := vm.evalExprToMsg(build, m, args[0])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		for i := int64(0); i < in.Len(); i++ {
+			if msgutil.Equal(in.Index(i), elem) {
+				idx = i
+				break
+			}
+		}
+	}
+	var out msg.Msg
+	if idx < 0 {
+		out, err = build.Null()
+	} else {
+		out, err = build.Int(idx)
+	}
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == inside(a, b msg.Msg) -> bool ==
+// Emits a boolean: if a is inside b. A string is inside another if it's
+// a substring of it, an array if each of its elements is inside one of
+// the other's, and an object if each of its members is inside the
+// other's member of the same name. Other messages must be equal.
+func (vm *ASTInterpreter) evalFuncInside(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	a := m
+	if len(args) == 2 {
+		var (
+			ok  bool
+			err error
+		)
+		if a, ok, err = vm.evalExprToMsg(build, m, args[0]); err != nil || !ok {
+			return err
+		}
+	}
+	b, ok, err := vm.evalExprToMsg(build, m, args[len(args)-1])
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	out, err := build.Bool(inside(a, b))
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+func inside(a, b msg.Msg) bool {
+	if a.Type() != b.Type() {
+		return msgutil.Equal(a, b)
+	}
+	switch a.Type() {
+	case msg.TypeString:
+		return strings.Contains(b.StringVal(), a.StringVal())
+	case msg.TypeArray:
+		for i := int64(0); i < a.Len(); i++ {
+			found := false
+			for j := int64(0); j < b.Len() && !found; j++ {
+				found = inside(a.Index(i), b.Index(j))
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	case msg.TypeObject:
+		for _, k := range a.Keys() {
+			av, _ := a.Member(k)
+			bv, ok := b.Member(k)
+			if !ok || !inside(av, bv) {
+				return false
+			}
+		}
+		return true
+	}
+	return msgutil.Equal(a, b)
+}
+
+// helper
+
+// keyedElem is an element of an array, with the key it's sorted by.
+type keyedElem struct {
+	elem, key msg.Msg
+}
+
+// evalSortedBy evaluates the key of each element of an array, and sorts
+// them by key. Elements with the same key keep their order.
+func (vm *ASTInterpreter) evalSortedBy(build msg.Builder, m msg.Msg, args []*ast.Expr, action string) ([]keyedElem, bool, error) {
+	defer trace()()
+
+	args, arr, ok, err := vm.implicitArgOrEvalExpr(build, action, m, 1, args, msg.TypeArray)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	keyed := make([]keyedElem, 0, arr.Len())
+	for i := int64(0); i < arr.Len(); i++ {
+		elem := arr.Index(i)
+		key, err := vm.evalKey(build, elem, args[0])
+		if err != nil {
+			return nil, false, err
+		}
+		keyed = append(keyed, keyedElem{elem: elem, key: key})
+	}
+	sort.SliceStable(keyed, func(i, j int) bool { return msgutil.Compare(keyed[i].key, keyed[j].key) < 0 })
+	return keyed, true, nil
+}
+
+// evalKey evaluates the key of an element of an array, by which it's
+// ordered. The key is null if the expression emits nothing, as if it
+// selected a member that isn't there.
+func (vm *ASTInterpreter) evalKey(build msg.Builder, elem msg.Msg, expr *ast.Expr) (msg.Msg, error) {
+	defer trace()()
+	key, ok, err := vm.evalExprToMsg(build, elem, expr)
+	if err != nil || ok {
+		return key, err
+	}
+	return build.Null()
+}
+
+// arrayElems returns the elements of an array.
+func arrayElems(arr msg.Msg) []msg.Msg {
+	elems := make([]msg.Msg, arr.Len())
+	for i := range elems {
+		elems[i] = arr.Index(int64(i))
+	}
+	return elems
+}
+
+// buildArray builds an array of elems.
+func buildArray(build msg.Builder, elems []msg.Msg) (msg.Msg, error) {
+	return build.Array(func(ab msg.ArrayBuilder) error {
+		for _, elem := range elems {
+			err := ab.AddElem(func(msg.Builder) (msg.Msg, error) {
+				return elem, nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,126 @@
+package astvm
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/crc32"
+	"hash/fnv"
+	"math"
+
+	"github.com/aybabtme/streamql/lang/ast"
+	"github.com/aybabtme/streamql/lang/check"
+	"github.com/aybabtme/streamql/lang/msg"
+	"github.com/aybabtme/streamql/lang/msg/msgutil"
+)
+
+// The hash functions hash the bytes of a string, so that its hash is the
+// one other programs give, or the canonical encoding of any other message
+// (see msgutil.AppendCanonical), so that messages that are alike hash
+// alike, whichever builder made them. The canonical encoding comes after
+// a 0xff byte, which is never in a UTF-8 string, so that the string "1"
+// and the int 1 don't hash alike. The hashes that are ints are never
+// negative, so they can be used to pick among n partitions with
+// `hash(.id) % n`.
+
+var (
+	sigHashToInt = &check.Func{
+		Arities: []int{0, 1},
+		Params:  []check.Types{check.AnyType},
+		Result:  check.TypesOf(msg.TypeInt),
+	}
+	sigHashToString = &check.Func{
+		Arities: []int{0, 1},
+		Params:  []check.Types{check.AnyType},
+		Result:  stringType,
+	}
+)
+
+// == hash(msg.Msg) -> int ==
+// Emits a hash of the message. It's the one to use when any hash will
+// do; for now it's xxhash.
+func (vm *ASTInterpreter) evalFuncHash(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalHash(build, m, args, sink, sumXxhash)
+}
+
+// == xxhash(msg.Msg) -> int ==
+// Emits the XXH64 hash of the message, with its top bit cleared.
+func (vm *ASTInterpreter) evalFuncXxhash(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalHash(build, m, args, sink, sumXxhash)
+}
+
+// == fnv(msg.Msg) -> int ==
+// Emits the 64 bits FNV-1a hash of the message, with its top bit
+// cleared.
+func (vm *ASTInterpreter) evalFuncFnv(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalHash(build, m, args, sink, func(b msg.Builder, data []byte) (msg.Msg, error) {
+		h := fnv.New64a()
+		h.Write(data)
+		return b.Int(int64(h.Sum64() & math.MaxInt64))
+	})
+}
+
+// == crc32(msg.Msg) -> int ==
+// Emits the CRC-32 checksum of the message, with the IEEE polynomial.
+func (vm *ASTInterpreter) evalFuncCrc32(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalHash(build, m, args, sink, func(b msg.Builder, data []byte) (msg.Msg, error) {
+		return b.Int(int64(crc32.ChecksumIEEE(data)))
+	})
+}
+
+// == sha256(msg.Msg) -> string ==
+// Emits the SHA-256 hash of the message, in hexadecimal.
+func (vm *ASTInterpreter) evalFuncSha256(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalHash(build, m, args, sink, func(b msg.Builder, data []byte) (msg.Msg, error) {
+		sum := sha256.Sum256(data)
+		return b.String(hex.EncodeToString(sum[:]))
+	})
+}
+
+// == md5(msg.Msg) -> string ==
+// Emits the MD5 hash of the message, in hexadecimal.
+func (vm *ASTInterpreter) evalFuncMd5(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalHash(build, m, args, sink, func(b msg.Builder, data []byte) (msg.Msg, error) {
+		sum := md5.Sum(data)
+		return b.String(hex.EncodeToString(sum[:]))
+	})
+}
+
+// helper
+
+// canonicalTag comes before the canonical encoding of the messages that
+// aren't strings when they're hashed.
+const canonicalTag = 0xff
+
+func (vm *ASTInterpreter) evalHash(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink, sum func(msg.Builder, []byte) (msg.Msg, error)) error {
+	defer trace()()
+
+	arg, ok, err := vm.implicitArgOrEvalAny(build, m, args)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	var data []byte
+	if arg.Type() == msg.TypeString {
+		data = []byte(arg.StringVal())
+	} else {
+		data = msgutil.AppendCanonical([]byte{canonicalTag}, arg)
+	}
+	out, err := sum(build, data)
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+func sumXxhash(b msg.Builder, data []byte) (msg.Msg, error) {
+	return b.Int(int64(xxhash64(data) & math.MaxInt64))
+}
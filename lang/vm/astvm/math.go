@@ -0,0 +1,406 @@
+package astvm
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/aybabtme/streamql/lang/ast"
+	"github.com/aybabtme/streamql/lang/check"
+	"github.com/aybabtme/streamql/lang/msg"
+	"github.com/aybabtme/streamql/lang/msg/msgutil"
+)
+
+// The math functions follow the rules of the numerical operators: they
+// emit an int when all their arguments are ints and the result can be
+// one, and a float otherwise. NaN and infinite arguments go through
+// them like any other float, but a function that would give NaN or an
+// infinite result from finite arguments, like `sqrt(-1)` or `log(0)`,
+// can't be done and the message is skipped.
+
+var (
+	numberType = check.TypesOf(msg.TypeInt, msg.TypeFloat)
+
+	sigNumberToNumber = &check.Func{
+		Arities: []int{0, 1},
+		Params:  []check.Types{numberType},
+		Result:  numberType,
+	}
+	sigNumberToFloat = &check.Func{
+		Arities: []int{0, 1},
+		Params:  []check.Types{numberType},
+		Result:  check.TypesOf(msg.TypeFloat),
+	}
+	sigPow = &check.Func{
+		Arities: []int{1, 2},
+		Params:  []check.Types{numberType, numberType},
+		Result:  numberType,
+	}
+	sigMinMax = &check.Func{
+		Arities: []int{0, 1},
+		Params:  []check.Types{check.TypesOf(msg.TypeArray)},
+		Result:  check.TypesOf(msg.TypeInt, msg.TypeFloat, msg.TypeNull),
+	}
+	sigClamp = &check.Func{
+		Arities: []int{2, 3},
+		Params:  []check.Types{numberType, numberType, numberType},
+		Result:  numberType,
+	}
+	sigMinMaxBy = &check.Func{
+		Arities: []int{1, 2},
+		Params:  []check.Types{check.TypesOf(msg.TypeArray), check.AnyType},
+		Each:    []int{1},
+		Elem:    true,
+	}
+)
+
+// == floor(number) -> number ==
+// Emits the greatest integer that isn't greater than the number.
+func (vm *ASTInterpreter) evalFuncFloor(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalRounding(build, m, args, sink, "function floor", math.Floor)
+}
+
+// == ceil(number) -> number ==
+// Emits the least integer that isn't less than the number.
+func (vm *ASTInterpreter) evalFuncCeil(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalRounding(build, m, args, sink, "function ceil", math.Ceil)
+}
+
+// == round(number) -> number ==
+// Emits the integer nearest to the number, away from zero for halves.
+func (vm *ASTInterpreter) evalFuncRound(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalRounding(build, m, args, sink, "function round", math.Round)
+}
+
+// == abs(number) -> number ==
+// Emits the absolute value of the number.
+func (vm *ASTInterpreter) evalFuncAbs(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	_, x, ok, err := vm.implicitArgOrEvalExpr(build, "function abs", m, 0, args, msg.TypeInt, msg.TypeFloat)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	var v msg.Msg
+	switch x.Type() {
+	case msg.TypeInt:
+		switch i := x.IntVal(); {
+		case i == math.MinInt64:
+			return vm.skipEvalWrongArgValue("function abs", x.Type(), "the result overflows an int")
+		case i < 0:
+			v, err = build.Int(-i)
+		default:
+			v = x
+		}
+	case msg.TypeFloat:
+		v, err = build.Float(math.Abs(x.FloatVal()))
+	}
+	if err != nil {
+		return err
+	}
+	return sink(v)
+}
+
+// == sqrt(number) -> float ==
+// Emits the square root of the number, which can't be negative.
+func (vm *ASTInterpreter) evalFuncSqrt(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalFloatFunc(build, m, args, sink, "function sqrt", math.Sqrt)
+}
+
+// == log(number) -> float ==
+// Emits the natural logarithm of the number, which must be positive.
+func (vm *ASTInterpreter) evalFuncLog(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalFloatFunc(build, m, args, sink, "function log", math.Log)
+}
+
+// == exp(number) -> float ==
+// Emits e to the power of the number.
+func (vm *ASTInterpreter) evalFuncExp(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalFloatFunc(build, m, args, sink, "function exp", math.Exp)
+}
+
+// == pow(x, y number) -> number ==
+// Emits x to the power of y. It's an int if both are ints and y isn't
+// negative.
+func (vm *ASTInterpreter) evalFuncPow(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	args, x, ok, err := vm.implicitArgOrEvalExpr(build, "function pow", m, 1, args, msg.TypeInt, msg.TypeFloat)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	y, ok, err := vm.evalExprToMsgType(build, m, args[0], "function pow", msg.TypeInt, msg.TypeFloat)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+
+	if x.Type() == msg.TypeInt && y.Type() == msg.TypeInt && y.IntVal() >= 0 {
+		v, ok := powInt(x.IntVal(), y.IntVal())
+		if !ok {
+			return vm.skipEvalWrongArgValue("function pow", y.Type(), "the result overflows an int")
+		}
+		out, err := build.Int(v)
+		if err != nil {
+			return err
+		}
+		return sink(out)
+	}
+	xf, yf := floatVal(x), floatVal(y)
+	v := math.Pow(xf, yf)
+	if err := vm.checkFloatResult("function pow", y.Type(), v, xf, yf); err != nil {
+		return err
+	}
+	out, err := build.Float(v)
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// powInt raises x to the power of y, which isn't negative, unless the
+// result overflows an int.
+func powInt(x, y int64) (int64, bool) {
+	switch {
+	case y == 0, x == 1:
+		return 1, true
+	case x == 0:
+		return 0, true
+	case x == -1:
+		return 1 - 2*(y%2), true
+	}
+	// |x| is at least 2, so this overflows in at most 63 steps
+	v := int64(1)
+	for ; y > 0; y-- {
+		next := v * x
+		if next/x != v {
+			return 0, false
+		}
+		v = next
+	}
+	return v, true
+}
+
+// == min(array) -> number|null ==
+// Emits the smallest number of the array, or null if it's empty. NaN is
+// smaller than all the other numbers.
+func (vm *ASTInterpreter) evalFuncMin(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalExtremum(build, m, args, sink, "function min", -1)
+}
+
+// == max(array) -> number|null ==
+// Emits the greatest number of the array, or null if it's empty.
+func (vm *ASTInterpreter) evalFuncMax(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalExtremum(build, m, args, sink, "function max", 1)
+}
+
+// == clamp(x, min, max number) -> number ==
+// Emits x if it's between min and max, or otherwise the one of them
+// that it's past. NaN is less than all the other numbers, so it's past
+// min.
+func (vm *ASTInterpreter) evalFuncClamp(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	args, x, ok, err := vm.implicitArgOrEvalExpr(build, "function clamp", m, 2, args, msg.TypeInt, msg.TypeFloat)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	lo, ok, err := vm.evalExprToMsgType(build, m, args[0], "function clamp", msg.TypeInt, msg.TypeFloat)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	hi, ok, err := vm.evalExprToMsgType(build, m, args[1], "function clamp", msg.TypeInt, msg.TypeFloat)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	if msgutil.Compare(lo, hi) > 0 {
+		return vm.skipEvalWrongArgValue("function clamp", hi.Type(), "max is less than min")
+	}
+	switch {
+	case msgutil.Compare(x, lo) < 0:
+		return sink(lo)
+	case msgutil.Compare(x, hi) > 0:
+		return sink(hi)
+	}
+	return sink(x)
+}
+
+// == min_by(array, msg.Msg) -> msg.Msg ==
+// Emits the element of the array for which the expression is the
+// smallest, running it on each element, in the order of sort_by. Emits
+// null if the array is empty. An expression that emits nothing is taken
+// to be null.
+func (vm *ASTInterpreter) evalFuncMinBy(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalExtremumBy(build, m, args, sink, "function min_by", -1)
+}
+
+// == max_by(array, msg.Msg) -> msg.Msg ==
+// Emits the element of the array for which the expression is the
+// greatest, running it on each element, in the order of sort_by. Emits
+// null if the array is empty. An expression that emits nothing is taken
+// to be null.
+func (vm *ASTInterpreter) evalFuncMaxBy(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalExtremumBy(build, m, args, sink, "function max_by", 1)
+}
+
+// helper
+
+// evalRounding evaluates a function that rounds a float to an integer.
+// Ints are already integers and are emitted as they are.
+func (vm *ASTInterpreter) evalRounding(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink, action string, round func(float64) float64) error {
+	defer trace()()
+
+	_, x, ok, err := vm.implicitArgOrEvalExpr(build, action, m, 0, args, msg.TypeInt, msg.TypeFloat)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	if x.Type() == msg.TypeInt {
+		return sink(x)
+	}
+	v, err := build.Float(round(x.FloatVal()))
+	if err != nil {
+		return err
+	}
+	return sink(v)
+}
+
+// evalFloatFunc evaluates a function of a number that emits a float.
+func (vm *ASTInterpreter) evalFloatFunc(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink, action string, fn func(float64) float64) error {
+	defer trace()()
+
+	_, x, ok, err := vm.implicitArgOrEvalExpr(build, action, m, 0, args, msg.TypeInt, msg.TypeFloat)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	xf := floatVal(x)
+	v := fn(xf)
+	if err := vm.checkFloatResult(action, x.Type(), v, xf); err != nil {
+		return err
+	}
+	out, err := build.Float(v)
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// checkFloatResult returns a skipable error if v is NaN or infinite
+// while none of the arguments it came from were.
+func (vm *ASTInterpreter) checkFloatResult(action string, arg msg.Type, v float64, args ...float64) error {
+	for _, a := range args {
+		if math.IsNaN(a) || math.IsInf(a, 0) {
+			return nil
+		}
+	}
+	switch {
+	case math.IsNaN(v):
+		return vm.skipEvalWrongArgValue(action, arg, "the result isn't a number")
+	case math.IsInf(v, 0):
+		return vm.skipEvalWrongArgValue(action, arg, "the result is infinite")
+	}
+	return nil
+}
+
+// evalExtremum emits the smallest number of an array if sign is
+// negative, or the greatest if it's positive.
+func (vm *ASTInterpreter) evalExtremum(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink, action string, sign int) error {
+	defer trace()()
+
+	_, arr, ok, err := vm.implicitArgOrEvalExpr(build, action, m, 0, args, msg.TypeArray)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	var best msg.Msg
+	for i := int64(0); i < arr.Len(); i++ {
+		elem := arr.Index(i)
+		if t := elem.Type(); t != msg.TypeInt && t != msg.TypeFloat {
+			return vm.skipEvalWrongArgValue(action, arr.Type(), fmt.Sprintf("element %d is a %v", i, t))
+		}
+		if best == nil || msgutil.Compare(elem, best)*sign > 0 {
+			best = elem
+		}
+	}
+	if best == nil {
+		best, err = build.Null()
+		if err != nil {
+			return err
+		}
+	}
+	return sink(best)
+}
+
+// evalExtremumBy emits the element of an array for which an expression
+// is the smallest if sign is negative, or the greatest if it's
+// positive.
+func (vm *ASTInterpreter) evalExtremumBy(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink, action string, sign int) error {
+	defer trace()()
+
+	args, arr, ok, err := vm.implicitArgOrEvalExpr(build, action, m, 1, args, msg.TypeArray)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	var best, bestKey msg.Msg
+	for i := int64(0); i < arr.Len(); i++ {
+		elem := arr.Index(i)
+		key, err := vm.evalKey(build, elem, args[0])
+		if err != nil {
+			return err
+		}
+		if best == nil || msgutil.Compare(key, bestKey)*sign > 0 {
+			best, bestKey = elem, key
+		}
+	}
+	if best == nil {
+		best, err = build.Null()
+		if err != nil {
+			return err
+		}
+	}
+	return sink(best)
+}
+
+// floatVal returns the value of a number as a float.
+func floatVal(m msg.Msg) float64 {
+	if m.Type() == msg.TypeInt {
+		return float64(m.IntVal())
+	}
+	return m.FloatVal()
+}
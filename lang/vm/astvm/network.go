@@ -0,0 +1,153 @@
+package astvm
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/aybabtme/streamql/lang/ast"
+	"github.com/aybabtme/streamql/lang/check"
+	"github.com/aybabtme/streamql/lang/msg"
+)
+
+var (
+	sigIPVersion = &check.Func{
+		Arities: []int{0, 1},
+		Params:  []check.Types{stringType},
+		Result:  check.TypesOf(msg.TypeInt),
+	}
+	sigStringToBool = &check.Func{
+		Arities: []int{0, 1},
+		Params:  []check.Types{stringType},
+		Result:  check.TypesOf(msg.TypeBool),
+	}
+)
+
+// == ip_parse(string) -> string ==
+// Emits the IP address written the usual way: IPv4 in dotted decimal,
+// and IPv6 in lower case with its longest run of zeros cut short, like
+// "2001:db8::1".
+func (vm *ASTInterpreter) evalFuncIPParse(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	addr, ok, err := vm.evalAddr(build, m, args, "function ip_parse")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	out, err := build.String(addr.String())
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == ip_version(string) -> int ==
+// Emits the version of the IP address, 4 or 6. An IPv4 address mapped
+// in IPv6, like "::ffff:10.0.0.1", is of version 6.
+func (vm *ASTInterpreter) evalFuncIPVersion(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	addr, ok, err := vm.evalAddr(build, m, args, "function ip_version")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	version := int64(6)
+	if addr.Is4() {
+		version = 4
+	}
+	out, err := build.Int(version)
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == in_cidr(ip, cidr string) -> bool ==
+// Emits a boolean: if the IP address is in the range of the CIDR, like
+// "10.0.0.0/8" or "fc00::/7". IPv4 addresses mapped in IPv6 are in the
+// ranges of the IPv4 addresses they map.
+func (vm *ASTInterpreter) evalFuncInCIDR(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	strs, ok, err := vm.evalStrings(build, m, args, "function in_cidr", 1)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	addr, err := netip.ParseAddr(strs[0])
+	if err != nil {
+		return vm.skipEvalWrongArgValue("function in_cidr", msg.TypeString, fmt.Sprintf("invalid IP address %q", strs[0]))
+	}
+	prefix, err := netip.ParsePrefix(strs[1])
+	if err != nil {
+		return vm.skipEvalWrongArgValue("function in_cidr", msg.TypeString, fmt.Sprintf("invalid CIDR %q", strs[1]))
+	}
+	if prefix.Addr().Is4() {
+		addr = addr.Unmap()
+	}
+	out, err := build.Bool(prefix.Contains(addr.WithZone("")))
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == is_private(string) -> bool ==
+// Emits a boolean: if the IP address is private, in 10.0.0.0/8,
+// 172.16.0.0/12, 192.168.0.0/16 or fc00::/7.
+func (vm *ASTInterpreter) evalFuncIsPrivate(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalAddrIs(build, m, args, sink, "function is_private", netip.Addr.IsPrivate)
+}
+
+// == is_loopback(string) -> bool ==
+// Emits a boolean: if the IP address is a loopback one, in 127.0.0.0/8
+// or ::1.
+func (vm *ASTInterpreter) evalFuncIsLoopback(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalAddrIs(build, m, args, sink, "function is_loopback", netip.Addr.IsLoopback)
+}
+
+// helper
+
+// evalAddr evaluates the only argument of a function that takes an IP
+// address, the current message if it isn't given.
+func (vm *ASTInterpreter) evalAddr(build msg.Builder, m msg.Msg, args []*ast.Expr, action string) (netip.Addr, bool, error) {
+	defer trace()()
+
+	strs, ok, err := vm.evalStrings(build, m, args, action, 0)
+	if err != nil || !ok {
+		return netip.Addr{}, ok, err
+	}
+	addr, err := netip.ParseAddr(strs[0])
+	if err != nil {
+		return netip.Addr{}, false, vm.skipEvalWrongArgValue(action, msg.TypeString, fmt.Sprintf("invalid IP address %q", strs[0]))
+	}
+	return addr, true, nil
+}
+
+// evalAddrIs evaluates if an IP address is of some kind. IPv4 addresses
+// mapped in IPv6 are of the kind of the IPv4 addresses they map.
+func (vm *ASTInterpreter) evalAddrIs(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink, action string, is func(netip.Addr) bool) error {
+	defer trace()()
+
+	addr, ok, err := vm.evalAddr(build, m, args, action)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	out, err := build.Bool(is(addr.Unmap()))
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
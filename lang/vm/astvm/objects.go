@@ -0,0 +1,388 @@
+package astvm
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/aybabtme/streamql/lang/ast"
+	"github.com/aybabtme/streamql/lang/check"
+	"github.com/aybabtme/streamql/lang/msg"
+)
+
+// maxPickPaths is how many paths a single call to pick can take.
+const maxPickPaths = 8
+
+var (
+	objectType = check.TypesOf(msg.TypeObject)
+
+	sigToEntries = &check.Func{
+		Arities: []int{0, 1},
+		Params:  []check.Types{objectType},
+		Result:  arrayType,
+	}
+	sigFromEntries = &check.Func{
+		Arities: []int{0, 1},
+		Params:  []check.Types{arrayType},
+		Result:  objectType,
+	}
+	sigWithEntries = &check.Func{
+		Arities: []int{1, 2},
+		Params:  []check.Types{objectType, check.AnyType},
+		Result:  objectType,
+	}
+	sigPick = func() *check.Func {
+		sig := &check.Func{Optional: maxPickPaths - 1, Result: objectType}
+		for i := 1; i <= maxPickPaths; i++ {
+			sig.Arities = append(sig.Arities, i)
+			sig.Params = append(sig.Params, check.AnyType)
+		}
+		return sig
+	}()
+)
+
+// == keys_unsorted(object|array) -> array ==
+// Emits an array of the keys of an object, in the order they're in, or
+// the indices of an array.
+func (vm *ASTInterpreter) evalFuncKeysUnsorted(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalKeys(build, m, args, sink, "function keys_unsorted", false)
+}
+
+// == to_entries(object) -> array ==
+// Emits an array of objects, one for each member of the object, with
+// its key and its value:
+//
+//	{"a": 1} -> [{"key": "a", "value": 1}]
+func (vm *ASTInterpreter) evalFuncToEntries(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	_, obj, ok, err := vm.implicitArgOrEvalExpr(build, "function to_entries", m, 0, args, msg.TypeObject)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	out, err := buildEntries(build, obj)
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == from_entries(array) -> object ==
+// Emits an object with a member for each of the objects in the array,
+// as made by to_entries. The key can also be named `k` or `name`, and
+// the value `v`. Keys that are ints or booleans are turned into strings,
+// and a missing value is null. When a key is there twice, the last
+// value is kept.
+func (vm *ASTInterpreter) evalFuncFromEntries(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	_, arr, ok, err := vm.implicitArgOrEvalExpr(build, "function from_entries", m, 0, args, msg.TypeArray)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	out, err := vm.buildFromEntries(build, "function from_entries", arr)
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == with_entries(object, msg.Msg) -> object ==
+// Emits the object with its members changed by the expression, which
+// runs on each of them as made by to_entries. What the expression emits
+// is put back together as with from_entries.
+func (vm *ASTInterpreter) evalFuncWithEntries(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	args, obj, ok, err := vm.implicitArgOrEvalExpr(build, "function with_entries", m, 1, args, msg.TypeObject)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	entries, err := buildEntries(build, obj)
+	if err != nil {
+		return err
+	}
+	var changed []msg.Msg
+	for i := int64(0); i < entries.Len(); i++ {
+		err := vm.evalExpr(build, entries.Index(i), args[0], func(entry msg.Msg) error {
+			changed = append(changed, entry)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	arr, err := buildArray(build, changed)
+	if err != nil {
+		return err
+	}
+	out, err := vm.buildFromEntries(build, "function with_entries", arr)
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == pick(path, ...) -> object ==
+// Emits an object with only the members at the paths, like `.a` or
+// `.b.c`, where they are in the current message. Members that aren't
+// there are null.
+func (vm *ASTInterpreter) evalFuncPick(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	if m.Type() != msg.TypeObject {
+		return vm.skipEvalWrongType("function pick", m.Type(), msg.TypeObject)
+	}
+	picked := new(pickTree)
+	for _, arg := range args {
+		if arg.Next != nil || arg.Selector == nil || arg.Selector.Member == nil {
+			return at(arg.Span, vm.skipEvalWrongArgValue("function pick", m.Type(), "argument isn't a path to a member"))
+		}
+		if err := vm.pickPath(build, m, arg.Selector, picked); err != nil {
+			return at(arg.Span, err)
+		}
+	}
+	out, err := picked.build(build)
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// pickTree holds the members picked from an object. If all of a member
+// was picked, it's the value, otherwise it's what was picked from it.
+type pickTree struct {
+	value   msg.Msg
+	keys    []string
+	members map[string]*pickTree
+}
+
+func (vm *ASTInterpreter) pickPath(build msg.Builder, m msg.Msg, sel *ast.Selector, picked *pickTree) error {
+	defer trace()()
+
+	for ; sel != nil; sel = sel.Member.Child {
+		if sel.Member == nil {
+			return vm.skipEvalWrongArgValue("function pick", m.Type(), "argument isn't a path to a member")
+		}
+		if picked.value != nil {
+			// all of it was picked already
+			return nil
+		}
+		switch m.Type() {
+		case msg.TypeObject, msg.TypeNull:
+		default:
+			return vm.skipEvalWrongType("function pick", m.Type(), msg.TypeObject)
+		}
+		key, ok, err := vm.evalExprToMsgType(build, m, sel.Member.Index, "function pick", msg.TypeString)
+		if err != nil || !ok {
+			return err
+		}
+		name := key.StringVal()
+		if m.Type() == msg.TypeObject {
+			if m, ok = m.Member(name); !ok {
+				m = nil
+			}
+		}
+		if m == nil {
+			if m, err = build.Null(); err != nil {
+				return err
+			}
+		}
+		if picked.members == nil {
+			picked.members = make(map[string]*pickTree)
+		}
+		next, ok := picked.members[name]
+		if !ok {
+			next = new(pickTree)
+			picked.keys = append(picked.keys, name)
+			picked.members[name] = next
+		}
+		picked = next
+	}
+	picked.value, picked.keys, picked.members = m, nil, nil
+	return nil
+}
+
+func (p *pickTree) build(build msg.Builder) (msg.Msg, error) {
+	if p.value != nil {
+		return p.value, nil
+	}
+	return build.Object(func(ob msg.ObjectBuilder) error {
+		for _, k := range p.keys {
+			member := p.members[k]
+			if err := ob.AddMember(k, member.build); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// helper
+
+// evalKeys evaluates the keys of an object, sorted or in the order they
+// are in, or the indices of an array.
+func (vm *ASTInterpreter) evalKeys(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink, action string, sorted bool) error {
+	defer trace()()
+
+	_, arg, ok, err := vm.implicitArgOrEvalExpr(build, action, m, 0, args, msg.TypeObject, msg.TypeArray)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+
+	var keys msg.Msg
+	switch arg.Type() {
+	case msg.TypeObject:
+		names := arg.Keys()
+		if sorted {
+			names = append([]string(nil), names...)
+			sort.Strings(names)
+		}
+		keys, err = buildStrings(build, names)
+	case msg.TypeArray:
+		keys, err = build.Array(func(ab msg.ArrayBuilder) error {
+			for i := int64(0); i < arg.Len(); i++ {
+				err := ab.AddElem(func(b msg.Builder) (msg.Msg, error) {
+					return b.Int(i)
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return sink(keys)
+}
+
+// buildEntries builds an array of the members of an object, each as an
+// object with a key and a value.
+func buildEntries(build msg.Builder, obj msg.Msg) (msg.Msg, error) {
+	return build.Array(func(ab msg.ArrayBuilder) error {
+		for _, k := range obj.Keys() {
+			v, _ := obj.Member(k)
+			err := ab.AddElem(func(b msg.Builder) (msg.Msg, error) {
+				return b.Object(func(ob msg.ObjectBuilder) error {
+					if err := ob.AddMember("key", func(b msg.Builder) (msg.Msg, error) { return b.String(k) }); err != nil {
+						return err
+					}
+					return ob.AddMember("value", func(msg.Builder) (msg.Msg, error) { return v, nil })
+				})
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// buildFromEntries builds an object from an array of entries, as made
+// by buildEntries.
+func (vm *ASTInterpreter) buildFromEntries(build msg.Builder, action string, arr msg.Msg) (msg.Msg, error) {
+	var (
+		keys    []string
+		members = make(map[string]msg.Msg)
+	)
+	for i := int64(0); i < arr.Len(); i++ {
+		entry := arr.Index(i)
+		if entry.Type() != msg.TypeObject {
+			return nil, vm.skipEvalWrongArgValue(action, arr.Type(), fmt.Sprintf("element %d is a %v", i, entry.Type()))
+		}
+		key, ok := entryMember(entry, "key", "k", "name")
+		if !ok {
+			return nil, vm.skipEvalWrongArgValue(action, arr.Type(), fmt.Sprintf("element %d has no key", i))
+		}
+		var name string
+		switch key.Type() {
+		case msg.TypeString:
+			name = key.StringVal()
+		case msg.TypeInt:
+			name = strconv.FormatInt(key.IntVal(), 10)
+		case msg.TypeBool:
+			name = strconv.FormatBool(key.BoolVal())
+		default:
+			return nil, vm.skipEvalWrongArgValue(action, arr.Type(), fmt.Sprintf("key of element %d is a %v", i, key.Type()))
+		}
+		value, ok := entryMember(entry, "value", "v")
+		if !ok {
+			var err error
+			if value, err = build.Null(); err != nil {
+				return nil, err
+			}
+		}
+		if _, ok := members[name]; !ok {
+			keys = append(keys, name)
+		}
+		members[name] = value
+	}
+	return buildObject(build, keys, members)
+}
+
+// entryMember returns the first of the members of an entry that it has.
+func entryMember(entry msg.Msg, names ...string) (msg.Msg, bool) {
+	for _, name := range names {
+		if v, ok := entry.Member(name); ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// mergeObjects builds an object with the members of both objects, those
+// of rhs replacing those of lhs. If deep is set, members that are
+// objects on both sides are merged the same way instead.
+func mergeObjects(build msg.Builder, lhs, rhs msg.Msg, deep bool) (msg.Msg, error) {
+	keys := append([]string(nil), lhs.Keys()...)
+	members := make(map[string]msg.Msg, len(keys))
+	for _, k := range keys {
+		members[k], _ = lhs.Member(k)
+	}
+	for _, k := range rhs.Keys() {
+		v, _ := rhs.Member(k)
+		prev, ok := members[k]
+		if !ok {
+			keys = append(keys, k)
+		} else if deep && prev.Type() == msg.TypeObject && v.Type() == msg.TypeObject {
+			merged, err := mergeObjects(build, prev, v, deep)
+			if err != nil {
+				return nil, err
+			}
+			v = merged
+		}
+		members[k] = v
+	}
+	return buildObject(build, keys, members)
+}
+
+// buildObject builds an object with the members, in the order of keys.
+func buildObject(build msg.Builder, keys []string, members map[string]msg.Msg) (msg.Msg, error) {
+	return build.Object(func(ob msg.ObjectBuilder) error {
+		for _, k := range keys {
+			v := members[k]
+			err := ob.AddMember(k, func(msg.Builder) (msg.Msg, error) {
+				return v, nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
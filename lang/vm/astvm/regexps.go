@@ -0,0 +1,564 @@
+package astvm
+
+import (
+	"container/list"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/aybabtme/streamql/lang/ast"
+	"github.com/aybabtme/streamql/lang/check"
+	"github.com/aybabtme/streamql/lang/msg"
+)
+
+// pattern is a regexp compiled along with its flags. The flags that
+// don't change how the regexp is compiled change how it's used:
+//
+//	g: use all the matches, not only the first one
+//	i: ignore case
+//	x: extended, ignore whitespace and `#` comments in the pattern
+//	n: ignore empty matches
+//	s: single line, `.` matches `\n`
+//	l: use the longest matches, instead of the leftmost ones
+type pattern struct {
+	*regexp.Regexp
+	global      bool
+	ignoreEmpty bool
+}
+
+func compilePattern(expr, flags string) (*pattern, error) {
+	p := new(pattern)
+	var prefix string
+	extended, longest := false, false
+	for _, flag := range flags {
+		switch flag {
+		case 'g':
+			p.global = true
+		case 'n':
+			p.ignoreEmpty = true
+		case 'i', 's':
+			prefix += string(flag)
+		case 'x':
+			extended = true
+		case 'l':
+			longest = true
+		default:
+			return nil, fmt.Errorf("unknown flag %q, want one of g, i, x, n, s or l", flag)
+		}
+	}
+	if extended {
+		expr = stripExtended(expr)
+	}
+	if prefix != "" {
+		expr = "(?" + prefix + ")" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	if longest {
+		re.Longest()
+	}
+	p.Regexp = re
+	return p, nil
+}
+
+// stripExtended removes the whitespace and comments from a pattern in
+// extended mode, except in character classes or when escaped.
+func stripExtended(expr string) string {
+	var out strings.Builder
+	inClass, inComment, escaped := false, false, false
+	for _, r := range expr {
+		switch {
+		case inComment:
+			inComment = r != '\n'
+		case escaped:
+			escaped = false
+			if !unicode.IsSpace(r) {
+				out.WriteRune('\\')
+			}
+			out.WriteRune(r)
+		case r == '\\':
+			escaped = true
+		case inClass:
+			inClass = r != ']'
+			out.WriteRune(r)
+		case r == '[':
+			inClass = true
+			out.WriteRune(r)
+		case r == '#':
+			inComment = true
+		case !unicode.IsSpace(r):
+			out.WriteRune(r)
+		}
+	}
+	if escaped {
+		out.WriteRune('\\')
+	}
+	return out.String()
+}
+
+// matches finds where the pattern matches s, with the indexes of its
+// submatches as in regexp.FindAllStringSubmatchIndex. Only the first
+// match is returned, unless all is set or the pattern is global.
+func (p *pattern) matches(s string, all bool) [][]int {
+	if !all && !p.global {
+		// don't look for the other matches, unless the first one is
+		// empty and must be ignored
+		match := p.FindStringSubmatchIndex(s)
+		if match == nil {
+			return nil
+		}
+		if !p.ignoreEmpty || match[0] != match[1] {
+			return [][]int{match}
+		}
+	}
+	var out [][]int
+	for _, match := range p.FindAllStringSubmatchIndex(s, -1) {
+		if p.ignoreEmpty && match[0] == match[1] {
+			continue
+		}
+		out = append(out, match)
+		if !all && !p.global {
+			break
+		}
+	}
+	return out
+}
+
+// replace replaces the matches of the pattern in s with repl, in which
+// `$1` or `${name}` are expanded to the submatches of each match.
+func (p *pattern) replace(s, repl string, all bool) string {
+	var out []byte
+	last := 0
+	for _, match := range p.matches(s, all) {
+		out = append(out, s[last:match[0]]...)
+		out = p.ExpandString(out, repl, s, match)
+		last = match[1]
+	}
+	return string(append(out, s[last:]...))
+}
+
+// patternArgs finds the pattern of a call to a function, and its flags
+// if they're given. The flags are the last parameter of the functions
+// that take them, and can be left out.
+func patternArgs(sig *check.Func, args []*ast.Expr) (expr, flags *ast.Expr) {
+	if len(sig.Patterns) == 0 {
+		return nil, nil
+	}
+	implicit := sig.Implicit(len(args))
+	i := sig.Patterns[0] - implicit
+	if i < 0 || i >= len(args) {
+		return nil, nil
+	}
+	expr = args[i]
+	if sig.Optional > 0 && implicit+len(args) == len(sig.Params) {
+		flags = args[len(args)-1]
+	}
+	return expr, flags
+}
+
+// compilePatterns compiles the patterns given as literals, with their
+// flags if those are literals too. Those that don't compile are left
+// for the call to report.
+func compilePatterns(tree *ast.AST) map[*ast.Expr]*pattern {
+	patterns := make(map[*ast.Expr]*pattern)
+	ast.Inspect(tree, func(node ast.Node) bool {
+		f, ok := node.(*ast.FuncCall)
+		if !ok {
+			return true
+		}
+		sig, ok := Builtin(f.Name)
+		if !ok || !hasArity(sig, len(f.Args)) {
+			return true
+		}
+		expr, flags := patternArgs(sig, f.Args)
+		exprStr, ok := literalString(expr)
+		if !ok {
+			return true
+		}
+		var flagsStr string
+		if flags != nil {
+			if flagsStr, ok = literalString(flags); !ok {
+				return true
+			}
+		}
+		if p, err := compilePattern(exprStr, flagsStr); err == nil {
+			patterns[expr] = p
+		}
+		return true
+	})
+	return patterns
+}
+
+func literalString(e *ast.Expr) (string, bool) {
+	if e == nil || e.Next != nil || e.Literal == nil || e.Literal.String == nil {
+		return "", false
+	}
+	return *e.Literal.String, true
+}
+
+func hasArity(sig *check.Func, n int) bool {
+	for _, arity := range sig.Arities {
+		if arity == n {
+			return true
+		}
+	}
+	return false
+}
+
+// maxCachedPatterns is how many patterns that aren't literals a query
+// keeps compiled.
+const maxCachedPatterns = 256
+
+// patternCache keeps the patterns that aren't literals compiled, since
+// the same few often come back from one message to the next. When it's
+// full, the pattern used least recently is dropped.
+type patternCache struct {
+	mu sync.Mutex
+	// recent lists the entries from the most recently used to the
+	// least recently used.
+	recent  *list.List
+	entries map[patternKey]*list.Element
+}
+
+type patternKey struct{ expr, flags string }
+
+type patternEntry struct {
+	key patternKey
+	p   *pattern
+	err error
+}
+
+func (c *patternCache) compile(expr, flags string) (*pattern, error) {
+	key := patternKey{expr: expr, flags: flags}
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.recent.MoveToFront(elem)
+		entry := elem.Value.(*patternEntry)
+		c.mu.Unlock()
+		return entry.p, entry.err
+	}
+	c.mu.Unlock()
+	entry := &patternEntry{key: key}
+	entry.p, entry.err = compilePattern(expr, flags)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.recent = list.New()
+		c.entries = make(map[patternKey]*list.Element)
+	}
+	if elem, ok := c.entries[key]; ok {
+		// compiled by another message in the meantime
+		c.recent.MoveToFront(elem)
+		return entry.p, entry.err
+	}
+	if len(c.entries) >= maxCachedPatterns {
+		oldest := c.recent.Back()
+		c.recent.Remove(oldest)
+		delete(c.entries, oldest.Value.(*patternEntry).key)
+	}
+	c.entries[key] = c.recent.PushFront(entry)
+	return entry.p, entry.err
+}
+
+// evalPattern evaluates the pattern of a call, and its flags if they're
+// given, unless they were compiled ahead of time.
+func (vm *ASTInterpreter) evalPattern(build msg.Builder, m msg.Msg, action string, expr, flags *ast.Expr) (*pattern, bool, error) {
+	defer trace()()
+	if p, ok := vm.patterns[expr]; ok {
+		return p, true, nil
+	}
+	exprMsg, ok, err := vm.evalExprToMsgType(build, m, expr, action, msg.TypeString)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	var flagsStr string
+	if flags != nil {
+		flagsMsg, ok, err := vm.evalExprToMsgType(build, m, flags, action, msg.TypeString)
+		if err != nil || !ok {
+			return nil, ok, err
+		}
+		flagsStr = flagsMsg.StringVal()
+	}
+	p, err := vm.cache.compile(exprMsg.StringVal(), flagsStr)
+	if err != nil {
+		return nil, false, vm.skipEvalWrongArgValue(action, exprMsg.Type(), "invalid regexp: "+err.Error())
+	}
+	return p, true, nil
+}
+
+var sigMatchBool = &check.Func{
+	Arities:  []int{1, 2, 3},
+	Params:   []check.Types{stringType, stringType, stringType},
+	Optional: 1,
+	Result:   check.TypesOf(msg.TypeBool),
+	Patterns: []int{1},
+}
+
+var sigMatchObject = &check.Func{
+	Arities:  []int{1, 2, 3},
+	Params:   []check.Types{stringType, stringType, stringType},
+	Optional: 1,
+	Result:   check.TypesOf(msg.TypeObject),
+	Patterns: []int{1},
+	Many:     true,
+}
+
+var sigScan = &check.Func{
+	Arities:  []int{1, 2, 3},
+	Params:   []check.Types{stringType, stringType, stringType},
+	Optional: 1,
+	Result:   check.TypesOf(msg.TypeString, msg.TypeArray),
+	Patterns: []int{1},
+	Many:     true,
+}
+
+var sigSplits = &check.Func{
+	Arities:  []int{1, 2, 3},
+	Params:   []check.Types{stringType, stringType, stringType},
+	Optional: 1,
+	Result:   stringType,
+	Patterns: []int{1},
+	Many:     true,
+}
+
+// == test(s, pattern, flags string) -> bool ==
+// Emits a boolean: if the regexp matches the string. The flags can be
+// left out.
+func (vm *ASTInterpreter) evalFuncTest(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	_, _, matches, ok, err := vm.evalMatches(build, m, args, "function test", sigMatchBool, false)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	match, err := build.Bool(len(matches) > 0)
+	if err != nil {
+		return err
+	}
+	return sink(match)
+}
+
+// == match(s, pattern, flags string) -> object ==
+// Emits an object for the first match of the regexp in the string, or
+// for each of them with the `g` flag. It has the `offset` and `length`
+// of the match, the `string` that matched, and the `captures` of the
+// submatches: an array of objects with the same members, and their
+// `name`. A submatch that matched nothing has an offset of -1 and a
+// null string. Offsets and lengths are in bytes, as with length and
+// index, not in codepoints as in jq: they differ from jq's in strings
+// that aren't ASCII, like "é" which is 2 bytes long.
+func (vm *ASTInterpreter) evalFuncMatch(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	s, re, matches, ok, err := vm.evalMatches(build, m, args, "function match", sigMatchObject, false)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	names := re.SubexpNames()
+	for _, match := range matches {
+		obj, err := build.Object(func(ob msg.ObjectBuilder) error {
+			if err := addMatch(ob, s, match[0], match[1]); err != nil {
+				return err
+			}
+			return ob.AddMember("captures", func(b msg.Builder) (msg.Msg, error) {
+				return b.Array(func(ab msg.ArrayBuilder) error {
+					for i := 1; i < len(names); i++ {
+						err := ab.AddElem(func(b msg.Builder) (msg.Msg, error) {
+							return b.Object(func(ob msg.ObjectBuilder) error {
+								if err := addMatch(ob, s, match[2*i], match[2*i+1]); err != nil {
+									return err
+								}
+								return ob.AddMember("name", func(b msg.Builder) (msg.Msg, error) {
+									if names[i] == "" {
+										return b.Null()
+									}
+									return b.String(names[i])
+								})
+							})
+						})
+						if err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+			})
+		})
+		if err != nil {
+			return err
+		}
+		if err := sink(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// == capture(s, pattern, flags string) -> object ==
+// Emits an object of what the named submatches of the regexp matched
+// in the string, for the first match or each of them with the `g` flag.
+// A submatch that matched nothing is null.
+func (vm *ASTInterpreter) evalFuncCapture(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	s, re, matches, ok, err := vm.evalMatches(build, m, args, "function capture", sigMatchObject, false)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	names := re.SubexpNames()
+	for _, match := range matches {
+		obj, err := build.Object(func(ob msg.ObjectBuilder) error {
+			for i, name := range names {
+				if name == "" {
+					continue
+				}
+				err := ob.AddMember(name, func(b msg.Builder) (msg.Msg, error) {
+					return buildSubmatch(b, s, match[2*i], match[2*i+1])
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := sink(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// == scan(s, pattern, flags string) -> string|array ==
+// Emits each match of the regexp in the string: what it matched, or an
+// array of what its submatches matched if the regexp has any.
+func (vm *ASTInterpreter) evalFuncScan(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	s, re, matches, ok, err := vm.evalMatches(build, m, args, "function scan", sigScan, true)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	for _, match := range matches {
+		var (
+			out msg.Msg
+			err error
+		)
+		if re.NumSubexp() == 0 {
+			out, err = build.String(s[match[0]:match[1]])
+		} else {
+			out, err = build.Array(func(ab msg.ArrayBuilder) error {
+				for i := 1; i <= re.NumSubexp(); i++ {
+					err := ab.AddElem(func(b msg.Builder) (msg.Msg, error) {
+						return buildSubmatch(b, s, match[2*i], match[2*i+1])
+					})
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}
+		if err != nil {
+			return err
+		}
+		if err := sink(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// == splits(s, pattern, flags string) -> string ==
+// Emits each part of the string between the matches of the regexp.
+func (vm *ASTInterpreter) evalFuncSplits(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	s, _, matches, ok, err := vm.evalMatches(build, m, args, "function splits", sigSplits, true)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	last := 0
+	for _, match := range matches {
+		part, err := build.String(s[last:match[0]])
+		if err != nil {
+			return err
+		}
+		if err := sink(part); err != nil {
+			return err
+		}
+		last = match[1]
+	}
+	part, err := build.String(s[last:])
+	if err != nil {
+		return err
+	}
+	return sink(part)
+}
+
+// evalMatches evaluates the string and the pattern given to a function
+// of the regexp family, and finds where the pattern matches the string.
+func (vm *ASTInterpreter) evalMatches(build msg.Builder, m msg.Msg, args []*ast.Expr, action string, sig *check.Func, all bool) (string, *pattern, [][]int, bool, error) {
+	defer trace()()
+
+	expr, flags := patternArgs(sig, args)
+	_, s, ok, err := vm.implicitArgOrEvalExpr(build, action, m, 1, args, msg.TypeString)
+	if err != nil || !ok {
+		return "", nil, nil, ok, err
+	}
+	re, ok, err := vm.evalPattern(build, m, action, expr, flags)
+	if err != nil || !ok {
+		return "", nil, nil, ok, err
+	}
+	return s.StringVal(), re, re.matches(s.StringVal(), all), true, nil
+}
+
+// addMatch adds the members that describe a match, or a submatch, from
+// start to end in s.
+func addMatch(ob msg.ObjectBuilder, s string, start, end int) error {
+	offset, length := int64(start), int64(end-start)
+	if start < 0 {
+		offset, length = -1, 0
+	}
+	err := ob.AddMember("offset", func(b msg.Builder) (msg.Msg, error) { return b.Int(offset) })
+	if err != nil {
+		return err
+	}
+	err = ob.AddMember("length", func(b msg.Builder) (msg.Msg, error) { return b.Int(length) })
+	if err != nil {
+		return err
+	}
+	return ob.AddMember("string", func(b msg.Builder) (msg.Msg, error) {
+		return buildSubmatch(b, s, start, end)
+	})
+}
+
+// buildSubmatch builds what a submatch matched in s, or null if it
+// matched nothing.
+func buildSubmatch(build msg.Builder, s string, start, end int) (msg.Msg, error) {
+	if start < 0 {
+		return build.Null()
+	}
+	return build.String(s[start:end])
+}
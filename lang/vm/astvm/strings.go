@@ -0,0 +1,358 @@
+package astvm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/aybabtme/streamql/lang/ast"
+	"github.com/aybabtme/streamql/lang/check"
+	"github.com/aybabtme/streamql/lang/msg"
+)
+
+var (
+	stringType = check.TypesOf(msg.TypeString)
+
+	sigStringToString = &check.Func{
+		Arities: []int{0, 1},
+		Params:  []check.Types{stringType},
+		Result:  stringType,
+	}
+	sigStringsToString = &check.Func{
+		Arities: []int{1, 2},
+		Params:  []check.Types{stringType, stringType},
+		Result:  stringType,
+	}
+	sigStringsToBool = &check.Func{
+		Arities: []int{1, 2},
+		Params:  []check.Types{stringType, stringType},
+		Result:  check.TypesOf(msg.TypeBool),
+	}
+	sigSplit = &check.Func{
+		Arities: []int{1, 2},
+		Params:  []check.Types{stringType, stringType},
+		Result:  check.TypesOf(msg.TypeArray),
+	}
+	sigJoin = &check.Func{
+		Arities: []int{1, 2},
+		Params:  []check.Types{check.TypesOf(msg.TypeArray), stringType},
+		Result:  stringType,
+	}
+	sigReplace = &check.Func{
+		Arities: []int{2, 3},
+		Params:  []check.Types{stringType, stringType, stringType},
+		Result:  stringType,
+	}
+	sigSub = &check.Func{
+		Arities:  []int{2, 3, 4},
+		Params:   []check.Types{stringType, stringType, stringType, stringType},
+		Optional: 1,
+		Result:   stringType,
+		Patterns: []int{1},
+	}
+)
+
+// == upper(string) -> string ==
+// Emits the string with all its letters in upper case.
+func (vm *ASTInterpreter) evalFuncUpper(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalStringToString(build, m, args, sink, "function upper", strings.ToUpper)
+}
+
+// == lower(string) -> string ==
+// Emits the string with all its letters in lower case.
+func (vm *ASTInterpreter) evalFuncLower(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalStringToString(build, m, args, sink, "function lower", strings.ToLower)
+}
+
+// == trim(string) -> string ==
+// Emits the string without the whitespace it starts and ends with.
+func (vm *ASTInterpreter) evalFuncTrim(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalStringToString(build, m, args, sink, "function trim", strings.TrimSpace)
+}
+
+// == ltrim(string) -> string ==
+// Emits the string without the whitespace it starts with.
+func (vm *ASTInterpreter) evalFuncLtrim(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalStringToString(build, m, args, sink, "function ltrim", func(s string) string {
+		return strings.TrimLeftFunc(s, unicode.IsSpace)
+	})
+}
+
+// == rtrim(string) -> string ==
+// Emits the string without the whitespace it ends with.
+func (vm *ASTInterpreter) evalFuncRtrim(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalStringToString(build, m, args, sink, "function rtrim", func(s string) string {
+		return strings.TrimRightFunc(s, unicode.IsSpace)
+	})
+}
+
+// == ltrimstr(s, prefix string) -> string ==
+// Emits the string without the prefix, if it starts with it.
+func (vm *ASTInterpreter) evalFuncLtrimstr(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	strs, ok, err := vm.evalStrings(build, m, args, "function ltrimstr", 1)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	out, err := build.String(strings.TrimPrefix(strs[0], strs[1]))
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == rtrimstr(s, suffix string) -> string ==
+// Emits the string without the suffix, if it ends with it.
+func (vm *ASTInterpreter) evalFuncRtrimstr(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	strs, ok, err := vm.evalStrings(build, m, args, "function rtrimstr", 1)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	out, err := build.String(strings.TrimSuffix(strs[0], strs[1]))
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == startswith(s, prefix string) -> bool ==
+// Emits a boolean: if the string starts with the prefix.
+func (vm *ASTInterpreter) evalFuncStartswith(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	strs, ok, err := vm.evalStrings(build, m, args, "function startswith", 1)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	out, err := build.Bool(strings.HasPrefix(strs[0], strs[1]))
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == endswith(s, suffix string) -> bool ==
+// Emits a boolean: if the string ends with the suffix.
+func (vm *ASTInterpreter) evalFuncEndswith(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	strs, ok, err := vm.evalStrings(build, m, args, "function endswith", 1)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	out, err := build.Bool(strings.HasSuffix(strs[0], strs[1]))
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == split(s, separator string) -> array ==
+// Emits an array of the parts of the string between each separator. An
+// empty separator splits the string after each letter. The empty string
+// has no parts, as in jq.
+func (vm *ASTInterpreter) evalFuncSplit(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	strs, ok, err := vm.evalStrings(build, m, args, "function split", 1)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	var parts []string
+	if strs[0] != "" {
+		parts = strings.Split(strs[0], strs[1])
+	}
+	out, err := buildStrings(build, parts)
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == join(array, separator string) -> string ==
+// Emits the elements of the array one after the other, with the
+// separator between them. Numbers and booleans are written as in the
+// query, and null as nothing.
+func (vm *ASTInterpreter) evalFuncJoin(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	args, arr, ok, err := vm.implicitArgOrEvalExpr(build, "function join", m, 1, args, msg.TypeArray)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	sep, ok, err := vm.evalExprToMsgType(build, m, args[0], "function join", msg.TypeString)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+
+	parts := make([]string, 0, arr.Len())
+	for i := int64(0); i < arr.Len(); i++ {
+		elem := arr.Index(i)
+		switch elem.Type() {
+		case msg.TypeString:
+			parts = append(parts, elem.StringVal())
+		case msg.TypeInt:
+			parts = append(parts, strconv.FormatInt(elem.IntVal(), 10))
+		case msg.TypeFloat:
+			parts = append(parts, strconv.FormatFloat(elem.FloatVal(), 'g', -1, 64))
+		case msg.TypeBool:
+			parts = append(parts, strconv.FormatBool(elem.BoolVal()))
+		case msg.TypeNull:
+			parts = append(parts, "")
+		default:
+			return vm.skipEvalWrongArgValue("function join", arr.Type(), fmt.Sprintf("element %d is a %v", i, elem.Type()))
+		}
+	}
+	out, err := build.String(strings.Join(parts, sep.StringVal()))
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == replace(s, old, new string) -> string ==
+// Emits the string with each occurrence of old replaced by new.
+func (vm *ASTInterpreter) evalFuncReplace(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	strs, ok, err := vm.evalStrings(build, m, args, "function replace", 2)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	out, err := build.String(strings.ReplaceAll(strs[0], strs[1], strs[2]))
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == sub(s, pattern, replacement, flags string) -> string ==
+// Emits the string with the first match of the regexp replaced, or all
+// of them with the `g` flag. In the replacement, `$1` or `${name}` stand
+// for what the submatches matched. The flags can be left out.
+func (vm *ASTInterpreter) evalFuncSub(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalSubstitute(build, m, args, sink, "function sub", false)
+}
+
+// == gsub(s, pattern, replacement, flags string) -> string ==
+// Emits the string with all the matches of the regexp replaced, like
+// sub with the `g` flag.
+func (vm *ASTInterpreter) evalFuncGsub(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalSubstitute(build, m, args, sink, "function gsub", true)
+}
+
+func (vm *ASTInterpreter) evalSubstitute(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink, action string, all bool) error {
+	defer trace()()
+
+	pattern, flags := patternArgs(sigSub, args)
+	args, s, ok, err := vm.implicitArgOrEvalExpr(build, action, m, 2, args, msg.TypeString)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	re, ok, err := vm.evalPattern(build, m, action, pattern, flags)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	repl, ok, err := vm.evalExprToMsgType(build, m, args[1], action, msg.TypeString)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	out, err := build.String(re.replace(s.StringVal(), repl.StringVal(), all))
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// helper
+
+// evalStringToString evaluates a function that maps a string to
+// another, the current message if the string isn't given.
+func (vm *ASTInterpreter) evalStringToString(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink, action string, fn func(string) string) error {
+	defer trace()()
+	strs, ok, err := vm.evalStrings(build, m, args, action, 0)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	out, err := build.String(fn(strs[0]))
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// evalStrings evaluates the arguments of a function that takes only
+// strings. The first one is the current message if only implIfLen
+// arguments are given.
+func (vm *ASTInterpreter) evalStrings(build msg.Builder, m msg.Msg, args []*ast.Expr, action string, implIfLen int) ([]string, bool, error) {
+	defer trace()()
+	args, s, ok, err := vm.implicitArgOrEvalExpr(build, action, m, implIfLen, args, msg.TypeString)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	strs := append(make([]string, 0, len(args)+1), s.StringVal())
+	for _, arg := range args {
+		s, ok, err := vm.evalExprToMsgType(build, m, arg, action, msg.TypeString)
+		if err != nil || !ok {
+			return nil, ok, err
+		}
+		strs = append(strs, s.StringVal())
+	}
+	return strs, true, nil
+}
+
+// buildStrings builds an array of strings.
+func buildStrings(build msg.Builder, strs []string) (msg.Msg, error) {
+	return build.Array(func(ab msg.ArrayBuilder) error {
+		for _, s := range strs {
+			err := ab.AddElem(func(b msg.Builder) (msg.Msg, error) {
+				return b.String(s)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
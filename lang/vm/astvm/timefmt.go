@@ -0,0 +1,319 @@
+package astvm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The formats of strftime and strptime are those of C: `%` followed by
+// a letter stands for a part of the time, and everything else is
+// written or read as it is.
+
+// formatTime writes t in the format.
+func formatTime(t time.Time, format string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			sb.WriteByte(format[i])
+			continue
+		}
+		if i++; i == len(format) {
+			return "", fmt.Errorf("format ends with a lone %%")
+		}
+		switch c := format[i]; c {
+		case 'Y':
+			sb.WriteString(strconv.Itoa(t.Year()))
+		case 'y':
+			fmt.Fprintf(&sb, "%02d", t.Year()%100)
+		case 'm':
+			fmt.Fprintf(&sb, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&sb, "%02d", t.Day())
+		case 'e':
+			fmt.Fprintf(&sb, "%2d", t.Day())
+		case 'j':
+			fmt.Fprintf(&sb, "%03d", t.YearDay())
+		case 'H':
+			fmt.Fprintf(&sb, "%02d", t.Hour())
+		case 'I':
+			fmt.Fprintf(&sb, "%02d", (t.Hour()+11)%12+1)
+		case 'M':
+			fmt.Fprintf(&sb, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&sb, "%02d", t.Second())
+		case 'p':
+			sb.WriteString(t.Format("PM"))
+		case 'b', 'h':
+			sb.WriteString(t.Format("Jan"))
+		case 'B':
+			sb.WriteString(t.Format("January"))
+		case 'a':
+			sb.WriteString(t.Format("Mon"))
+		case 'A':
+			sb.WriteString(t.Format("Monday"))
+		case 'u':
+			sb.WriteString(strconv.Itoa((int(t.Weekday())+6)%7 + 1))
+		case 'w':
+			sb.WriteString(strconv.Itoa(int(t.Weekday())))
+		case 'Z':
+			sb.WriteString(t.Format("MST"))
+		case 'z':
+			sb.WriteString(t.Format("-0700"))
+		case 's':
+			sb.WriteString(strconv.FormatInt(t.Unix(), 10))
+		case 'T', 'D', 'F', 'R', 'r':
+			s, err := formatTime(t, shorthands[c])
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(s)
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		case '%':
+			sb.WriteByte('%')
+		default:
+			return "", fmt.Errorf("unknown directive %%%c", c)
+		}
+	}
+	return sb.String(), nil
+}
+
+// shorthands are the directives that stand for others.
+var shorthands = map[byte]string{
+	'T': "%H:%M:%S",
+	'D': "%m/%d/%y",
+	'F': "%Y-%m-%d",
+	'R': "%H:%M",
+	'r': "%I:%M:%S %p",
+}
+
+// parsedTime holds the parts of a time read by parseTime.
+type parsedTime struct {
+	year, month, day    int
+	hour, min, sec      int
+	yday                int
+	pm, hour12, hasDate bool
+	offset              int
+	epoch               *int64
+}
+
+// parseTime reads s in the format. The parts of the time that aren't in
+// the format are those of 1970-01-01T00:00:00Z.
+func parseTime(s, format string) (time.Time, error) {
+	p := &parsedTime{year: 1970, month: 1, day: 1}
+	rest, err := p.parse(s, format)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if rest != "" {
+		return time.Time{}, fmt.Errorf("%q is left after the format", rest)
+	}
+	if p.epoch != nil {
+		return time.Unix(*p.epoch, 0).UTC(), nil
+	}
+	hour := p.hour
+	if p.hour12 {
+		if hour < 1 || hour > 12 {
+			return time.Time{}, fmt.Errorf("hour %d is out of range", hour)
+		}
+		hour %= 12
+		if p.pm {
+			hour += 12
+		}
+	}
+	switch {
+	case p.month < 1 || p.month > 12:
+		return time.Time{}, fmt.Errorf("month %d is out of range", p.month)
+	case p.day < 1 || p.day > 31:
+		return time.Time{}, fmt.Errorf("day %d is out of range", p.day)
+	case hour > 23:
+		return time.Time{}, fmt.Errorf("hour %d is out of range", hour)
+	case p.min > 59:
+		return time.Time{}, fmt.Errorf("minute %d is out of range", p.min)
+	case p.sec > 60:
+		return time.Time{}, fmt.Errorf("second %d is out of range", p.sec)
+	}
+	day := p.day
+	if p.yday > 0 && !p.hasDate {
+		p.month, day = 1, p.yday
+	}
+	t := time.Date(p.year, time.Month(p.month), day, hour, p.min, p.sec, 0, time.FixedZone("", p.offset))
+	return t.UTC(), nil
+}
+
+func (p *parsedTime) parse(s, format string) (string, error) {
+	var err error
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			switch {
+			case unicode.IsSpace(rune(c)):
+				s = strings.TrimLeftFunc(s, unicode.IsSpace)
+			case s == "" || s[0] != c:
+				return "", fmt.Errorf("%q doesn't match the format at %q", s, format[i:])
+			default:
+				s = s[1:]
+			}
+			continue
+		}
+		if i++; i == len(format) {
+			return "", fmt.Errorf("format ends with a lone %%")
+		}
+		switch c = format[i]; c {
+		case 'Y':
+			s, p.year, err = readInt(s, 4, true)
+			p.hasDate = true
+		case 'y':
+			var y int
+			if s, y, err = readInt(s, 2, false); y < 69 {
+				p.year = 2000 + y
+			} else {
+				p.year = 1900 + y
+			}
+			p.hasDate = true
+		case 'm':
+			s, p.month, err = readInt(s, 2, false)
+			p.hasDate = true
+		case 'd', 'e':
+			s, p.day, err = readInt(strings.TrimLeft(s, " "), 2, false)
+			p.hasDate = true
+		case 'j':
+			s, p.yday, err = readInt(s, 3, false)
+		case 'H':
+			s, p.hour, err = readInt(s, 2, false)
+		case 'I':
+			s, p.hour, err = readInt(s, 2, false)
+			p.hour12 = true
+		case 'M':
+			s, p.min, err = readInt(s, 2, false)
+		case 'S':
+			s, p.sec, err = readInt(s, 2, false)
+		case 'p':
+			var name int
+			s, name, err = readName(s, "AM", "PM")
+			p.pm = name == 1
+		case 'b', 'h', 'B':
+			var month int
+			if s, month, err = readMonth(s); err == nil {
+				p.month = month + 1
+				p.hasDate = true
+			}
+		case 'a', 'A':
+			s, _, err = readWeekday(s)
+		case 'Z':
+			s, _, err = readName(s, "UTC", "GMT", "Z")
+		case 'z':
+			s, p.offset, err = readOffset(s)
+		case 's':
+			var n int64
+			end := strings.IndexFunc(strings.TrimPrefix(s, "-"), func(r rune) bool { return r < '0' || r > '9' })
+			if end < 0 {
+				end = len(s)
+			} else if strings.HasPrefix(s, "-") {
+				end++
+			}
+			if n, err = strconv.ParseInt(s[:end], 10, 64); err == nil {
+				s, p.epoch = s[end:], &n
+			}
+		case 'T', 'D', 'F', 'R', 'r':
+			s, err = p.parse(s, shorthands[c])
+		case 'n', 't':
+			s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		case '%':
+			if !strings.HasPrefix(s, "%") {
+				return "", fmt.Errorf("%q doesn't match the format at %q", s, "%%")
+			}
+			s = s[1:]
+		default:
+			return "", fmt.Errorf("unknown directive %%%c", c)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return s, nil
+}
+
+// readInt reads an int of at most max digits, maybe with a sign.
+func readInt(s string, max int, signed bool) (string, int, error) {
+	start := 0
+	if signed && (strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+")) {
+		start = 1
+	}
+	end := start
+	for end < len(s) && end-start < max && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	if end == start {
+		return "", 0, fmt.Errorf("%q doesn't start with a number", s)
+	}
+	n, err := strconv.Atoi(s[:end])
+	return s[end:], n, err
+}
+
+// readName reads one of the names, in any case, and returns its index.
+func readName(s string, names ...string) (string, int, error) {
+	for i, name := range names {
+		if len(s) >= len(name) && strings.EqualFold(s[:len(name)], name) {
+			return s[len(name):], i, nil
+		}
+	}
+	return "", 0, fmt.Errorf("%q doesn't start with one of %s", s, strings.Join(names, ", "))
+}
+
+// readMonth reads the name of a month, in full or abbreviated, and
+// returns it from 0 for January.
+func readMonth(s string) (string, int, error) {
+	var names []string
+	for m := time.January; m <= time.December; m++ {
+		names = append(names, m.String())
+	}
+	for m := time.January; m <= time.December; m++ {
+		names = append(names, m.String()[:3])
+	}
+	s, i, err := readName(s, names...)
+	return s, i % 12, err
+}
+
+// readWeekday reads the name of a day, in full or abbreviated.
+func readWeekday(s string) (string, int, error) {
+	var names []string
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		names = append(names, d.String())
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		names = append(names, d.String()[:3])
+	}
+	s, i, err := readName(s, names...)
+	return s, i % 7, err
+}
+
+// readOffset reads an offset from UTC, like `Z`, `+0200` or `-07:00`,
+// and returns it in seconds.
+func readOffset(s string) (string, int, error) {
+	if strings.HasPrefix(s, "Z") {
+		return s[1:], 0, nil
+	}
+	if len(s) < 5 || (s[0] != '+' && s[0] != '-') {
+		return "", 0, fmt.Errorf("%q doesn't start with an offset", s)
+	}
+	sign := 1
+	if s[0] == '-' {
+		sign = -1
+	}
+	rest, hours, err := readInt(s[1:], 2, false)
+	if err != nil || len(s)-len(rest) != 3 {
+		return "", 0, fmt.Errorf("%q doesn't start with an offset", s)
+	}
+	rest = strings.TrimPrefix(rest, ":")
+	after, mins, err := readInt(rest, 2, false)
+	if err != nil || len(rest)-len(after) != 2 || mins > 59 {
+		return "", 0, fmt.Errorf("%q doesn't start with an offset", s)
+	}
+	return after, sign * (hours*3600 + mins*60), nil
+}
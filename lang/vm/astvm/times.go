@@ -0,0 +1,445 @@
+package astvm
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/aybabtme/streamql/lang/ast"
+	"github.com/aybabtme/streamql/lang/check"
+	"github.com/aybabtme/streamql/lang/msg"
+)
+
+// Times are numbers of seconds since 1970-01-01T00:00:00Z: an int, or a
+// float when they have a fraction of a second. So are durations, which
+// makes date arithmetic the usual arithmetic, as in:
+//
+//	select(fromdateiso8601(.ts) > now - duration("5m"))
+//
+// Times can also be broken down into an array of their parts, the same
+// way as in C and in jq:
+//
+//	[year, month (0-11), day (1-31), hours, minutes, seconds, weekday (0-6), day of the year (0-365)]
+//
+// All times are in UTC.
+
+var (
+	timeType = check.TypesOf(msg.TypeInt, msg.TypeFloat, msg.TypeArray)
+
+	sigNow = &check.Func{
+		Arities: []int{0},
+		Result:  check.TypesOf(msg.TypeFloat),
+	}
+	sigFromDate = &check.Func{
+		Arities: []int{0, 1},
+		Params:  []check.Types{stringType},
+		Result:  numberType,
+	}
+	sigToDate = &check.Func{
+		Arities: []int{0, 1},
+		Params:  []check.Types{timeType},
+		Result:  stringType,
+	}
+	sigGmtime = &check.Func{
+		Arities: []int{0, 1},
+		Params:  []check.Types{numberType},
+		Result:  arrayType,
+	}
+	sigMktime = &check.Func{
+		Arities: []int{0, 1},
+		Params:  []check.Types{check.TypesOf(msg.TypeArray)},
+		Result:  numberType,
+	}
+	sigStrptime = &check.Func{
+		Arities: []int{1, 2},
+		Params:  []check.Types{stringType, stringType},
+		Result:  arrayType,
+	}
+	sigStrftime = &check.Func{
+		Arities: []int{1, 2},
+		Params:  []check.Types{timeType, stringType},
+		Result:  stringType,
+	}
+	sigDuration = &check.Func{
+		Arities: []int{0, 1},
+		Params:  []check.Types{stringType},
+		Result:  numberType,
+	}
+	sigDateAdd = &check.Func{
+		Arities: []int{1, 2},
+		Params:  []check.Types{check.TypesOf(msg.TypeString, msg.TypeInt, msg.TypeFloat), check.TypesOf(msg.TypeString, msg.TypeInt, msg.TypeFloat)},
+		Result:  check.TypesOf(msg.TypeString, msg.TypeInt, msg.TypeFloat),
+	}
+)
+
+// == now() -> float ==
+// Emits the current time.
+func (vm *ASTInterpreter) evalFuncNow(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	t := vm.now()
+	out, err := build.Float(float64(t.UnixNano()) / float64(time.Second))
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == fromdateiso8601(string) -> int|float ==
+// Emits the time written in the string as in RFC 3339, like
+// "2015-03-05T23:51:47Z".
+func (vm *ASTInterpreter) evalFuncFromdateiso8601(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalFromDate(build, m, args, sink, "function fromdateiso8601")
+}
+
+// == fromdate(string) -> int|float ==
+// Same as fromdateiso8601.
+func (vm *ASTInterpreter) evalFuncFromdate(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalFromDate(build, m, args, sink, "function fromdate")
+}
+
+// == todate(int|float|array) -> string ==
+// Emits the time written as in RFC 3339, with a fraction of a second
+// only if it has one.
+func (vm *ASTInterpreter) evalFuncTodate(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	_, t, ok, err := vm.implicitTimeArg(build, m, args, 0, "function todate")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	out, err := build.String(t.Format(time.RFC3339Nano))
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == gmtime(int|float) -> array ==
+// Emits the time broken down into its parts.
+func (vm *ASTInterpreter) evalFuncGmtime(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	_, t, ok, err := vm.implicitTimeArg(build, m, args, 0, "function gmtime", msg.TypeInt, msg.TypeFloat)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	out, err := buildBrokenDown(build, t)
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == mktime(array) -> int|float ==
+// Emits the time that was broken down into its parts. Parts out of their
+// range carry over to the next, and the weekday and the day of the year
+// are ignored.
+func (vm *ASTInterpreter) evalFuncMktime(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	_, t, ok, err := vm.implicitTimeArg(build, m, args, 0, "function mktime", msg.TypeArray)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	out, err := buildTime(build, t)
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == strptime(s, format string) -> array ==
+// Emits the time written in the string, broken down into its parts. The
+// format is that of C's strptime, like "%Y-%m-%dT%H:%M:%SZ".
+func (vm *ASTInterpreter) evalFuncStrptime(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	strs, ok, err := vm.evalStrings(build, m, args, "function strptime", 1)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	t, err := parseTime(strs[0], strs[1])
+	if err != nil {
+		return vm.skipEvalWrongArgValue("function strptime", msg.TypeString, err.Error())
+	}
+	out, err := buildBrokenDown(build, t)
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == strftime(int|float|array, format string) -> string ==
+// Emits the time written in the format of C's strftime, like
+// "%Y-%m-%dT%H:%M:%SZ".
+func (vm *ASTInterpreter) evalFuncStrftime(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	args, t, ok, err := vm.implicitTimeArg(build, m, args, 1, "function strftime")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	format, ok, err := vm.evalExprToMsgType(build, m, args[0], "function strftime", msg.TypeString)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	s, err := formatTime(t, format.StringVal())
+	if err != nil {
+		return vm.skipEvalWrongArgValue("function strftime", format.Type(), err.Error())
+	}
+	out, err := build.String(s)
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == duration(string) -> int|float ==
+// Emits the number of seconds in a duration like "5m", "1h30m" or
+// "1.5s". The units are "ns", "us", "ms", "s", "m" and "h".
+func (vm *ASTInterpreter) evalFuncDuration(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	strs, ok, err := vm.evalStrings(build, m, args, "function duration", 0)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	d, err := time.ParseDuration(strs[0])
+	if err != nil {
+		return vm.skipEvalWrongArgValue("function duration", msg.TypeString, err.Error())
+	}
+	out, err := buildSeconds(build, d)
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == dateadd(date string|int|float, duration string|int|float) -> string|int|float ==
+// Emits the date moved later by the duration. The date is written as in
+// RFC 3339 or is a number of seconds, and the duration is written as
+// for the duration function or is a number of seconds. The date that's
+// emitted is of the same kind as the one given.
+func (vm *ASTInterpreter) evalFuncDateadd(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalDateArithmetic(build, m, args, sink, "function dateadd", 1)
+}
+
+// == datesub(date string|int|float, duration string|int|float) -> string|int|float ==
+// Emits the date moved earlier by the duration, as with dateadd.
+func (vm *ASTInterpreter) evalFuncDatesub(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalDateArithmetic(build, m, args, sink, "function datesub", -1)
+}
+
+// helper
+
+// now is the time of the clock in the options, or of the system.
+func (vm *ASTInterpreter) now() time.Time {
+	if vm.opts.Clock != nil {
+		return vm.opts.Clock()
+	}
+	return time.Now()
+}
+
+func (vm *ASTInterpreter) evalFromDate(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink, action string) error {
+	defer trace()()
+	strs, ok, err := vm.evalStrings(build, m, args, action, 0)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, strs[0])
+	if err != nil {
+		return vm.skipEvalWrongArgValue(action, msg.TypeString, err.Error())
+	}
+	out, err := buildTime(build, t)
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+func (vm *ASTInterpreter) evalDateArithmetic(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink, action string, sign time.Duration) error {
+	defer trace()()
+
+	args, date, ok, err := vm.implicitArgOrEvalExpr(build, action, m, 1, args, msg.TypeString, msg.TypeInt, msg.TypeFloat)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	dur, ok, err := vm.evalExprToMsgType(build, m, args[0], action, msg.TypeString, msg.TypeInt, msg.TypeFloat)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+
+	var t time.Time
+	if date.Type() == msg.TypeString {
+		if t, err = time.Parse(time.RFC3339Nano, date.StringVal()); err != nil {
+			return vm.skipEvalWrongArgValue(action, date.Type(), err.Error())
+		}
+	} else if t, err = numberTime(date); err != nil {
+		return vm.skipEvalWrongArgValue(action, date.Type(), err.Error())
+	}
+	var d time.Duration
+	if dur.Type() == msg.TypeString {
+		if d, err = time.ParseDuration(dur.StringVal()); err != nil {
+			return vm.skipEvalWrongArgValue(action, dur.Type(), err.Error())
+		}
+	} else {
+		secs := floatVal(dur)
+		if math.IsNaN(secs) || math.Abs(secs) > math.MaxInt64/float64(time.Second) {
+			return vm.skipEvalWrongArgValue(action, dur.Type(), "duration is out of range")
+		}
+		d = time.Duration(secs * float64(time.Second))
+	}
+
+	t = t.Add(sign * d)
+	var out msg.Msg
+	if date.Type() == msg.TypeString {
+		out, err = build.String(t.UTC().Format(time.RFC3339Nano))
+	} else {
+		out, err = buildTime(build, t)
+	}
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// implicitTimeArg evaluates a time argument, a number or broken down, or
+// uses the current message if only implIfLen arguments are given.
+func (vm *ASTInterpreter) implicitTimeArg(build msg.Builder, m msg.Msg, args []*ast.Expr, implIfLen int, action string, want ...msg.Type) ([]*ast.Expr, time.Time, bool, error) {
+	if len(want) == 0 {
+		want = []msg.Type{msg.TypeInt, msg.TypeFloat, msg.TypeArray}
+	}
+	args, arg, ok, err := vm.implicitArgOrEvalExpr(build, action, m, implIfLen, args, want...)
+	if err != nil || !ok {
+		return nil, time.Time{}, ok, err
+	}
+	var t time.Time
+	if arg.Type() == msg.TypeArray {
+		t, err = brokenDownTime(arg)
+	} else {
+		t, err = numberTime(arg)
+	}
+	if err != nil {
+		return nil, time.Time{}, false, vm.skipEvalWrongArgValue(action, arg.Type(), err.Error())
+	}
+	return args, t, true, nil
+}
+
+// numberTime is the time at a number of seconds.
+func numberTime(n msg.Msg) (time.Time, error) {
+	if n.Type() == msg.TypeInt {
+		return time.Unix(n.IntVal(), 0).UTC(), nil
+	}
+	f := n.FloatVal()
+	if math.IsNaN(f) || math.IsInf(f, 0) || math.Abs(f) > math.MaxInt64 {
+		return time.Time{}, fmt.Errorf("%v isn't a time", f)
+	}
+	secs, frac := math.Modf(f)
+	return time.Unix(int64(secs), int64(frac*float64(time.Second))).UTC(), nil
+}
+
+// brokenDownTime is the time broken down in the array.
+func brokenDownTime(arr msg.Msg) (time.Time, error) {
+	if arr.Len() < 6 {
+		return time.Time{}, fmt.Errorf("a broken down time has at least 6 parts, not %d", arr.Len())
+	}
+	var parts [5]int
+	for i := range parts {
+		part := arr.Index(int64(i))
+		if part.Type() != msg.TypeInt {
+			return time.Time{}, fmt.Errorf("part %d of a broken down time is a %v", i, part.Type())
+		}
+		parts[i] = int(part.IntVal())
+	}
+	secs := arr.Index(5)
+	if secs.Type() != msg.TypeInt && secs.Type() != msg.TypeFloat {
+		return time.Time{}, fmt.Errorf("part 5 of a broken down time is a %v", secs.Type())
+	}
+	sec, err := numberTime(secs)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t := time.Date(parts[0], time.Month(parts[1]+1), parts[2], parts[3], parts[4], 0, 0, time.UTC)
+	return t.Add(time.Duration(sec.UnixNano())), nil
+}
+
+// buildTime builds the number of seconds at a time: an int, or a float
+// if there's a fraction of a second.
+func buildTime(build msg.Builder, t time.Time) (msg.Msg, error) {
+	if t.Nanosecond() == 0 {
+		return build.Int(t.Unix())
+	}
+	return build.Float(float64(t.Unix()) + float64(t.Nanosecond())/float64(time.Second))
+}
+
+// buildSeconds builds the number of seconds in a duration: an int, or a
+// float if there's a fraction of a second.
+func buildSeconds(build msg.Builder, d time.Duration) (msg.Msg, error) {
+	if d%time.Second == 0 {
+		return build.Int(int64(d / time.Second))
+	}
+	return build.Float(d.Seconds())
+}
+
+// buildBrokenDown builds an array of the parts of a time.
+func buildBrokenDown(build msg.Builder, t time.Time) (msg.Msg, error) {
+	t = t.UTC()
+	return build.Array(func(ab msg.ArrayBuilder) error {
+		parts := []int{t.Year(), int(t.Month()) - 1, t.Day(), t.Hour(), t.Minute()}
+		for _, part := range parts {
+			err := ab.AddElem(func(b msg.Builder) (msg.Msg, error) {
+				return b.Int(int64(part))
+			})
+			if err != nil {
+				return err
+			}
+		}
+		err := ab.AddElem(func(b msg.Builder) (msg.Msg, error) {
+			return buildSeconds(b, time.Duration(t.Second())*time.Second+time.Duration(t.Nanosecond()))
+		})
+		if err != nil {
+			return err
+		}
+		for _, part := range []int{int(t.Weekday()), t.YearDay() - 1} {
+			err := ab.AddElem(func(b msg.Builder) (msg.Msg, error) {
+				return b.Int(int64(part))
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
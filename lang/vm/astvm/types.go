@@ -0,0 +1,135 @@
+package astvm
+
+import (
+	"strings"
+
+	"github.com/aybabtme/streamql/lang/ast"
+	"github.com/aybabtme/streamql/lang/check"
+	"github.com/aybabtme/streamql/lang/msg"
+)
+
+var (
+	sigType = &check.Func{
+		Arities: []int{0, 1},
+		Params:  []check.Types{check.AnyType},
+		Result:  stringType,
+	}
+	sigIsType = &check.Func{
+		Arities: []int{0, 1},
+		Params:  []check.Types{check.AnyType},
+		Result:  check.TypesOf(msg.TypeBool),
+	}
+)
+
+// typeFilter is a function that emits the current message only if it's
+// of some types.
+type typeFilter struct {
+	sig   *check.Func
+	types []msg.Type
+}
+
+func newTypeFilter(types ...msg.Type) *typeFilter {
+	return &typeFilter{
+		sig: &check.Func{
+			Arities:     []int{0},
+			Params:      []check.Types{check.AnyType},
+			Result:      check.TypesOf(types...),
+			Passthrough: true,
+		},
+		types: types,
+	}
+}
+
+var typeFilters = map[string]*typeFilter{
+	"objects":   newTypeFilter(msg.TypeObject),
+	"arrays":    newTypeFilter(msg.TypeArray),
+	"strings":   newTypeFilter(msg.TypeString),
+	"numbers":   newTypeFilter(msg.TypeInt, msg.TypeFloat),
+	"booleans":  newTypeFilter(msg.TypeBool),
+	"nulls":     newTypeFilter(msg.TypeNull),
+	"iterables": newTypeFilter(msg.TypeObject, msg.TypeArray),
+	"scalars":   newTypeFilter(msg.TypeString, msg.TypeInt, msg.TypeFloat, msg.TypeBool, msg.TypeNull),
+}
+
+// == type(msg.Msg) -> string ==
+// Emits the name of the type of the message: "object", "array",
+// "string", "int", "float", "bool" or "null".
+func (vm *ASTInterpreter) evalFuncType(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	arg, ok, err := vm.implicitArgOrEvalAny(build, m, args)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	out, err := build.String(typeName(arg.Type()))
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == isint(msg.Msg) -> bool ==
+// Emits a boolean: if the message is an int. A float is never one, even
+// if it has no fraction.
+func (vm *ASTInterpreter) evalFuncIsint(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalIsType(build, m, args, sink, msg.TypeInt)
+}
+
+// == isfloat(msg.Msg) -> bool ==
+// Emits a boolean: if the message is a float.
+func (vm *ASTInterpreter) evalFuncIsfloat(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalIsType(build, m, args, sink, msg.TypeFloat)
+}
+
+// == objects, arrays, strings, numbers, booleans, nulls, iterables, scalars -> msg.Msg ==
+// Emit the current message if it's of the type they're named after, and
+// nothing otherwise. Iterables are objects and arrays, and scalars are
+// all the others.
+func (f *typeFilter) eval(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	for _, t := range f.types {
+		if m.Type() == t {
+			return sink(m)
+		}
+	}
+	return nil
+}
+
+// helper
+
+func (vm *ASTInterpreter) evalIsType(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink, want msg.Type) error {
+	defer trace()()
+
+	arg, ok, err := vm.implicitArgOrEvalAny(build, m, args)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	out, err := build.Bool(arg.Type() == want)
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// implicitArgOrEvalAny is the only argument of a function that takes a
+// message of any type, the current message if it isn't given.
+func (vm *ASTInterpreter) implicitArgOrEvalAny(build msg.Builder, m msg.Msg, args []*ast.Expr) (msg.Msg, bool, error) {
+	if len(args) == 0 {
+		return m, true, nil
+	}
+	return vm.evalExprToMsg(build, m, args[0])
+}
+
+// typeName is the name of a type in a query, like "int" for
+// msg.TypeInt.
+func typeName(t msg.Type) string {
+	return strings.ToLower(strings.TrimPrefix(t.String(), "Type"))
+}
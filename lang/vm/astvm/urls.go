@@ -0,0 +1,165 @@
+package astvm
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aybabtme/streamql/lang/ast"
+	"github.com/aybabtme/streamql/lang/check"
+	"github.com/aybabtme/streamql/lang/msg"
+)
+
+var (
+	sigStringToObject = &check.Func{
+		Arities: []int{0, 1},
+		Params:  []check.Types{stringType},
+		Result:  objectType,
+	}
+)
+
+// == url_parse(string) -> object ==
+// Emits an object with the parts of the URL: its "scheme", "host",
+// "port", "path", "query" and "fragment". The port is an int, or null if
+// there's none, and the others are strings, empty if they're not in the
+// URL. The path and the fragment are decoded, the query isn't; see
+// query_parse.
+//
+//	"https://example.com:8080/api/v1?a=1#top" -> {"scheme": "https", "host": "example.com", "port": 8080, "path": "/api/v1", "query": "a=1", "fragment": "top"}
+func (vm *ASTInterpreter) evalFuncURLParse(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	strs, ok, err := vm.evalStrings(build, m, args, "function url_parse", 0)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	u, err := url.Parse(strs[0])
+	if err != nil {
+		return vm.skipEvalWrongArgValue("function url_parse", msg.TypeString, fmt.Sprintf("invalid URL %q", strs[0]))
+	}
+	str := func(s string) func(msg.Builder) (msg.Msg, error) {
+		return func(b msg.Builder) (msg.Msg, error) { return b.String(s) }
+	}
+	out, err := build.Object(func(ob msg.ObjectBuilder) error {
+		if err := ob.AddMember("scheme", str(u.Scheme)); err != nil {
+			return err
+		}
+		if err := ob.AddMember("host", str(u.Hostname())); err != nil {
+			return err
+		}
+		err := ob.AddMember("port", func(b msg.Builder) (msg.Msg, error) {
+			port, err := strconv.ParseInt(u.Port(), 10, 64)
+			if err != nil {
+				return b.Null()
+			}
+			return b.Int(port)
+		})
+		if err != nil {
+			return err
+		}
+		if err := ob.AddMember("path", str(u.Path)); err != nil {
+			return err
+		}
+		if err := ob.AddMember("query", str(u.RawQuery)); err != nil {
+			return err
+		}
+		return ob.AddMember("fragment", str(u.Fragment))
+	})
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == query_parse(string) -> object ==
+// Emits an object with the decoded parameters of the query string, like
+// "a=1&b=x+y", in the order they first appear. The value of a parameter
+// that's there once is a string, and that of one that's there more than
+// once is an array of strings. A leading "?" is ignored.
+func (vm *ASTInterpreter) evalFuncQueryParse(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	strs, ok, err := vm.evalStrings(build, m, args, "function query_parse", 0)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	var (
+		keys   []string
+		values = make(map[string][]string)
+	)
+	for _, param := range strings.Split(strings.TrimPrefix(strs[0], "?"), "&") {
+		if param == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(param, "=")
+		key, err := url.QueryUnescape(k)
+		if err != nil {
+			return vm.skipEvalWrongArgValue("function query_parse", msg.TypeString, fmt.Sprintf("invalid query string: %q isn't encoded properly", k))
+		}
+		value, err := url.QueryUnescape(v)
+		if err != nil {
+			return vm.skipEvalWrongArgValue("function query_parse", msg.TypeString, fmt.Sprintf("invalid query string: %q isn't encoded properly", v))
+		}
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+		values[key] = append(values[key], value)
+	}
+	out, err := build.Object(func(ob msg.ObjectBuilder) error {
+		for _, k := range keys {
+			vals := values[k]
+			err := ob.AddMember(k, func(b msg.Builder) (msg.Msg, error) {
+				if len(vals) == 1 {
+					return b.String(vals[0])
+				}
+				return buildStrings(b, vals)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
+
+// == url_encode(string) -> string ==
+// Emits the string encoded to be put in a query string, like
+// "a b&c" -> "a+b%26c".
+func (vm *ASTInterpreter) evalFuncURLEncode(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+	return vm.evalStringToString(build, m, args, sink, "function url_encode", url.QueryEscape)
+}
+
+// == url_decode(string) -> string ==
+// Emits the string decoded from a query string, like "a+b%26c" -> "a b&c".
+func (vm *ASTInterpreter) evalFuncURLDecode(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
+	defer trace()()
+
+	strs, ok, err := vm.evalStrings(build, m, args, "function url_decode", 0)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	s, err := url.QueryUnescape(strs[0])
+	if err != nil {
+		return vm.skipEvalWrongArgValue("function url_decode", msg.TypeString, fmt.Sprintf("%q isn't encoded properly", strs[0]))
+	}
+	out, err := build.String(s)
+	if err != nil {
+		return err
+	}
+	return sink(out)
+}
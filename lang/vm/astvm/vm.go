@@ -4,17 +4,17 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"sort"
+	"math"
 	"strconv"
 
 	"runtime"
 
 	"strings"
 
-	"regexp"
-
 	"github.com/aybabtme/streamql/lang/ast"
+	"github.com/aybabtme/streamql/lang/check"
 	"github.com/aybabtme/streamql/lang/msg"
+	"github.com/aybabtme/streamql/lang/msg/msgutil"
 	"github.com/aybabtme/streamql/lang/vm"
 )
 
@@ -44,12 +44,21 @@ func trace() func() {
 type ASTInterpreter struct {
 	opts *vm.Options
 	tree *ast.AST
+
+	// patterns are the regexps given to functions as literals,
+	// compiled ahead of time.
+	patterns map[*ast.Expr]*pattern
+	// cache has the patterns that aren't literals, compiled when they
+	// were last used.
+	cache *patternCache
 }
 
 func Interpreter(tree *ast.AST, opts *vm.Options) vm.VM {
 	return &ASTInterpreter{
-		opts: opts,
-		tree: tree,
+		opts:     opts,
+		tree:     tree,
+		patterns: compilePatterns(tree),
+		cache:    new(patternCache),
 	}
 }
 
@@ -102,10 +111,30 @@ type skipableError interface {
 	IsSkipable()
 }
 
-type skipable struct{ error }
+type skipable struct {
+	error
+	// where in the query the error happened, if known
+	span *ast.Span
+}
 
 func (*skipable) IsSkipable() {}
 
+func (s *skipable) Error() string {
+	if s.span == nil {
+		return s.error.Error()
+	}
+	return fmt.Sprintf("%v: %v", s.span, s.error)
+}
+
+// at notes that err happened in the part of the query found at span,
+// unless it's already known to have happened somewhere more precise.
+func at(span *ast.Span, err error) error {
+	if s, ok := err.(*skipable); ok && s.span == nil {
+		s.span = span
+	}
+	return err
+}
+
 func (vm *ASTInterpreter) skipEvalWrongType(action string, got msg.Type, want ...msg.Type) error {
 	defer trace()()
 	str := fmt.Sprintf("%s is not defined on %v (can be done on ", action, got)
@@ -115,7 +144,7 @@ func (vm *ASTInterpreter) skipEvalWrongType(action string, got msg.Type, want ..
 	for _, w := range want[1:] {
 		str += fmt.Sprintf(" or %v", w)
 	}
-	return &skipable{errors.New(str + ")")}
+	return &skipable{error: errors.New(str + ")")}
 }
 
 func (vm *ASTInterpreter) skipEvalWrongArgType(action string, target msg.Type, arg msg.Type, want ...msg.Type) error {
@@ -127,12 +156,12 @@ func (vm *ASTInterpreter) skipEvalWrongArgType(action string, target msg.Type, a
 	for _, w := range want[1:] {
 		str += fmt.Sprintf(" or %v", w)
 	}
-	return &skipable{errors.New(str + ")")}
+	return &skipable{error: errors.New(str + ")")}
 }
 
 func (vm *ASTInterpreter) skipEvalWrongArgValue(action string, arg msg.Type, problem string) error {
 	defer trace()()
-	return &skipable{fmt.Errorf("%s with given %v is impossible: %s", action, arg, problem)}
+	return &skipable{error: fmt.Errorf("%s with given %v is impossible: %s", action, arg, problem)}
 }
 
 func (vm *ASTInterpreter) evalExpr(build msg.Builder, m msg.Msg, expr *ast.Expr, sink msg.Sink) error {
@@ -148,15 +177,15 @@ func (vm *ASTInterpreter) evalExpr(build msg.Builder, m msg.Msg, expr *ast.Expr,
 
 	switch {
 	case expr.Literal != nil:
-		return vm.evalLiteral(build, m, expr.Literal, sink)
+		return at(expr.Span, vm.evalLiteral(build, m, expr.Literal, sink))
 	case expr.Selector != nil:
-		return vm.evalSelector(build, m, expr.Selector, sink)
+		return at(expr.Span, vm.evalSelector(build, m, expr.Selector, sink))
 	case expr.UnaryOperator != nil:
-		return vm.evalUnaryOperator(build, m, expr.UnaryOperator, sink)
+		return at(expr.Span, vm.evalUnaryOperator(build, m, expr.UnaryOperator, sink))
 	case expr.BinaryOperator != nil:
-		return vm.evalBinaryOperator(build, m, expr.BinaryOperator, sink)
+		return at(expr.Span, vm.evalBinaryOperator(build, m, expr.BinaryOperator, sink))
 	case expr.FuncCall != nil:
-		return vm.evalFuncCall(build, m, expr.FuncCall, sink)
+		return at(expr.Span, vm.evalFuncCall(build, m, expr.FuncCall, sink))
 	default:
 		panic("invalid expression in AST has no possible evaluation branches")
 	}
@@ -204,9 +233,9 @@ func (vm *ASTInterpreter) evalSelector(build msg.Builder, m msg.Msg, s *ast.Sele
 	defer trace()()
 	switch {
 	case s.Member != nil:
-		return vm.evalMemberSelector(build, m, s.Member, sink)
+		return at(s.Span, vm.evalMemberSelector(build, m, s.Member, sink))
 	case s.Slice != nil:
-		return vm.evalSliceSelector(build, m, s.Slice, sink)
+		return at(s.Span, vm.evalSliceSelector(build, m, s.Slice, sink))
 	case s.Noop != nil:
 		return sink(m)
 	default:
@@ -393,92 +422,25 @@ func (vm *ASTInterpreter) evalBinaryOperator(build msg.Builder, m msg.Msg, o *as
 	// numerical operators
 	switch {
 	case o.NumAdd != nil:
-		lhs, ok, err := vm.evalExprToMsgType(build, m, o.LHS, "left of an addition", msg.TypeInt, msg.TypeFloat, msg.TypeString)
+		lhs, ok, err := vm.evalExprToMsgType(build, m, o.LHS, "left of an addition", msg.TypeInt, msg.TypeFloat, msg.TypeString, msg.TypeObject)
 		if err != nil {
 			return err
 		}
 		if !ok {
 			return nil
 		}
-		rhs, ok, err := vm.evalExprToMsgType(build, m, o.RHS, "right of an addition", msg.TypeInt, msg.TypeFloat, msg.TypeString)
+		rhs, ok, err := vm.evalExprToMsgType(build, m, o.RHS, "right of an addition", msg.TypeInt, msg.TypeFloat, msg.TypeString, msg.TypeObject)
 		if err != nil {
 			return err
 		}
 		if !ok {
 			return nil
 		}
-		switch lhs.Type() {
-		case msg.TypeInt:
-			switch rhs.Type() {
-			case msg.TypeInt: // Int + Int
-				v, err := build.Int(lhs.IntVal() + rhs.IntVal())
-				if err != nil {
-					return err
-				}
-				return sink(v)
-			case msg.TypeFloat: // Int + Float, promote Int
-				v, err := build.Float(float64(lhs.IntVal()) + rhs.FloatVal())
-				if err != nil {
-					return err
-				}
-				return sink(v)
-			case msg.TypeString: // Int + String, promote Int
-				v, err := build.String(strconv.FormatInt(lhs.IntVal(), 10) + rhs.StringVal())
-				if err != nil {
-					return err
-				}
-				return sink(v)
-			}
-		case msg.TypeFloat:
-			switch rhs.Type() {
-			case msg.TypeInt: // Float + Int, promote Int
-				v, err := build.Float(lhs.FloatVal() + float64(rhs.IntVal()))
-				if err != nil {
-					return err
-				}
-				return sink(v)
-			case msg.TypeFloat: // Float + Float
-				v, err := build.Float(lhs.FloatVal() + rhs.FloatVal())
-				if err != nil {
-					return err
-				}
-				return sink(v)
-			case msg.TypeString: // Float + String, promote Float
-				v, err := build.String(
-					strconv.FormatFloat(lhs.FloatVal(), 'g', -1, 64) + rhs.StringVal(),
-				)
-				if err != nil {
-					return err
-				}
-				return sink(v)
-			}
-		case msg.TypeString:
-			switch rhs.Type() {
-			case msg.TypeInt: // String + Int, promote Int
-				v, err := build.String(
-					lhs.StringVal() + strconv.FormatInt(rhs.IntVal(), 10),
-				)
-				if err != nil {
-					return err
-				}
-				return sink(v)
-			case msg.TypeFloat: // String + Float, promote Float
-				v, err := build.String(
-					lhs.StringVal() + strconv.FormatFloat(rhs.FloatVal(), 'g', -1, 64),
-				)
-				if err != nil {
-					return err
-				}
-				return sink(v)
-			case msg.TypeString: // String + String
-				v, err := build.String(lhs.StringVal() + rhs.StringVal())
-				if err != nil {
-					return err
-				}
-				return sink(v)
-			}
+		v, err := vm.evalAddition(build, lhs, rhs)
+		if err != nil {
+			return err
 		}
-		panic("missing case")
+		return sink(v)
 
 	case o.NumSub != nil:
 		lhs, ok, err := vm.evalExprToMsgType(build, m, o.LHS, "left of a subtraction", msg.TypeInt, msg.TypeFloat)
@@ -584,21 +546,67 @@ func (vm *ASTInterpreter) evalBinaryOperator(build msg.Builder, m msg.Msg, o *as
 			panic("missing case")
 		}
 
+	case o.NumMod != nil:
+		lhs, ok, err := vm.evalExprToMsgType(build, m, o.LHS, "left of a modulo", msg.TypeInt, msg.TypeFloat)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		rhs, ok, err := vm.evalExprToMsgType(build, m, o.RHS, "right of a modulo", msg.TypeInt, msg.TypeFloat)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		switch {
+		case rhs.Type() == msg.TypeFloat && rhs.FloatVal() == 0:
+			return vm.skipEvalWrongArgValue("modulo", rhs.Type(), "can't divide by zero")
+		case rhs.Type() == msg.TypeInt && rhs.IntVal() == 0:
+			return vm.skipEvalWrongArgValue("modulo", rhs.Type(), "can't divide by zero")
+		}
+		if lhs.Type() == msg.TypeInt && rhs.Type() == msg.TypeInt { // Int % Int
+			v, err := build.Int(lhs.IntVal() % rhs.IntVal())
+			if err != nil {
+				return err
+			}
+			return sink(v)
+		}
+		// Float % Float, promote Int
+		v, err := build.Float(math.Mod(floatVal(lhs), floatVal(rhs)))
+		if err != nil {
+			return err
+		}
+		return sink(v)
+
 	case o.NumMul != nil:
-		lhs, ok, err := vm.evalExprToMsgType(build, m, o.LHS, "left of a multiplication", msg.TypeInt, msg.TypeFloat)
+		lhs, ok, err := vm.evalExprToMsgType(build, m, o.LHS, "left of a multiplication", msg.TypeInt, msg.TypeFloat, msg.TypeObject)
 		if err != nil {
 			return err
 		}
 		if !ok {
 			return nil
 		}
-		rhs, ok, err := vm.evalExprToMsgType(build, m, o.RHS, "right of a multiplication", msg.TypeInt, msg.TypeFloat)
+		rhs, ok, err := vm.evalExprToMsgType(build, m, o.RHS, "right of a multiplication", msg.TypeInt, msg.TypeFloat, msg.TypeObject)
 		if err != nil {
 			return err
 		}
 		if !ok {
 			return nil
 		}
+		if lhs.Type() == msg.TypeObject || rhs.Type() == msg.TypeObject {
+			if lhs.Type() != rhs.Type() {
+				return vm.skipEvalWrongArgType("multiplication", lhs.Type(), rhs.Type(), lhs.Type())
+			}
+			// Object * Object, recursive merge
+			v, err := mergeObjects(build, lhs, rhs, true)
+			if err != nil {
+				return err
+			}
+			return sink(v)
+		}
 		switch lhs.Type() {
 		case msg.TypeInt:
 			switch rhs.Type() {
@@ -634,240 +642,20 @@ func (vm *ASTInterpreter) evalBinaryOperator(build msg.Builder, m msg.Msg, o *as
 		}
 	}
 
-	var checkEq func(lhs, rhs msg.Msg) bool
-	checkEq = func(lhs, rhs msg.Msg) bool {
-		switch {
-		case lhs.Type() == msg.TypeInt && rhs.Type() == msg.TypeFloat:
-			return float64(lhs.IntVal()) == rhs.FloatVal()
-		case lhs.Type() == msg.TypeFloat && rhs.Type() == msg.TypeInt:
-			return lhs.FloatVal() == float64(rhs.IntVal())
-		default:
-			if lhs.Type() != rhs.Type() {
-				return false
-			}
-		}
-
-		switch lhs.Type() {
-		case msg.TypeObject:
-			lhsKeys := lhs.Keys()
-			rhsKeys := rhs.Keys()
-			if len(lhsKeys) != len(rhsKeys) {
-				return false
-			}
-			sort.Strings(lhsKeys)
-			sort.Strings(rhsKeys)
-			for i, lhsKey := range lhsKeys {
-				rhsKey := rhsKeys[i]
-				if lhsKey != rhsKey {
-					return false
-				}
-				lhsV, lhsOk := lhs.Member(lhsKey)
-				rhsV, rhsOk := rhs.Member(rhsKey)
-				if lhsOk != rhsOk {
-					return false
-				}
-				if !checkEq(lhsV, rhsV) {
-					return false
-				}
-			}
-			return true
-
-		case msg.TypeArray:
-			if lhs.Len() != rhs.Len() {
-				return false
-			}
-			for i := int64(0); i < lhs.Len(); i++ {
-				if !checkEq(lhs.Index(i), rhs.Index(i)) {
-					return false
-				}
-			}
-			return true
-
-		case msg.TypeString:
-			return lhs.StringVal() == rhs.StringVal()
-		case msg.TypeInt:
-			return lhs.IntVal() == rhs.IntVal()
-		case msg.TypeFloat:
-			return lhs.FloatVal() == rhs.FloatVal()
-		case msg.TypeBool:
-			return lhs.BoolVal() == rhs.BoolVal()
-		case msg.TypeNull:
-			return lhs.IsNull() == rhs.IsNull()
-		default:
-			panic("missing case")
-		}
-	}
-
-	var checkLess func(lhs, rhs msg.Msg) (bool, error)
-	checkLess = func(lhs, rhs msg.Msg) (bool, error) {
-		switch {
-		case lhs.Type() == msg.TypeInt && rhs.Type() == msg.TypeFloat:
-			return float64(lhs.IntVal()) < rhs.FloatVal(), nil
-		case lhs.Type() == msg.TypeFloat && rhs.Type() == msg.TypeInt:
-			return lhs.FloatVal() < float64(rhs.IntVal()), nil
-		default:
-			if lhs.Type() != rhs.Type() {
-				return false, vm.skipEvalWrongType("comparison", rhs.Type(), lhs.Type())
-			}
-
-		}
-		switch lhs.Type() {
-		case msg.TypeString:
-			return lhs.StringVal() < rhs.StringVal(), nil
-		case msg.TypeInt:
-			return lhs.IntVal() < rhs.IntVal(), nil
-		case msg.TypeFloat:
-			return lhs.FloatVal() < rhs.FloatVal(), nil
-		default:
-			panic("missing case")
-		}
-	}
-
 	// comparators
 	switch {
 	case o.CmpEq != nil:
-		lhs, ok, err := vm.evalExprToMsgType(build, m, o.LHS, "left of an equality", msg.TypeInt, msg.TypeFloat, msg.TypeBool, msg.TypeString, msg.TypeArray, msg.TypeObject)
-		if err != nil {
-			return err
-		}
-		if !ok {
-			return nil
-		}
-		rhs, ok, err := vm.evalExprToMsgType(build, m, o.RHS, "right of an equality", msg.TypeInt, msg.TypeFloat, msg.TypeBool, msg.TypeString, msg.TypeArray, msg.TypeObject)
-		if err != nil {
-			return err
-		}
-		if !ok {
-			return nil
-		}
-		v, err := build.Bool(checkEq(lhs, rhs))
-		if err != nil {
-			return err
-		}
-		return sink(v)
-
+		return vm.evalComparison(build, m, o, sink, "an equality", func(c int) bool { return c == 0 })
 	case o.CmpNotEq != nil:
-		lhs, ok, err := vm.evalExprToMsgType(build, m, o.LHS, "left of a non-equality", msg.TypeInt, msg.TypeFloat, msg.TypeBool, msg.TypeString, msg.TypeArray, msg.TypeObject)
-		if err != nil {
-			return err
-		}
-		if !ok {
-			return nil
-		}
-		rhs, ok, err := vm.evalExprToMsgType(build, m, o.RHS, "right of a non-equality", msg.TypeInt, msg.TypeFloat, msg.TypeBool, msg.TypeString, msg.TypeArray, msg.TypeObject)
-		if err != nil {
-			return err
-		}
-		if !ok {
-			return nil
-		}
-		v, err := build.Bool(!checkEq(lhs, rhs))
-		if err != nil {
-			return err
-		}
-		return sink(v)
-
+		return vm.evalComparison(build, m, o, sink, "a non-equality", func(c int) bool { return c != 0 })
 	case o.CmpGt != nil:
-		lhs, ok, err := vm.evalExprToMsgType(build, m, o.LHS, "left of a greater-than comparison", msg.TypeInt, msg.TypeFloat, msg.TypeString)
-		if err != nil {
-			return err
-		}
-		if !ok {
-			return nil
-		}
-		rhs, ok, err := vm.evalExprToMsgType(build, m, o.RHS, "right of a greater-than comparison", msg.TypeInt, msg.TypeFloat, msg.TypeString)
-		if err != nil {
-			return err
-		}
-		if !ok {
-			return nil
-		}
-		isLess, err := checkLess(lhs, rhs)
-		if err != nil {
-			return err
-		}
-		striclyGreater := !isLess && !checkEq(lhs, rhs)
-		v, err := build.Bool(striclyGreater)
-		if err != nil {
-			return err
-		}
-		return sink(v)
-
+		return vm.evalComparison(build, m, o, sink, "a greater-than comparison", func(c int) bool { return c > 0 })
 	case o.CmpGtOrEq != nil:
-		lhs, ok, err := vm.evalExprToMsgType(build, m, o.LHS, "left of a greater-than-or-equal comparison", msg.TypeInt, msg.TypeFloat, msg.TypeString)
-		if err != nil {
-			return err
-		}
-		if !ok {
-			return nil
-		}
-		rhs, ok, err := vm.evalExprToMsgType(build, m, o.RHS, "right of a greater-than-or-equal comparison", msg.TypeInt, msg.TypeFloat, msg.TypeString)
-		if err != nil {
-			return err
-		}
-		if !ok {
-			return nil
-		}
-		isLess, err := checkLess(lhs, rhs)
-		if err != nil {
-			return err
-		}
-		v, err := build.Bool(checkEq(lhs, rhs) || !isLess)
-		if err != nil {
-			return err
-		}
-		return sink(v)
-
+		return vm.evalComparison(build, m, o, sink, "a greater-than-or-equal comparison", func(c int) bool { return c >= 0 })
 	case o.CmpLs != nil:
-		lhs, ok, err := vm.evalExprToMsgType(build, m, o.LHS, "left of a less-than comparison", msg.TypeInt, msg.TypeFloat, msg.TypeString)
-		if err != nil {
-			return err
-		}
-		if !ok {
-			return nil
-		}
-		rhs, ok, err := vm.evalExprToMsgType(build, m, o.RHS, "right of a less-than comparison", msg.TypeInt, msg.TypeFloat, msg.TypeString)
-		if err != nil {
-			return err
-		}
-		if !ok {
-			return nil
-		}
-		isLess, err := checkLess(lhs, rhs)
-		if err != nil {
-			return err
-		}
-		strictlyLess := isLess && !checkEq(lhs, rhs)
-		v, err := build.Bool(strictlyLess)
-		if err != nil {
-			return err
-		}
-		return sink(v)
-
+		return vm.evalComparison(build, m, o, sink, "a less-than comparison", func(c int) bool { return c < 0 })
 	case o.CmpLsOrEq != nil:
-		lhs, ok, err := vm.evalExprToMsgType(build, m, o.LHS, "left of a less-than-or-equal comparison", msg.TypeInt, msg.TypeFloat, msg.TypeString)
-		if err != nil {
-			return err
-		}
-		if !ok {
-			return nil
-		}
-		rhs, ok, err := vm.evalExprToMsgType(build, m, o.RHS, "right of a less-than-or-equal comparison", msg.TypeInt, msg.TypeFloat, msg.TypeString)
-		if err != nil {
-			return err
-		}
-		if !ok {
-			return nil
-		}
-		isLess, err := checkLess(lhs, rhs)
-		if err != nil {
-			return err
-		}
-		v, err := build.Bool(checkEq(lhs, rhs) || isLess)
-		if err != nil {
-			return err
-		}
-		return sink(v)
+		return vm.evalComparison(build, m, o, sink, "a less-than-or-equal comparison", func(c int) bool { return c <= 0 })
 
 	default:
 		panic("invalid operator in AST has no possible evaluation branches")
@@ -875,20 +663,96 @@ func (vm *ASTInterpreter) evalBinaryOperator(build msg.Builder, m msg.Msg, o *as
 
 }
 
+// evalComparison compares both sides of an operator, as ordered by
+// msgutil.Compare, and emits if the comparison holds. All messages can
+// be compared, whatever their types.
+func (vm *ASTInterpreter) evalComparison(build msg.Builder, m msg.Msg, o *ast.BinaryOperator, sink msg.Sink, name string, holds func(c int) bool) error {
+	defer trace()()
+
+	comparable := []msg.Type{msg.TypeInt, msg.TypeFloat, msg.TypeBool, msg.TypeString, msg.TypeArray, msg.TypeObject, msg.TypeNull}
+	lhs, ok, err := vm.evalExprToMsgType(build, m, o.LHS, "left of "+name, comparable...)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	rhs, ok, err := vm.evalExprToMsgType(build, m, o.RHS, "right of "+name, comparable...)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	v, err := build.Bool(holds(msgutil.Compare(lhs, rhs)))
+	if err != nil {
+		return err
+	}
+	return sink(v)
+}
+
+// evalAddition adds two numbers or strings together, or merges two
+// objects. Anything added to a string is turned into a string.
+func (vm *ASTInterpreter) evalAddition(build msg.Builder, lhs, rhs msg.Msg) (msg.Msg, error) {
+	switch lhs.Type() {
+	case msg.TypeInt:
+		switch rhs.Type() {
+		case msg.TypeInt: // Int + Int
+			return build.Int(lhs.IntVal() + rhs.IntVal())
+		case msg.TypeFloat: // Int + Float, promote Int
+			return build.Float(float64(lhs.IntVal()) + rhs.FloatVal())
+		case msg.TypeString: // Int + String, promote Int
+			return build.String(strconv.FormatInt(lhs.IntVal(), 10) + rhs.StringVal())
+		}
+	case msg.TypeFloat:
+		switch rhs.Type() {
+		case msg.TypeInt: // Float + Int, promote Int
+			return build.Float(lhs.FloatVal() + float64(rhs.IntVal()))
+		case msg.TypeFloat: // Float + Float
+			return build.Float(lhs.FloatVal() + rhs.FloatVal())
+		case msg.TypeString: // Float + String, promote Float
+			return build.String(
+				strconv.FormatFloat(lhs.FloatVal(), 'g', -1, 64) + rhs.StringVal(),
+			)
+		}
+	case msg.TypeString:
+		switch rhs.Type() {
+		case msg.TypeInt: // String + Int, promote Int
+			return build.String(
+				lhs.StringVal() + strconv.FormatInt(rhs.IntVal(), 10),
+			)
+		case msg.TypeFloat: // String + Float, promote Float
+			return build.String(
+				lhs.StringVal() + strconv.FormatFloat(rhs.FloatVal(), 'g', -1, 64),
+			)
+		case msg.TypeString: // String + String
+			return build.String(lhs.StringVal() + rhs.StringVal())
+		}
+	case msg.TypeObject:
+		if rhs.Type() == msg.TypeObject { // Object + Object, shallow merge
+			return mergeObjects(build, lhs, rhs, false)
+		}
+	}
+	if lhs.Type() == msg.TypeObject || rhs.Type() == msg.TypeObject {
+		return nil, vm.skipEvalWrongArgType("addition", lhs.Type(), rhs.Type(), lhs.Type())
+	}
+	panic("missing case")
+}
+
 func (vm *ASTInterpreter) evalFuncCall(build msg.Builder, m msg.Msg, f *ast.FuncCall, sink msg.Sink) error {
 	defer trace()()
-	arities, fn := vm.lookupFuncs(f.Name)
+	sig, fn := vm.lookupFuncs(f.Name)
 	if fn == nil {
 		return fmt.Errorf("unknown function %q", f.Name)
 	}
-	for _, arity := range arities {
+	for _, arity := range sig.Arities {
 		if arity == len(f.Args) {
 			return fn(build, m, f.Args, sink)
 		}
 	}
-	arityString := strconv.Itoa(arities[0])
-	for i, arity := range arities[1:] {
-		if i == len(arities)-1 {
+	arityString := strconv.Itoa(sig.Arities[0])
+	for i, arity := range sig.Arities[1:] {
+		if i == len(sig.Arities)-1 {
 			arityString += " or "
 		} else {
 			arityString += ", "
@@ -898,30 +762,244 @@ func (vm *ASTInterpreter) evalFuncCall(build msg.Builder, m msg.Msg, f *ast.Func
 	return fmt.Errorf("function %q requires %s arguments, %d were given", f.Name, arityString, len(f.Args))
 }
 
+// Builtin describes the builtin function `name`, or returns false if
+// there's no such function.
+func Builtin(name string) (*check.Func, bool) {
+	sig, fn := new(ASTInterpreter).lookupFuncs(name)
+	return sig, fn != nil
+}
+
 type evalFunc func(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error
 
-func (vm *ASTInterpreter) lookupFuncs(name string) ([]int, evalFunc) {
+var (
+	sigSelect = &check.Func{
+		Arities:     []int{1, 2},
+		Params:      []check.Types{check.AnyType, check.TypesOf(msg.TypeBool)},
+		Passthrough: true,
+	}
+	sigLength = &check.Func{
+		Arities: []int{0, 1},
+		Params:  []check.Types{check.TypesOf(msg.TypeString, msg.TypeObject, msg.TypeArray)},
+		Result:  check.TypesOf(msg.TypeInt),
+	}
+	sigKeys = &check.Func{
+		Arities: []int{0, 1},
+		Params:  []check.Types{check.TypesOf(msg.TypeObject, msg.TypeArray)},
+		Result:  check.TypesOf(msg.TypeArray),
+	}
+	sigRegexp = &check.Func{
+		Arities:  []int{2},
+		Params:   []check.Types{check.TypesOf(msg.TypeString), check.TypesOf(msg.TypeString)},
+		Result:   check.TypesOf(msg.TypeBool),
+		Patterns: []int{1},
+	}
+	sigContains = &check.Func{
+		Arities: []int{2},
+		Params:  []check.Types{check.TypesOf(msg.TypeString), check.TypesOf(msg.TypeString)},
+		Result:  check.TypesOf(msg.TypeBool),
+	}
+	sigHas = &check.Func{
+		Arities: []int{1, 2},
+		Params:  []check.Types{check.TypesOf(msg.TypeObject, msg.TypeArray), check.TypesOf(msg.TypeString)},
+		Result:  check.TypesOf(msg.TypeBool),
+	}
+)
+
+func (vm *ASTInterpreter) lookupFuncs(name string) (*check.Func, evalFunc) {
 	defer trace()()
 	switch name {
 	// not implicit unary func
 	case "select":
-		return []int{1, 2}, vm.evalFuncSelect
+		return sigSelect, vm.evalFuncSelect
+
+	// not implicit nullary func
+	case "now":
+		return sigNow, vm.evalFuncNow
+
+	// filters on the type of the current message
+	case "objects", "arrays", "strings", "numbers", "booleans", "nulls", "iterables", "scalars":
+		filter := typeFilters[name]
+		return filter.sig, filter.eval
 
 	// implicit unary func
+	case "type":
+		return sigType, vm.evalFuncType
+	case "isint":
+		return sigIsType, vm.evalFuncIsint
+	case "isfloat":
+		return sigIsType, vm.evalFuncIsfloat
 	case "length":
-		return []int{0, 1}, vm.evalFuncLength
+		return sigLength, vm.evalFuncLength
 	case "keys":
-		return []int{0, 1}, vm.evalFuncKeys
+		return sigKeys, vm.evalFuncKeys
+	case "keys_unsorted":
+		return sigKeys, vm.evalFuncKeysUnsorted
+	case "to_entries":
+		return sigToEntries, vm.evalFuncToEntries
+	case "from_entries":
+		return sigFromEntries, vm.evalFuncFromEntries
+	case "upper":
+		return sigStringToString, vm.evalFuncUpper
+	case "lower":
+		return sigStringToString, vm.evalFuncLower
+	case "trim":
+		return sigStringToString, vm.evalFuncTrim
+	case "ltrim":
+		return sigStringToString, vm.evalFuncLtrim
+	case "rtrim":
+		return sigStringToString, vm.evalFuncRtrim
+	case "floor":
+		return sigNumberToNumber, vm.evalFuncFloor
+	case "ceil":
+		return sigNumberToNumber, vm.evalFuncCeil
+	case "round":
+		return sigNumberToNumber, vm.evalFuncRound
+	case "abs":
+		return sigNumberToNumber, vm.evalFuncAbs
+	case "sqrt":
+		return sigNumberToFloat, vm.evalFuncSqrt
+	case "log":
+		return sigNumberToFloat, vm.evalFuncLog
+	case "exp":
+		return sigNumberToFloat, vm.evalFuncExp
+	case "min":
+		return sigMinMax, vm.evalFuncMin
+	case "max":
+		return sigMinMax, vm.evalFuncMax
+	case "sort":
+		return sigArrayToArray, vm.evalFuncSort
+	case "unique":
+		return sigArrayToArray, vm.evalFuncUnique
+	case "reverse":
+		return sigReverse, vm.evalFuncReverse
+	case "add":
+		return sigAdd, vm.evalFuncAdd
+	case "fromdateiso8601":
+		return sigFromDate, vm.evalFuncFromdateiso8601
+	case "fromdate":
+		return sigFromDate, vm.evalFuncFromdate
+	case "todate", "todateiso8601":
+		return sigToDate, vm.evalFuncTodate
+	case "gmtime":
+		return sigGmtime, vm.evalFuncGmtime
+	case "mktime":
+		return sigMktime, vm.evalFuncMktime
+	case "duration":
+		return sigDuration, vm.evalFuncDuration
+	case "hash":
+		return sigHashToInt, vm.evalFuncHash
+	case "xxhash":
+		return sigHashToInt, vm.evalFuncXxhash
+	case "fnv":
+		return sigHashToInt, vm.evalFuncFnv
+	case "crc32":
+		return sigHashToInt, vm.evalFuncCrc32
+	case "sha256":
+		return sigHashToString, vm.evalFuncSha256
+	case "md5":
+		return sigHashToString, vm.evalFuncMd5
+	case "ip_parse":
+		return sigStringToString, vm.evalFuncIPParse
+	case "ip_version":
+		return sigIPVersion, vm.evalFuncIPVersion
+	case "is_private":
+		return sigStringToBool, vm.evalFuncIsPrivate
+	case "is_loopback":
+		return sigStringToBool, vm.evalFuncIsLoopback
+	case "url_parse":
+		return sigStringToObject, vm.evalFuncURLParse
+	case "query_parse":
+		return sigStringToObject, vm.evalFuncQueryParse
+	case "url_encode":
+		return sigStringToString, vm.evalFuncURLEncode
+	case "url_decode":
+		return sigStringToString, vm.evalFuncURLDecode
 
 		// not implicit binary func
 	case "regexp":
-		return []int{2}, vm.evalFuncRegexp
+		return sigRegexp, vm.evalFuncRegexp
 	case "contains":
-		return []int{2}, vm.evalFuncContains
+		return sigContains, vm.evalFuncContains
 
 		// implicit binary func
 	case "has":
-		return []int{1, 2}, vm.evalFuncHas
+		return sigHas, vm.evalFuncHas
+	case "ltrimstr":
+		return sigStringsToString, vm.evalFuncLtrimstr
+	case "rtrimstr":
+		return sigStringsToString, vm.evalFuncRtrimstr
+	case "startswith":
+		return sigStringsToBool, vm.evalFuncStartswith
+	case "endswith":
+		return sigStringsToBool, vm.evalFuncEndswith
+	case "split":
+		return sigSplit, vm.evalFuncSplit
+	case "join":
+		return sigJoin, vm.evalFuncJoin
+	case "pow":
+		return sigPow, vm.evalFuncPow
+	case "min_by":
+		return sigMinMaxBy, vm.evalFuncMinBy
+	case "max_by":
+		return sigMinMaxBy, vm.evalFuncMaxBy
+	case "sort_by":
+		return sigArrayByToArray, vm.evalFuncSortBy
+	case "unique_by":
+		return sigArrayByToArray, vm.evalFuncUniqueBy
+	case "group_by":
+		return sigGroupBy, vm.evalFuncGroupBy
+	case "map":
+		return sigMap, vm.evalFuncMap
+	case "any":
+		return sigAnyAll, vm.evalFuncAny
+	case "all":
+		return sigAnyAll, vm.evalFuncAll
+	case "index":
+		return sigIndex, vm.evalFuncIndex
+	case "inside":
+		return sigInside, vm.evalFuncInside
+	case "with_entries":
+		return sigWithEntries, vm.evalFuncWithEntries
+	case "strptime":
+		return sigStrptime, vm.evalFuncStrptime
+	case "strftime":
+		return sigStrftime, vm.evalFuncStrftime
+	case "in_cidr":
+		return sigStringsToBool, vm.evalFuncInCIDR
+	case "dateadd":
+		return sigDateAdd, vm.evalFuncDateadd
+	case "datesub":
+		return sigDateAdd, vm.evalFuncDatesub
+
+		// implicit ternary func
+	case "replace":
+		return sigReplace, vm.evalFuncReplace
+	case "clamp":
+		return sigClamp, vm.evalFuncClamp
+	case "sub":
+		return sigSub, vm.evalFuncSub
+	case "gsub":
+		return sigSub, vm.evalFuncGsub
+
+		// not implicit func of paths
+	case "pick":
+		return sigPick, vm.evalFuncPick
+
+		// implicit unary func, with an optional depth after the array
+	case "flatten":
+		return sigFlatten, vm.evalFuncFlatten
+
+		// regexp family, implicit binary func with optional flags
+	case "test":
+		return sigMatchBool, vm.evalFuncTest
+	case "match":
+		return sigMatchObject, vm.evalFuncMatch
+	case "capture":
+		return sigMatchObject, vm.evalFuncCapture
+	case "scan":
+		return sigScan, vm.evalFuncScan
+	case "splits":
+		return sigSplits, vm.evalFuncSplits
 
 	}
 	return nil, nil
@@ -983,56 +1061,10 @@ func (vm *ASTInterpreter) evalFuncLength(build msg.Builder, m msg.Msg, args []*a
 }
 
 // == keys(object|array) -> array ==
-// Emits an array representing the keys an object, or the indices of an array.
+// Emits an array representing the sorted keys of an object, or the indices of an array.
 func (vm *ASTInterpreter) evalFuncKeys(build msg.Builder, m msg.Msg, args []*ast.Expr, sink msg.Sink) error {
 	defer trace()()
-
-	_, arg, ok, err := vm.implicitArgOrEvalExpr(build, "function keys", m, 0, args, msg.TypeObject, msg.TypeArray)
-	if err != nil {
-		return err
-	}
-	if !ok {
-		return nil
-	}
-
-	var abFunc func(msg.ArrayBuilder) error
-
-	switch arg.Type() {
-	case msg.TypeObject:
-		abFunc = func(ab msg.ArrayBuilder) error {
-			keys := make([]string, len(arg.Keys()))
-			copy(keys, arg.Keys())
-			sort.Strings(keys)
-			for _, k := range keys {
-				err := ab.AddElem(func(b msg.Builder) (msg.Msg, error) {
-					return b.String(k)
-				})
-				if err != nil {
-					return err
-				}
-			}
-			return nil
-		}
-
-	case msg.TypeArray:
-		abFunc = func(ab msg.ArrayBuilder) error {
-			for i := int64(0); i < arg.Len(); i++ {
-				err := ab.AddElem(func(b msg.Builder) (msg.Msg, error) {
-					return b.Int(i)
-				})
-				if err != nil {
-					return err
-				}
-			}
-			return nil
-		}
-	}
-
-	keys, err := build.Array(abFunc)
-	if err != nil {
-		return err
-	}
-	return sink(keys)
+	return vm.evalKeys(build, m, args, sink, "function keys", true)
 }
 
 // == has(object, string) -> bool ==
@@ -1075,19 +1107,13 @@ func (vm *ASTInterpreter) evalFuncRegexp(build msg.Builder, m msg.Msg, args []*a
 		return nil
 	}
 
-	pattern, ok, err := vm.evalExprToMsgType(build, m, args[1], "function regexp", msg.TypeString)
+	re, ok, err := vm.evalPattern(build, m, "function regexp", args[1], nil)
 	if err != nil {
 		return err
 	}
 	if !ok {
 		return nil
 	}
-
-	// figure a way to cache the compiled regexp.... if the argument is a compile time literal, for instance
-	re, err := regexp.Compile(pattern.StringVal())
-	if err != nil {
-		return vm.skipEvalWrongArgValue("function regexp", pattern.Type(), "invalid regexp: "+err.Error())
-	}
 	match, err := build.Bool(re.MatchString(s.StringVal()))
 	if err != nil {
 		return err
@@ -1177,5 +1203,5 @@ func (vm *ASTInterpreter) implicitArgOrEvalExpr(build msg.Builder, action string
 			return args, m, true, nil
 		}
 	}
-	return nil, nil, false, vm.skipEvalWrongType(action, m.Type(), msg.TypeString, msg.TypeObject, msg.TypeArray)
+	return nil, nil, false, vm.skipEvalWrongType(action, m.Type(), want...)
 }
@@ -1,9 +1,14 @@
 package astvm
 
 import (
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/aybabtme/streamql/lang/grammar"
+	"github.com/aybabtme/streamql/lang/msg"
 	"github.com/aybabtme/streamql/lang/msg/gomsg"
+	"github.com/aybabtme/streamql/lang/vm"
 	"github.com/aybabtme/streamql/lang/vm/vmtest"
 )
 
@@ -11,6 +16,86 @@ func TestInterpreter(t *testing.T) {
 	vmtest.Verify(t, Interpreter)
 }
 
+func TestSkipableErrorSpan(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`.[1]`, `1:1-1:5: index is not defined on TypeInt (can be done on TypeObject or TypeArray)`},
+		{`. | 1 / .`, `1:5-1:10: division with given TypeInt is impossible: can't divide by zero`},
+		{`. | 1 % .`, `1:5-1:10: modulo with given TypeInt is impossible: can't divide by zero`},
+		{`.a | .b`, `1:1-1:3: index is not defined on TypeInt (can be done on TypeObject or TypeArray)`},
+		{`sub("a", "b", .)`, `1:15-1:16: function sub by TypeInt is not defined on TypeInt (can be done by TypeString)`},
+		{`"a" | test(., "a", "q")`, `1:7-1:24: function test with given TypeString is impossible: invalid regexp: unknown flag 'q', want one of g, i, x, n, s or l`},
+		{`"" | split("") | from_entries | pick(.a + 1)`, `1:38-1:44: function pick with given TypeObject is impossible: argument isn't a path to a member`},
+		{`"::1" | in_cidr(., "::1")`, `1:9-1:26: function in_cidr with given TypeString is impossible: invalid CIDR "::1"`},
+		{`"%" | url_decode(.)`, `1:7-1:20: function url_decode with given TypeString is impossible: "%" isn't encoded properly`},
+		{"\"a\" | \n  regexp(., \"(\")", `2:3-2:17: function regexp with given TypeString is impossible: invalid regexp: error parsing regexp: missing closing ): ` + "`(`"},
+	}
+	bd := gomsg.Build()
+	zero, err := bd.Int(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			tree, err := grammar.Parse(strings.NewReader(tt.query))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = Interpreter(tree, &vm.Options{Strict: true}).Run(bd,
+				vmtest.ArraySource([]msg.Msg{zero}),
+				func(msg.Msg) error { return nil },
+			)
+			if err == nil {
+				t.Fatal("want an error")
+			}
+			if got := err.Error(); got != tt.want {
+				t.Errorf("want=%s", tt.want)
+				t.Errorf(" got=%s", got)
+			}
+		})
+	}
+}
+
+func TestPatternCache(t *testing.T) {
+	c := new(patternCache)
+	a, err := c.compile("a+", "i")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := c.compile("a+", "i"); again != a {
+		t.Error("want the same pattern when compiled again")
+	}
+	if other, _ := c.compile("a+", ""); other == a {
+		t.Error("want another pattern with other flags")
+	}
+	if _, err := c.compile("a(", ""); err == nil {
+		t.Error("want an error for an invalid pattern")
+	}
+	if _, err := c.compile("a(", ""); err == nil {
+		t.Error("want the error again for an invalid pattern")
+	}
+	for i := 0; i < maxCachedPatterns; i++ {
+		if _, err := c.compile(strconv.Itoa(i), ""); err != nil {
+			t.Fatal(err)
+		}
+		// keep using a+ so it's never the least recently used
+		if again, _ := c.compile("a+", "i"); again != a {
+			t.Fatal("want the same pattern when it's used often")
+		}
+	}
+	if len(c.entries) != maxCachedPatterns {
+		t.Errorf("want %d patterns, got %d", maxCachedPatterns, len(c.entries))
+	}
+	if _, ok := c.entries[patternKey{expr: "a+", flags: ""}]; ok {
+		t.Error("want the least recently used pattern dropped")
+	}
+	if _, ok := c.entries[patternKey{expr: strconv.Itoa(maxCachedPatterns - 1), flags: ""}]; !ok {
+		t.Error("want the most recently added pattern kept")
+	}
+}
+
 func BenchmarkInterpreter(b *testing.B) {
 	vmtest.Bench(b, gomsg.Build, Interpreter)
 }
@@ -1,6 +1,10 @@
 package vm
 
-import "github.com/aybabtme/streamql/lang/msg"
+import (
+	"time"
+
+	"github.com/aybabtme/streamql/lang/msg"
+)
 
 // A VM runs a query on a source of message and puts the result on a sink.
 // A builder of message is used when the VM needs to construct new messages
@@ -18,4 +22,7 @@ type Options struct {
 	// the VM will simply skip that message and process the next one.
 	// In strict mode, the VM will stop and return an error.
 	Strict bool
+	// Clock tells the time to the functions that need it, like `now`.
+	// By default, it's the time of the system.
+	Clock func() time.Time
 }
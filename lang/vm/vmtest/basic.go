@@ -1,9 +1,12 @@
 package vmtest
 
 import (
+	"math"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aybabtme/streamql/lang/ast"
 	"github.com/aybabtme/streamql/lang/grammar"
@@ -15,6 +18,7 @@ import (
 // Verify that a VM emits the expected messages, given input messages and a query.
 func Verify(t *testing.T, mkVM func(*ast.AST, *vm.Options) vm.VM) {
 	bd := gomsg.Build()
+	now := time.Date(2015, time.March, 5, 23, 51, 47, 0, time.UTC)
 
 	tests := []struct {
 		name   string
@@ -204,6 +208,34 @@ func Verify(t *testing.T, mkVM func(*ast.AST, *vm.Options) vm.VM) {
 				mustString(bd, "world"),
 			),
 		},
+		{"index into an object with an awkward key", true,
+			list(
+				mustObject(bd, map[string]msg.Msg{
+					"x-request-id": mustString(bd, "abc123"),
+				}),
+			),
+			[]string{
+				`."x-request-id"`,
+				`.["x-request-id"]`,
+			},
+			list(
+				mustString(bd, "abc123"),
+			),
+		},
+		{"index into an object with a unicode key", true,
+			list(
+				mustObject(bd, map[string]msg.Msg{
+					"clé": mustString(bd, "valeur"),
+				}),
+			),
+			[]string{
+				`.clé`,
+				`."clé"`,
+			},
+			list(
+				mustString(bd, "valeur"),
+			),
+		},
 		{"index into recursively into an object", true,
 			list(
 				mustObject(bd, map[string]msg.Msg{
@@ -738,6 +770,16 @@ func Verify(t *testing.T, mkVM func(*ast.AST, *vm.Options) vm.VM) {
 				mustFloat(bd, -1.2),
 			),
 		},
+		{"hexadecimal and underscored literals", true,
+			list(mustBool(bd, true)),
+			[]string{"0x10 + 1_000", "0X3F8", "1016"},
+			list(mustInt(bd, 1016)),
+		},
+		{"exponent and leading dot literals", true,
+			list(mustBool(bd, true)),
+			[]string{"1e3 + .5", "10.005e2", "1_000.5"},
+			list(mustFloat(bd, 1000.5)),
+		},
 		{"division", true,
 			list(
 				mustObject(bd, map[string]msg.Msg{"l": mustFloat(bd, 1), "r": mustFloat(bd, 2)}),
@@ -764,6 +806,32 @@ func Verify(t *testing.T, mkVM func(*ast.AST, *vm.Options) vm.VM) {
 			[]string{".l / .r"},
 			list(),
 		},
+		{"modulo", true,
+			list(
+				mustObject(bd, map[string]msg.Msg{"l": mustFloat(bd, 7.5), "r": mustFloat(bd, 2)}),
+				mustObject(bd, map[string]msg.Msg{"l": mustInt(bd, 7), "r": mustInt(bd, 2)}),
+				mustObject(bd, map[string]msg.Msg{"l": mustInt(bd, -7), "r": mustInt(bd, 2)}),
+				// int promotion to float
+				mustObject(bd, map[string]msg.Msg{"l": mustFloat(bd, 7.5), "r": mustInt(bd, 2)}),
+				mustObject(bd, map[string]msg.Msg{"l": mustInt(bd, 7), "r": mustFloat(bd, 2.5)}),
+			),
+			[]string{".l % .r"},
+			list(
+				mustFloat(bd, 1.5),
+				mustInt(bd, 1),
+				mustInt(bd, -1),
+				mustFloat(bd, 1.5),
+				mustFloat(bd, 2),
+			),
+		},
+		{"modulo by zero", false, // not strict, we want to skip the modulos by zero
+			list(
+				mustObject(bd, map[string]msg.Msg{"l": mustFloat(bd, 1), "r": mustFloat(bd, 0)}),
+				mustObject(bd, map[string]msg.Msg{"l": mustInt(bd, 1), "r": mustInt(bd, 0)}),
+			),
+			[]string{".l % .r"},
+			list(),
+		},
 		{"multiplication", true,
 			list(
 				mustObject(bd, map[string]msg.Msg{"l": mustFloat(bd, 3.5), "r": mustFloat(bd, 2)}),
@@ -960,61 +1028,1714 @@ func Verify(t *testing.T, mkVM func(*ast.AST, *vm.Options) vm.VM) {
 				mustBool(bd, true),
 			),
 		},
-	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			for _, query := range tt.query {
-				t.Logf("query=\t%s", query)
-				tree, err := grammar.Parse(strings.NewReader(query))
-				if err != nil {
-					t.Fatal(err)
-				}
-				vm := mkVM(tree, &vm.Options{
-					Strict: tt.strict,
-				})
+		{"upper and lower", true,
+			list(mustString(bd, "Héllo World")),
+			[]string{
+				`upper`,
+				`upper(.)`,
+				`lower | upper`,
+			},
+			list(mustString(bd, "HÉLLO WORLD")),
+		},
 
-				var got []msg.Msg
-				err = vm.Run(bd, ArraySource(tt.input), func(m msg.Msg) error {
-					if m == nil {
-						panic("derpo")
-					}
-					got = append(got, m)
-					return nil
-				})
-				if err != nil {
-					t.Fatal(err)
-				}
-				if want, got := tt.want, got; !reflect.DeepEqual(want, got) {
-					t.Errorf("want=%#v", want)
-					t.Errorf(" got=%#v", got)
-				}
-			}
-		})
-	}
-}
+		{"lower", true,
+			list(mustString(bd, "Héllo World")),
+			[]string{
+				`lower`,
+				`lower(upper(.))`,
+			},
+			list(mustString(bd, "héllo world")),
+		},
 
-func list(allOfThem ...msg.Msg) []msg.Msg { return allOfThem }
+		{"trim", true,
+			list(mustString(bd, " \t hello world\n ")),
+			[]string{
+				`trim`,
+				`trim(.)`,
+				`ltrim | rtrim`,
+			},
+			list(mustString(bd, "hello world")),
+		},
 
-type arraySource struct {
-	data []msg.Msg
-}
+		{"ltrim and rtrim", true,
+			list(mustString(bd, "  hello  ")),
+			[]string{
+				`ltrim + "|" + rtrim`,
+			},
+			list(mustString(bd, "hello  |  hello")),
+		},
 
-func ArraySource(data []msg.Msg) msg.Source {
-	n := len(data)
-	i := 0
-	return func() (msg.Msg, bool, error) {
-		i++
-		if i > n {
-			return nil, false, nil
-		}
-		return data[i-1], i <= n, nil
-	}
-}
+		{"ltrimstr and rtrimstr", true,
+			list(
+				mustString(bd, "service-api.log"),
+				mustString(bd, "api"),
+			),
+			[]string{
+				`ltrimstr("service-") | rtrimstr(".log")`,
+				`rtrimstr(ltrimstr(., "service-"), ".log")`,
+			},
+			list(
+				mustString(bd, "api"),
+				mustString(bd, "api"),
+			),
+		},
 
-func mustObject(bd msg.Builder, obj map[string]msg.Msg) msg.Msg {
+		{"startswith and endswith", true,
+			list(
+				mustString(bd, "GET /index.html"),
+				mustString(bd, "POST /form.html"),
+				mustString(bd, "GET /style.css"),
+			),
+			[]string{
+				`select(startswith("GET ") && endswith(., ".html"))`,
+			},
+			list(mustString(bd, "GET /index.html")),
+		},
+
+		{"split", true,
+			list(
+				mustString(bd, "a,b,,c"),
+				mustString(bd, ""),
+			),
+			[]string{
+				`split(",")`,
+				`split(., ",")`,
+			},
+			list(
+				mustArray(bd,
+					mustString(bd, "a"),
+					mustString(bd, "b"),
+					mustString(bd, ""),
+					mustString(bd, "c"),
+				),
+				mustArray(bd),
+			),
+		},
+
+		{"split on nothing", true,
+			list(mustString(bd, "héllo")),
+			[]string{`split("")`},
+			list(
+				mustArray(bd,
+					mustString(bd, "h"),
+					mustString(bd, "é"),
+					mustString(bd, "l"),
+					mustString(bd, "l"),
+					mustString(bd, "o"),
+				),
+			),
+		},
+
+		{"join", true,
+			list(
+				mustArray(bd,
+					mustString(bd, "a"),
+					mustInt(bd, 1),
+					mustFloat(bd, 2.5),
+					mustBool(bd, true),
+					mustNull(bd),
+				),
+			),
+			[]string{
+				`join("-")`,
+				`join(., "-")`,
+				`join("-") | split("-") | join("-")`,
+			},
+			list(mustString(bd, "a-1-2.5-true-")),
+		},
+
+		{"join skips arrays that hold arrays", false,
+			list(
+				mustArray(bd, mustString(bd, "a"), mustArray(bd)),
+				mustArray(bd, mustString(bd, "a"), mustString(bd, "b")),
+			),
+			[]string{`join(", ")`},
+			list(mustString(bd, "a, b")),
+		},
+
+		{"replace", true,
+			list(mustString(bd, "a.b.c")),
+			[]string{
+				`replace(".", "/")`,
+				`replace(., ".", "/")`,
+			},
+			list(mustString(bd, "a/b/c")),
+		},
+
+		{"sub", true,
+			list(mustString(bd, "2019-01-22 and 2020-02-23")),
+			[]string{
+				`sub("[0-9]+", "N")`,
+				`sub(., "[0-9]+", "N")`,
+				`sub(., "[0-9]+", "N", "")`,
+			},
+			list(mustString(bd, "N-01-22 and 2020-02-23")),
+		},
+
+		{"sub with named captures", true,
+			list(mustString(bd, "2019-01-22 and 2020-02-23")),
+			[]string{
+				`sub("(?P<y>\\d+)-(?P<m>\\d+)-(?P<d>\\d+)", "${d}/${m}/${y}")`,
+				`sub("(?<y>\\d+)-(?<m>\\d+)-(?<d>\\d+)", "$3/$2/$1")`,
+			},
+			list(mustString(bd, "22/01/2019 and 2020-02-23")),
+		},
+
+		{"gsub", true,
+			list(mustString(bd, "2019-01-22 and 2020-02-23")),
+			[]string{
+				`gsub("(?<y>\\d+)-(?<m>\\d+)-(?<d>\\d+)", "${d}/${m}/${y}")`,
+				`sub(., "(?<y>\\d+)-(?<m>\\d+)-(?<d>\\d+)", "${d}/${m}/${y}", "g")`,
+			},
+			list(mustString(bd, "22/01/2019 and 23/02/2020")),
+		},
+
+		{"sub and gsub with flags", true,
+			list(mustString(bd, "Hello hello HELLO")),
+			[]string{
+				`gsub(., "hello", "bye", "i")`,
+				`sub(., "h e l l o # the word", "bye", "gix")`,
+				`gsub(., "(?i)HELLO", "bye")`,
+			},
+			list(mustString(bd, "bye bye bye")),
+		},
+
+		{"gsub ignoring empty matches", true,
+			list(mustString(bd, "abc")),
+			[]string{
+				`gsub("x*", "-") + " " + gsub(., "x*", "-", "n")`,
+			},
+			list(mustString(bd, "-a-b-c- abc")),
+		},
+
+		{"sub with a pattern that isn't a literal", true,
+			list(
+				mustObject(bd, map[string]msg.Msg{
+					"s":       mustString(bd, "aaaaa"),
+					"pattern": mustString(bd, "A+"),
+					"flags":   mustString(bd, "i"),
+				}),
+			),
+			[]string{
+				`sub(.s, .pattern, "b", .flags)`,
+			},
+			list(mustString(bd, "b")),
+		},
+
+		{"gsub skips invalid patterns and flags", false,
+			list(
+				mustObject(bd, map[string]msg.Msg{"pattern": mustString(bd, "a("), "flags": mustString(bd, "")}),
+				mustObject(bd, map[string]msg.Msg{"pattern": mustString(bd, "a"), "flags": mustString(bd, "q")}),
+				mustObject(bd, map[string]msg.Msg{"pattern": mustString(bd, "a"), "flags": mustString(bd, "")}),
+			),
+			[]string{
+				`gsub("aaa", .pattern, "b", .flags)`,
+			},
+			list(mustString(bd, "bbb")),
+		},
+
+		{"test", true,
+			list(
+				mustString(bd, "GET /index.html"),
+				mustString(bd, "get /"),
+				mustString(bd, "POST /"),
+			),
+			[]string{
+				`select(test("^GET "))`,
+				`select(test(., "^GET "))`,
+				`select(test(., "^GET [a-z/.]+$", ""))`,
+			},
+			list(mustString(bd, "GET /index.html")),
+		},
+
+		{"test with flags", true,
+			list(
+				mustString(bd, "GET /index.html"),
+				mustString(bd, "get /"),
+				mustString(bd, "POST /"),
+			),
+			[]string{
+				`select(test(., "^get ", "i"))`,
+				`select(test(., "^ g e t \\  # the method", "xi"))`,
+			},
+			list(
+				mustString(bd, "GET /index.html"),
+				mustString(bd, "get /"),
+			),
+		},
+
+		{"match", true,
+			list(mustString(bd, "éa ab b")),
+			[]string{
+				`match("a(b)?") | .string + " at " + .offset + ", " + .length + " long"`,
+				`match(., "a(b)?", "") | .string + " at " + .offset + ", " + .length + " long"`,
+			},
+			list(mustString(bd, "a at 2, 1 long")),
+		},
+
+		{"match captures", true,
+			list(mustString(bd, "éa ab b")),
+			[]string{
+				`match("(?<a>a)(b)?") | .captures[0].name + " " + .captures[0].string + " at " + .captures[0].offset`,
+				`match(., "(?<a>a)(b)?", "") | .captures[0] | .name + " " + .string + " at " + .offset`,
+			},
+			list(mustString(bd, "a a at 2")),
+		},
+
+		{"match captures that matched nothing", true,
+			list(mustString(bd, "éa ab b")),
+			[]string{
+				`match("(?<a>a)(b)?") | .captures[1].name`,
+				`match("(?<a>a)(b)?") | .captures[1].string`,
+			},
+			list(mustNull(bd)),
+		},
+
+		{"match every occurrence", true,
+			list(mustString(bd, "éa ab b")),
+			[]string{
+				`match(., "a(b)?", "g") | .string + " at " + .offset + ", " + .captures[0].offset`,
+			},
+			list(
+				mustString(bd, "a at 2, -1"),
+				mustString(bd, "ab at 4, 5"),
+			),
+		},
+
+		{"match empty matches", true,
+			list(mustString(bd, "ab")),
+			[]string{
+				`match(., "b*", "g") | .offset`,
+			},
+			list(mustInt(bd, 0), mustInt(bd, 1)),
+		},
+
+		{"match ignoring empty matches", true,
+			list(mustString(bd, "ab")),
+			[]string{
+				`match(., "b*", "gn") | .offset`,
+				`match(., "b*", "n") | .offset`,
+			},
+			list(mustInt(bd, 1)),
+		},
+
+		{"capture", true,
+			list(
+				mustString(bd, "200 /index.html"),
+				mustString(bd, "nope"),
+				mustString(bd, "404 /missing"),
+			),
+			[]string{
+				`capture("(?<status>\\d{3}) (?<path>\\S+)") | .status + " " + .path`,
+				`capture(., "(?<status>\\d{3}) (?<path>\\S+)", "g") | .status + " " + .path`,
+			},
+			list(
+				mustString(bd, "200 /index.html"),
+				mustString(bd, "404 /missing"),
+			),
+		},
+
+		{"capture every occurrence", true,
+			list(mustString(bd, "a=1 b=2 c")),
+			[]string{
+				`capture(., "(?<key>\\w+)(=(?<value>\\d+))?", "g") | .value`,
+			},
+			list(
+				mustString(bd, "1"),
+				mustString(bd, "2"),
+				mustNull(bd),
+			),
+		},
+
+		{"scan", true,
+			list(mustString(bd, "a1 b22 c")),
+			[]string{
+				`scan("[a-z]\\d*")`,
+				`scan(., "[A-Z]\\d*", "i")`,
+			},
+			list(
+				mustString(bd, "a1"),
+				mustString(bd, "b22"),
+				mustString(bd, "c"),
+			),
+		},
+
+		{"scan with submatches", true,
+			list(mustString(bd, "a1 b22 c")),
+			[]string{
+				`scan("([a-z])(\\d+)?") | join(., "=")`,
+			},
+			list(
+				mustString(bd, "a=1"),
+				mustString(bd, "b=22"),
+				mustString(bd, "c="),
+			),
+		},
+
+		{"splits", true,
+			list(mustString(bd, "a, b,c ,,d")),
+			[]string{
+				`splits(" *, *")`,
+				`splits(., " *, *")`,
+			},
+			list(
+				mustString(bd, "a"),
+				mustString(bd, "b"),
+				mustString(bd, "c"),
+				mustString(bd, ""),
+				mustString(bd, "d"),
+			),
+		},
+
+		{"floor, ceil and round", true,
+			list(
+				mustFloat(bd, 2.5),
+				mustFloat(bd, -2.5),
+				mustInt(bd, 3),
+				mustFloat(bd, math.Inf(1)),
+			),
+			[]string{
+				`floor + "," + ceil + "," + round`,
+				`floor(.) + "," + ceil(.) + "," + round(.)`,
+			},
+			list(
+				mustString(bd, "2,3,3"),
+				mustString(bd, "-3,-2,-3"),
+				mustString(bd, "3,3,3"),
+				mustString(bd, "+Inf,+Inf,+Inf"),
+			),
+		},
+
+		{"floor keeps the type of numbers", true,
+			list(mustFloat(bd, 2.5), mustInt(bd, 3)),
+			[]string{`floor`},
+			list(mustFloat(bd, 2), mustInt(bd, 3)),
+		},
+
+		{"abs", true,
+			list(
+				mustInt(bd, -3),
+				mustInt(bd, 3),
+				mustFloat(bd, -2.5),
+				mustFloat(bd, math.Inf(-1)),
+			),
+			[]string{`abs`, `abs(.)`},
+			list(
+				mustInt(bd, 3),
+				mustInt(bd, 3),
+				mustFloat(bd, 2.5),
+				mustFloat(bd, math.Inf(1)),
+			),
+		},
+
+		{"sqrt", true,
+			list(mustInt(bd, 4), mustFloat(bd, 2.25)),
+			[]string{
+				`sqrt`,
+				`pow(sqrt, 2) | sqrt(.)`,
+			},
+			list(mustFloat(bd, 2), mustFloat(bd, 1.5)),
+		},
+
+		{"log and exp", true,
+			list(mustInt(bd, 1), mustFloat(bd, 0)),
+			[]string{
+				`exp + "," + log(exp)`,
+				`exp(.) + "," + log(exp(.))`,
+			},
+			list(
+				mustString(bd, "2.718281828459045,1"),
+				mustString(bd, "1,0"),
+			),
+		},
+
+		{"math skips results that are not finite numbers", false,
+			list(
+				mustInt(bd, -1),
+				mustInt(bd, 0),
+				mustInt(bd, 1000),
+				mustInt(bd, 1),
+			),
+			[]string{
+				`sqrt + log + exp`,
+				`sqrt(.) + log(.) + exp(.)`,
+			},
+			list(mustFloat(bd, 1+math.E)),
+		},
+
+		{"math lets NaN and infinities through", true,
+			list(mustFloat(bd, math.Inf(1))),
+			[]string{
+				`sqrt`,
+				`log`,
+				`exp`,
+				`pow(., 2)`,
+				`pow(2, .)`,
+			},
+			list(mustFloat(bd, math.Inf(1))),
+		},
+
+		{"pow", true,
+			list(mustInt(bd, 2), mustFloat(bd, 2)),
+			[]string{
+				`pow(10)`,
+				`pow(., 10)`,
+			},
+			list(mustInt(bd, 1024), mustFloat(bd, 1024)),
+		},
+
+		{"pow of ints", true,
+			list(mustInt(bd, 0), mustInt(bd, 1), mustInt(bd, -1)),
+			[]string{
+				`pow(., 3) + "," + pow(., 0) + "," + pow(-1, .) + "," + pow(., 1000000000000)`,
+			},
+			list(
+				mustString(bd, "0,1,1,0"),
+				mustString(bd, "1,1,-1,1"),
+				mustString(bd, "-1,1,-1,1"),
+			),
+		},
+
+		{"pow skips overflows", false,
+			list(mustInt(bd, 63), mustInt(bd, 62)),
+			[]string{`pow(2, .)`},
+			list(mustInt(bd, 1<<62)),
+		},
+
+		{"pow with negative or float exponents skips divisions by zero", false,
+			list(mustInt(bd, 2), mustInt(bd, 0), mustInt(bd, -8)),
+			[]string{
+				`pow(., -1)`,
+				`pow(., -1.0)`,
+			},
+			list(mustFloat(bd, 0.5), mustFloat(bd, -0.125)),
+		},
+
+		{"min", true,
+			list(
+				mustArray(bd, mustInt(bd, 3), mustFloat(bd, 1.5), mustInt(bd, 2)),
+				mustArray(bd),
+			),
+			[]string{`min`, `min(.)`},
+			list(mustFloat(bd, 1.5), mustNull(bd)),
+		},
+
+		{"max", true,
+			list(
+				mustArray(bd, mustInt(bd, 3), mustFloat(bd, math.NaN()), mustInt(bd, 2)),
+				mustArray(bd),
+			),
+			[]string{`max`, `max(.)`},
+			list(mustInt(bd, 3), mustNull(bd)),
+		},
+
+		{"min and max skip arrays of other things than numbers", false,
+			list(
+				mustArray(bd, mustInt(bd, 3), mustString(bd, "1")),
+				mustArray(bd, mustInt(bd, 3), mustInt(bd, 1)),
+			),
+			[]string{`min`},
+			list(mustInt(bd, 1)),
+		},
+
+		{"clamp", true,
+			list(
+				mustInt(bd, -5),
+				mustInt(bd, 5),
+				mustInt(bd, 50),
+				mustFloat(bd, 10.5),
+				mustFloat(bd, math.NaN()),
+			),
+			[]string{
+				`clamp(0, 10)`,
+				`clamp(., 0, 10)`,
+			},
+			list(
+				mustInt(bd, 0),
+				mustInt(bd, 5),
+				mustInt(bd, 10),
+				mustInt(bd, 10),
+				mustInt(bd, 0),
+			),
+		},
+
+		{"clamp skips empty ranges", false,
+			list(mustInt(bd, 5)),
+			[]string{`clamp(10, 0) + clamp(0, 10)`},
+			nil,
+		},
+
+		{"min_by and max_by", true,
+			list(
+				mustArray(bd,
+					mustObject(bd, map[string]msg.Msg{"name": mustString(bd, "a"), "size": mustInt(bd, 3)}),
+					mustObject(bd, map[string]msg.Msg{"name": mustString(bd, "b"), "size": mustFloat(bd, 1.5)}),
+					mustObject(bd, map[string]msg.Msg{"name": mustString(bd, "c"), "size": mustInt(bd, 10)}),
+				),
+			),
+			[]string{
+				`min_by(.size) | .name`,
+				`min_by(., .size * 1) | .name`,
+				`max_by(., -1 * .size) | .name`,
+			},
+			list(mustString(bd, "b")),
+		},
+
+		{"min_by and max_by of any type, min_by", true,
+			list(
+				mustArray(bd,
+					mustObject(bd, map[string]msg.Msg{"name": mustString(bd, "bob"), "id": mustInt(bd, 1)}),
+					mustObject(bd, map[string]msg.Msg{"name": mustString(bd, "alice"), "id": mustInt(bd, 2)}),
+					mustObject(bd, map[string]msg.Msg{"name": mustNull(bd), "id": mustInt(bd, 3)}),
+					mustObject(bd, map[string]msg.Msg{"name": mustString(bd, "carol"), "id": mustInt(bd, 4)}),
+				),
+			),
+			[]string{`min_by(.name) | .id`, `sort_by(.name) | .[0].id`},
+			list(mustInt(bd, 3)),
+		},
+
+		{"min_by and max_by of any type, max_by", true,
+			list(
+				mustArray(bd,
+					mustObject(bd, map[string]msg.Msg{"name": mustString(bd, "bob"), "id": mustInt(bd, 1)}),
+					mustObject(bd, map[string]msg.Msg{"name": mustString(bd, "alice"), "id": mustInt(bd, 2)}),
+					mustObject(bd, map[string]msg.Msg{"name": mustNull(bd), "id": mustInt(bd, 3)}),
+					mustObject(bd, map[string]msg.Msg{"name": mustString(bd, "carol"), "id": mustInt(bd, 4)}),
+				),
+			),
+			[]string{`max_by(.name) | .id`, `sort_by(.name) | .[length - 1].id`},
+			list(mustInt(bd, 4)),
+		},
+
+		{"min_by with keys that emit nothing", true,
+			list(keyless(bd)),
+			[]string{`min_by(.x) | .n`, `min_by(., .x) | .n`},
+			list(mustString(bd, "b")),
+		},
+
+		{"max_by with keys that emit nothing", true,
+			list(keyless(bd)),
+			[]string{`max_by(.x) | .n`, `max_by(., .x) | .n`},
+			list(mustString(bd, "a")),
+		},
+
+		{"min_by and max_by of nothing", true,
+			list(mustArray(bd)),
+			[]string{`min_by(.size)`, `max_by(.size)`},
+			list(mustNull(bd)),
+		},
+
+		{"sort", true,
+			list(
+				mustArray(bd,
+					mustString(bd, "b"), mustInt(bd, 2), mustNull(bd), mustFloat(bd, 1.5),
+					mustBool(bd, true), mustString(bd, "a"), mustBool(bd, false),
+				),
+			),
+			[]string{`sort`, `sort(.)`, `reverse(sort) | reverse`},
+			list(
+				mustArray(bd,
+					mustNull(bd), mustBool(bd, false), mustBool(bd, true), mustFloat(bd, 1.5),
+					mustInt(bd, 2), mustString(bd, "a"), mustString(bd, "b"),
+				),
+			),
+		},
+
+		{"sort arrays and objects", true,
+			list(
+				mustObject(bd, map[string]msg.Msg{
+					"a": mustArray(bd,
+						mustObject(bd, map[string]msg.Msg{"b": mustInt(bd, 1)}),
+						mustArray(bd, mustInt(bd, 1), mustInt(bd, 2)),
+						mustObject(bd, map[string]msg.Msg{"a": mustInt(bd, 2)}),
+						mustArray(bd, mustInt(bd, 1)),
+						mustObject(bd, map[string]msg.Msg{"a": mustInt(bd, 1)}),
+					),
+				}),
+			),
+			[]string{`sort(.a) | .[]`},
+			list(
+				mustArray(bd, mustInt(bd, 1)),
+				mustArray(bd, mustInt(bd, 1), mustInt(bd, 2)),
+				mustObject(bd, map[string]msg.Msg{"a": mustInt(bd, 1)}),
+				mustObject(bd, map[string]msg.Msg{"a": mustInt(bd, 2)}),
+				mustObject(bd, map[string]msg.Msg{"b": mustInt(bd, 1)}),
+			),
+		},
+
+		{"sort_by, group_by and unique_by", true,
+			list(
+				mustArray(bd,
+					mustObject(bd, map[string]msg.Msg{"name": mustString(bd, "a"), "size": mustInt(bd, 3)}),
+					mustObject(bd, map[string]msg.Msg{"name": mustString(bd, "b"), "size": mustInt(bd, 1)}),
+					mustObject(bd, map[string]msg.Msg{"name": mustString(bd, "c"), "size": mustInt(bd, 3)}),
+					mustObject(bd, map[string]msg.Msg{"name": mustString(bd, "d"), "size": mustFloat(bd, 1)}),
+				),
+			),
+			[]string{
+				`sort_by(.size) | join(map(.name), ",") + " " + join(map(., .size), ",")`,
+				`sort_by(., .size) | join(map(.name), ",") + " " + join(map(., .size), ",")`,
+			},
+			list(mustString(bd, "b,d,a,c 1,1,3,3")),
+		},
+
+		{"sort_by with keys that emit nothing", true,
+			list(keyless(bd)),
+			[]string{`join(map(sort_by(.x), .n), ",")`, `join(map(sort_by(., .x), .n), ",")`},
+			list(mustString(bd, "b,d,c,a")),
+		},
+
+		{"group_by and unique_by with keys that emit nothing", true,
+			list(keyless(bd)),
+			[]string{`join(map(group_by(.x), .[0].n), ",")`, `join(map(unique_by(.x), .n), ",")`},
+			list(mustString(bd, "b,c,a")),
+		},
+
+		{"group_by", true,
+			list(
+				mustArray(bd,
+					mustObject(bd, map[string]msg.Msg{"name": mustString(bd, "a"), "size": mustInt(bd, 3)}),
+					mustObject(bd, map[string]msg.Msg{"name": mustString(bd, "b"), "size": mustInt(bd, 1)}),
+					mustObject(bd, map[string]msg.Msg{"name": mustString(bd, "c"), "size": mustInt(bd, 3)}),
+				),
+			),
+			[]string{`group_by(.size) | .[]`, `group_by(., .size) | .[]`},
+			list(
+				mustArray(bd,
+					mustObject(bd, map[string]msg.Msg{"name": mustString(bd, "b"), "size": mustInt(bd, 1)}),
+				),
+				mustArray(bd,
+					mustObject(bd, map[string]msg.Msg{"name": mustString(bd, "a"), "size": mustInt(bd, 3)}),
+					mustObject(bd, map[string]msg.Msg{"name": mustString(bd, "c"), "size": mustInt(bd, 3)}),
+				),
+			),
+		},
+
+		{"unique_by", true,
+			list(
+				mustArray(bd,
+					mustObject(bd, map[string]msg.Msg{"name": mustString(bd, "a"), "size": mustInt(bd, 3)}),
+					mustObject(bd, map[string]msg.Msg{"name": mustString(bd, "b"), "size": mustInt(bd, 1)}),
+					mustObject(bd, map[string]msg.Msg{"name": mustString(bd, "c"), "size": mustInt(bd, 3)}),
+				),
+			),
+			[]string{`unique_by(.size) | join(map(.name), ",")`, `unique_by(., .size) | join(map(.name), ",")`},
+			list(mustString(bd, "b,a")),
+		},
+
+		{"unique", true,
+			list(mustArray(bd, mustInt(bd, 2), mustString(bd, "a"), mustInt(bd, 1), mustFloat(bd, 2), mustString(bd, "a"))),
+			[]string{`unique`, `unique(.)`},
+			list(mustArray(bd, mustInt(bd, 1), mustInt(bd, 2), mustString(bd, "a"))),
+		},
+
+		{"reverse", true,
+			list(mustArray(bd, mustInt(bd, 1), mustString(bd, "a"), mustNull(bd))),
+			[]string{`reverse`, `reverse(.)`},
+			list(mustArray(bd, mustNull(bd), mustString(bd, "a"), mustInt(bd, 1))),
+		},
+
+		{"reverse strings", true,
+			list(mustString(bd, "héllo")),
+			[]string{`reverse`, `reverse(.)`},
+			list(mustString(bd, "olléh")),
+		},
+
+		{"flatten", true,
+			list(
+				mustArray(bd,
+					mustInt(bd, 1),
+					mustArray(bd, mustInt(bd, 2), mustArray(bd, mustInt(bd, 3), mustArray(bd, mustInt(bd, 4)))),
+					mustArray(bd),
+				),
+			),
+			[]string{`flatten`, `flatten(.)`, `flatten(., 3)`},
+			list(mustArray(bd, mustInt(bd, 1), mustInt(bd, 2), mustInt(bd, 3), mustInt(bd, 4))),
+		},
+
+		{"flatten to a depth", true,
+			list(
+				mustArray(bd,
+					mustInt(bd, 1),
+					mustArray(bd, mustInt(bd, 2), mustArray(bd, mustInt(bd, 3))),
+				),
+			),
+			[]string{`flatten(., 1)`},
+			list(mustArray(bd, mustInt(bd, 1), mustInt(bd, 2), mustArray(bd, mustInt(bd, 3)))),
+		},
+
+		{"flatten skips negative depths", false,
+			list(mustArray(bd, mustArray(bd, mustInt(bd, 1))), mustArray(bd)),
+			[]string{`flatten(., 0 - length)`},
+			list(mustArray(bd)),
+		},
+
+		{"add", true,
+			list(
+				mustArray(bd, mustInt(bd, 1), mustNull(bd), mustInt(bd, 2)),
+				mustArray(bd, mustInt(bd, 1), mustFloat(bd, 0.5)),
+				mustArray(bd, mustString(bd, "a"), mustString(bd, "b")),
+				mustArray(bd, mustNull(bd)),
+				mustArray(bd),
+			),
+			[]string{`add`, `add(.)`},
+			list(mustInt(bd, 3), mustFloat(bd, 1.5), mustString(bd, "ab"), mustNull(bd), mustNull(bd)),
+		},
+
+		{"add skips what can't be added", false,
+			list(
+				mustArray(bd, mustInt(bd, 1), mustBool(bd, true)),
+				mustArray(bd, mustInt(bd, 1), mustInt(bd, 2)),
+			),
+			[]string{`add`},
+			list(mustInt(bd, 3)),
+		},
+
+		{"any", true,
+			list(
+				mustArray(bd, mustInt(bd, 1), mustInt(bd, 5)),
+				mustArray(bd, mustInt(bd, 1), mustInt(bd, 2)),
+				mustArray(bd),
+			),
+			[]string{`any(. > 3)`, `any(.[], . > 3)`},
+			list(mustBool(bd, true), mustBool(bd, false), mustBool(bd, false)),
+		},
+
+		{"all", true,
+			list(
+				mustArray(bd, mustInt(bd, 1), mustInt(bd, 5)),
+				mustArray(bd, mustInt(bd, 5), mustInt(bd, 6)),
+				mustArray(bd),
+			),
+			[]string{`all(. > 3)`, `all(.[], . > 3)`},
+			list(mustBool(bd, false), mustBool(bd, true), mustBool(bd, true)),
+		},
+
+		{"any and all of booleans", true,
+			list(mustArray(bd, mustBool(bd, true), mustBool(bd, false))),
+			[]string{`any(.)`, `any(.[], .)`, `all(.) == false`, `all(.[], .) == false`},
+			list(mustBool(bd, true)),
+		},
+
+		{"any and all stop early", true,
+			list(mustArray(bd, mustInt(bd, 1), mustInt(bd, 5), mustString(bd, "a"))),
+			[]string{`any(.[], . > 3)`, `all(.[], . < 3) == false`, `any(. > 3)`},
+			list(mustBool(bd, true)),
+		},
+
+		{"map", true,
+			list(mustArray(bd, mustInt(bd, 1), mustInt(bd, 2)), mustArray(bd)),
+			[]string{`map(. * 10)`, `map(., . * 10)`},
+			list(mustArray(bd, mustInt(bd, 10), mustInt(bd, 20)), mustArray(bd)),
+		},
+
+		{"map keeps all that's emitted", true,
+			list(mustArray(bd, mustArray(bd, mustInt(bd, 1), mustInt(bd, 2)), mustArray(bd, mustInt(bd, 3)))),
+			[]string{`map(.[])`, `flatten`},
+			list(mustArray(bd, mustInt(bd, 1), mustInt(bd, 2), mustInt(bd, 3))),
+		},
+
+		{"index", true,
+			list(
+				mustString(bd, "hello"),
+				mustArray(bd, mustString(bd, "a"), mustString(bd, "ll"), mustString(bd, "ll")),
+			),
+			[]string{`index("ll")`, `index(., "ll")`},
+			list(mustInt(bd, 2), mustInt(bd, 1)),
+		},
+
+		{"index of what isn't there", true,
+			list(mustString(bd, "hello"), mustArray(bd, mustInt(bd, 1))),
+			[]string{`index("x")`},
+			list(mustNull(bd), mustNull(bd)),
+		},
+
+		{"inside", true,
+			list(
+				mustObject(bd, map[string]msg.Msg{
+					"a": mustObject(bd, map[string]msg.Msg{
+						"b": mustArray(bd, mustString(bd, "ell"), mustInt(bd, 2)),
+					}),
+					"b": mustObject(bd, map[string]msg.Msg{
+						"b": mustArray(bd, mustInt(bd, 1), mustInt(bd, 2), mustString(bd, "hello")),
+						"c": mustNull(bd),
+					}),
+				}),
+			),
+			[]string{
+				`inside(.a, .b)`,
+				`inside(.b, .a) == false`,
+				`.a.b[0] | inside("hello")`,
+				`inside(.b.c, .a) == false`,
+			},
+			list(mustBool(bd, true)),
+		},
+
+		{"ordering of arrays, objects and booleans", true,
+			list(
+				mustObject(bd, map[string]msg.Msg{
+					"l": mustArray(bd, mustInt(bd, 1), mustInt(bd, 2)),
+					"r": mustArray(bd, mustInt(bd, 1), mustFloat(bd, 3)),
+				}),
+				mustObject(bd, map[string]msg.Msg{
+					"l": mustArray(bd, mustInt(bd, 1)),
+					"r": mustArray(bd, mustInt(bd, 1), mustInt(bd, 0)),
+				}),
+				mustObject(bd, map[string]msg.Msg{
+					"l": mustObject(bd, map[string]msg.Msg{"a": mustInt(bd, 9)}),
+					"r": mustObject(bd, map[string]msg.Msg{"b": mustInt(bd, 1)}),
+				}),
+				mustObject(bd, map[string]msg.Msg{
+					"l": mustObject(bd, map[string]msg.Msg{"a": mustInt(bd, 1), "b": mustInt(bd, 2)}),
+					"r": mustObject(bd, map[string]msg.Msg{"a": mustInt(bd, 2), "b": mustInt(bd, 1)}),
+				}),
+				mustObject(bd, map[string]msg.Msg{"l": mustBool(bd, false), "r": mustBool(bd, true)}),
+			),
+			[]string{".l < .r", ".l <= .r", ".r > .l", ".r >= .l", ".l != .r"},
+			list(mustBool(bd, true), mustBool(bd, true), mustBool(bd, true), mustBool(bd, true), mustBool(bd, true)),
+		},
+
+		{"equality of arrays with ints and floats", true,
+			list(
+				mustObject(bd, map[string]msg.Msg{
+					"l": mustArray(bd, mustInt(bd, 1), mustObject(bd, map[string]msg.Msg{"a": mustFloat(bd, 2)})),
+					"r": mustArray(bd, mustFloat(bd, 1), mustObject(bd, map[string]msg.Msg{"a": mustInt(bd, 2)})),
+				}),
+			),
+			[]string{".l == .r", ".l <= .r", ".l >= .r"},
+			list(mustBool(bd, true)),
+		},
+
+		{"NaN is equal to itself", true,
+			list(mustFloat(bd, math.NaN())),
+			[]string{". == .", ". <= .", ". < 0"},
+			list(mustBool(bd, true)),
+		},
+
+		{"ordering of messages of different types", true,
+			list(
+				mustObject(bd, map[string]msg.Msg{"l": mustArray(bd), "r": mustObject(bd, map[string]msg.Msg{})}),
+				mustObject(bd, map[string]msg.Msg{"l": mustString(bd, "a"), "r": mustInt(bd, 1)}),
+				mustObject(bd, map[string]msg.Msg{"l": mustInt(bd, 1), "r": mustFloat(bd, 1.5)}),
+				mustObject(bd, map[string]msg.Msg{"l": mustNull(bd), "r": mustBool(bd, false)}),
+				mustObject(bd, map[string]msg.Msg{"l": mustBool(bd, true), "r": mustInt(bd, -1)}),
+			),
+			[]string{".l < .r", ".r > .l", "!(.l >= .r)"},
+			list(mustBool(bd, true), mustBool(bd, false), mustBool(bd, true), mustBool(bd, true), mustBool(bd, true)),
+		},
+
+		{"equality with null", true,
+			list(
+				mustObject(bd, map[string]msg.Msg{"n": mustNull(bd), "i": mustInt(bd, 1)}),
+			),
+			[]string{`.n == .n`, `.n != .i`, `.i != null`, `null == null`},
+			list(mustBool(bd, true)),
+		},
+
+		{"keys_unsorted", true,
+			list(
+				mustOrderedObject(bd, []string{"b", "a"}, mustInt(bd, 1), mustInt(bd, 2)),
+				mustArray(bd, mustString(bd, "x"), mustString(bd, "y")),
+			),
+			[]string{`keys_unsorted`, `keys_unsorted(.)`},
+			list(
+				mustArray(bd, mustString(bd, "b"), mustString(bd, "a")),
+				mustArray(bd, mustInt(bd, 0), mustInt(bd, 1)),
+			),
+		},
+
+		{"keys are sorted", true,
+			list(mustOrderedObject(bd, []string{"b", "a"}, mustInt(bd, 1), mustInt(bd, 2))),
+			[]string{`keys`},
+			list(mustArray(bd, mustString(bd, "a"), mustString(bd, "b"))),
+		},
+
+		{"to_entries", true,
+			list(mustOrderedObject(bd, []string{"b", "a"}, mustInt(bd, 1), mustString(bd, "x"))),
+			[]string{`to_entries | .[] | .key + "=" + .value`, `to_entries(.) | .[] | .key + "=" + .value`},
+			list(mustString(bd, "b=1"), mustString(bd, "a=x")),
+		},
+
+		{"from_entries", true,
+			list(
+				mustObject(bd, map[string]msg.Msg{
+					"entries": mustArray(bd,
+						mustObject(bd, map[string]msg.Msg{"key": mustString(bd, "b"), "value": mustInt(bd, 1)}),
+						mustObject(bd, map[string]msg.Msg{"k": mustString(bd, "a"), "v": mustInt(bd, 2)}),
+						mustObject(bd, map[string]msg.Msg{"name": mustInt(bd, 3)}),
+						mustObject(bd, map[string]msg.Msg{"key": mustBool(bd, true), "value": mustInt(bd, 4)}),
+						mustObject(bd, map[string]msg.Msg{"key": mustString(bd, "b"), "value": mustInt(bd, 5)}),
+					),
+				}),
+			),
+			[]string{`from_entries(.entries) | keys_unsorted`, `.entries | from_entries | keys_unsorted`},
+			list(mustArray(bd, mustString(bd, "b"), mustString(bd, "a"), mustString(bd, "3"), mustString(bd, "true"))),
+		},
+
+		{"from_entries keeps the last value", true,
+			list(
+				mustArray(bd,
+					mustObject(bd, map[string]msg.Msg{"key": mustString(bd, "a"), "value": mustInt(bd, 1)}),
+					mustObject(bd, map[string]msg.Msg{"name": mustString(bd, "b")}),
+					mustObject(bd, map[string]msg.Msg{"key": mustString(bd, "a"), "value": mustInt(bd, 2)}),
+				),
+			),
+			[]string{`from_entries`, `to_entries(from_entries) | from_entries`},
+			list(mustObject(bd, map[string]msg.Msg{"a": mustInt(bd, 2), "b": mustNull(bd)})),
+		},
+
+		{"from_entries skips what aren't entries", false,
+			list(
+				mustArray(bd, mustInt(bd, 1)),
+				mustArray(bd, mustObject(bd, map[string]msg.Msg{"value": mustInt(bd, 1)})),
+				mustArray(bd, mustObject(bd, map[string]msg.Msg{"key": mustNull(bd)})),
+				mustArray(bd, mustObject(bd, map[string]msg.Msg{"key": mustString(bd, "a")})),
+			),
+			[]string{`from_entries`},
+			list(mustObject(bd, map[string]msg.Msg{"a": mustNull(bd)})),
+		},
+
+		{"with_entries", true,
+			list(
+				mustObject(bd, map[string]msg.Msg{
+					"o": mustOrderedObject(bd, []string{"c", "a", "b"}, mustInt(bd, 3), mustInt(bd, 1), mustInt(bd, 2)),
+				}),
+			),
+			[]string{
+				`with_entries(.o, select(.value > 1)) | keys_unsorted`,
+				`.o | with_entries(select(.key != "a")) | keys_unsorted`,
+			},
+			list(mustArray(bd, mustString(bd, "c"), mustString(bd, "b"))),
+		},
+
+		{"merge objects", true,
+			list(
+				mustObject(bd, map[string]msg.Msg{
+					"l": mustOrderedObject(bd, []string{"b", "a"},
+						mustObject(bd, map[string]msg.Msg{"x": mustInt(bd, 1), "y": mustInt(bd, 1)}),
+						mustInt(bd, 1),
+					),
+					"r": mustOrderedObject(bd, []string{"c", "b"},
+						mustInt(bd, 3),
+						mustObject(bd, map[string]msg.Msg{"y": mustInt(bd, 2), "z": mustInt(bd, 2)}),
+					),
+					"shallow": mustObject(bd, map[string]msg.Msg{
+						"a": mustInt(bd, 1),
+						"b": mustObject(bd, map[string]msg.Msg{"y": mustInt(bd, 2), "z": mustInt(bd, 2)}),
+						"c": mustInt(bd, 3),
+					}),
+					"deep": mustObject(bd, map[string]msg.Msg{
+						"a": mustInt(bd, 1),
+						"b": mustObject(bd, map[string]msg.Msg{"x": mustInt(bd, 1), "y": mustInt(bd, 2), "z": mustInt(bd, 2)}),
+						"c": mustInt(bd, 3),
+					}),
+				}),
+			),
+			[]string{
+				`.l + .r == .shallow`,
+				`.l * .r == .deep`,
+				`.l * .r | join(keys_unsorted(.b), "") == "xyz"`,
+				`.l + .r | join(keys_unsorted, "") == "bac"`,
+				`.l * .l == .l`,
+			},
+			list(mustBool(bd, true)),
+		},
+
+		{"add merges objects", true,
+			list(
+				mustArray(bd,
+					mustObject(bd, map[string]msg.Msg{"a": mustInt(bd, 1)}),
+					mustNull(bd),
+					mustObject(bd, map[string]msg.Msg{"a": mustInt(bd, 2), "b": mustInt(bd, 3)}),
+				),
+			),
+			[]string{`add`},
+			list(mustObject(bd, map[string]msg.Msg{"a": mustInt(bd, 2), "b": mustInt(bd, 3)})),
+		},
+
+		{"merge skips what aren't objects", false,
+			list(
+				mustObject(bd, map[string]msg.Msg{"l": mustObject(bd, map[string]msg.Msg{}), "r": mustInt(bd, 1)}),
+				mustObject(bd, map[string]msg.Msg{"l": mustString(bd, "a"), "r": mustObject(bd, map[string]msg.Msg{})}),
+				mustObject(bd, map[string]msg.Msg{"l": mustObject(bd, map[string]msg.Msg{}), "r": mustObject(bd, map[string]msg.Msg{})}),
+			),
+			[]string{`.l + .r | length`, `.l * .r | length`},
+			list(mustInt(bd, 0)),
+		},
+
+		{"pick", true,
+			list(
+				mustObject(bd, map[string]msg.Msg{
+					"a": mustInt(bd, 1),
+					"b": mustObject(bd, map[string]msg.Msg{"c": mustInt(bd, 2), "d": mustInt(bd, 3)}),
+					"e": mustInt(bd, 4),
+				}),
+			),
+			[]string{`pick(.a, .b.c, .x.y)`, `pick(.a, .b."c", .x.y, .b.c)`},
+			list(
+				mustObject(bd, map[string]msg.Msg{
+					"a": mustInt(bd, 1),
+					"b": mustObject(bd, map[string]msg.Msg{"c": mustInt(bd, 2)}),
+					"x": mustObject(bd, map[string]msg.Msg{"y": mustNull(bd)}),
+				}),
+			),
+		},
+
+		{"pick all of a member", true,
+			list(
+				mustObject(bd, map[string]msg.Msg{
+					"b": mustObject(bd, map[string]msg.Msg{"c": mustInt(bd, 2), "d": mustInt(bd, 3)}),
+					"e": mustInt(bd, 4),
+				}),
+			),
+			[]string{`pick(.b)`, `pick(.b.c, .b)`, `pick(.b, .b.c)`},
+			list(
+				mustObject(bd, map[string]msg.Msg{
+					"b": mustObject(bd, map[string]msg.Msg{"c": mustInt(bd, 2), "d": mustInt(bd, 3)}),
+				}),
+			),
+		},
+
+		{"pick skips what it can't index", false,
+			list(
+				mustObject(bd, map[string]msg.Msg{"a": mustInt(bd, 1)}),
+				mustObject(bd, map[string]msg.Msg{"a": mustObject(bd, map[string]msg.Msg{})}),
+			),
+			[]string{`pick(.a.b)`},
+			list(mustObject(bd, map[string]msg.Msg{"a": mustObject(bd, map[string]msg.Msg{"b": mustNull(bd)})})),
+		},
+
+		{"type", true,
+			list(
+				mustObject(bd, map[string]msg.Msg{}),
+				mustArray(bd),
+				mustString(bd, "a"),
+				mustInt(bd, 1),
+				mustFloat(bd, 1),
+				mustBool(bd, false),
+				mustNull(bd),
+			),
+			[]string{`type`, `type(.)`},
+			list(
+				mustString(bd, "object"),
+				mustString(bd, "array"),
+				mustString(bd, "string"),
+				mustString(bd, "int"),
+				mustString(bd, "float"),
+				mustString(bd, "bool"),
+				mustString(bd, "null"),
+			),
+		},
+
+		{"isint", true,
+			list(mustInt(bd, 1), mustFloat(bd, 1), mustString(bd, "1")),
+			[]string{`isint`, `isint(.)`},
+			list(mustBool(bd, true), mustBool(bd, false), mustBool(bd, false)),
+		},
+
+		{"isfloat", true,
+			list(mustInt(bd, 1), mustFloat(bd, 1), mustString(bd, "1")),
+			[]string{`isfloat`, `isfloat(.)`},
+			list(mustBool(bd, false), mustBool(bd, true), mustBool(bd, false)),
+		},
+
+		{"type filter objects", true,
+			list(
+				mustArray(bd,
+					mustObject(bd, map[string]msg.Msg{}),
+					mustArray(bd),
+					mustString(bd, "a"),
+					mustInt(bd, 1),
+					mustFloat(bd, 1.5),
+					mustBool(bd, false),
+					mustNull(bd),
+				),
+			),
+			[]string{`.[] | objects | type`, `.[] | select(objects | true) | type`},
+			list(
+				mustString(bd, "object"),
+			),
+		},
+
+		{"type filter arrays", true,
+			list(
+				mustArray(bd,
+					mustObject(bd, map[string]msg.Msg{}),
+					mustArray(bd),
+					mustString(bd, "a"),
+					mustInt(bd, 1),
+					mustFloat(bd, 1.5),
+					mustBool(bd, false),
+					mustNull(bd),
+				),
+			),
+			[]string{`.[] | arrays | type`, `.[] | select(arrays | true) | type`},
+			list(
+				mustString(bd, "array"),
+			),
+		},
+
+		{"type filter strings", true,
+			list(
+				mustArray(bd,
+					mustObject(bd, map[string]msg.Msg{}),
+					mustArray(bd),
+					mustString(bd, "a"),
+					mustInt(bd, 1),
+					mustFloat(bd, 1.5),
+					mustBool(bd, false),
+					mustNull(bd),
+				),
+			),
+			[]string{`.[] | strings | type`, `.[] | select(strings | true) | type`},
+			list(
+				mustString(bd, "string"),
+			),
+		},
+
+		{"type filter numbers", true,
+			list(
+				mustArray(bd,
+					mustObject(bd, map[string]msg.Msg{}),
+					mustArray(bd),
+					mustString(bd, "a"),
+					mustInt(bd, 1),
+					mustFloat(bd, 1.5),
+					mustBool(bd, false),
+					mustNull(bd),
+				),
+			),
+			[]string{`.[] | numbers | type`, `.[] | select(numbers | true) | type`},
+			list(
+				mustString(bd, "int"),
+				mustString(bd, "float"),
+			),
+		},
+
+		{"type filter booleans", true,
+			list(
+				mustArray(bd,
+					mustObject(bd, map[string]msg.Msg{}),
+					mustArray(bd),
+					mustString(bd, "a"),
+					mustInt(bd, 1),
+					mustFloat(bd, 1.5),
+					mustBool(bd, false),
+					mustNull(bd),
+				),
+			),
+			[]string{`.[] | booleans | type`, `.[] | select(booleans | true) | type`},
+			list(
+				mustString(bd, "bool"),
+			),
+		},
+
+		{"type filter nulls", true,
+			list(
+				mustArray(bd,
+					mustObject(bd, map[string]msg.Msg{}),
+					mustArray(bd),
+					mustString(bd, "a"),
+					mustInt(bd, 1),
+					mustFloat(bd, 1.5),
+					mustBool(bd, false),
+					mustNull(bd),
+				),
+			),
+			[]string{`.[] | nulls | type`, `.[] | select(nulls | true) | type`},
+			list(
+				mustString(bd, "null"),
+			),
+		},
+
+		{"type filter iterables", true,
+			list(
+				mustArray(bd,
+					mustObject(bd, map[string]msg.Msg{}),
+					mustArray(bd),
+					mustString(bd, "a"),
+					mustInt(bd, 1),
+					mustFloat(bd, 1.5),
+					mustBool(bd, false),
+					mustNull(bd),
+				),
+			),
+			[]string{`.[] | iterables | type`, `.[] | select(iterables | true) | type`},
+			list(
+				mustString(bd, "object"),
+				mustString(bd, "array"),
+			),
+		},
+
+		{"type filter scalars", true,
+			list(
+				mustArray(bd,
+					mustObject(bd, map[string]msg.Msg{}),
+					mustArray(bd),
+					mustString(bd, "a"),
+					mustInt(bd, 1),
+					mustFloat(bd, 1.5),
+					mustBool(bd, false),
+					mustNull(bd),
+				),
+			),
+			[]string{`.[] | scalars | type`, `.[] | select(scalars | true) | type`},
+			list(
+				mustString(bd, "string"),
+				mustString(bd, "int"),
+				mustString(bd, "float"),
+				mustString(bd, "bool"),
+				mustString(bd, "null"),
+			),
+		},
+
+		{"now", true,
+			list(mustNull(bd)),
+			[]string{`now`, `now | todate | fromdate | . + 0.0`},
+			list(mustFloat(bd, 1425599507)),
+		},
+
+		{"fromdateiso8601", true,
+			list(mustString(bd, "2015-03-05T23:51:47Z"), mustString(bd, "2015-03-06T00:51:47.5+01:00")),
+			[]string{`fromdateiso8601`, `fromdateiso8601(.)`, `fromdate`},
+			list(mustInt(bd, 1425599507), mustFloat(bd, 1425599507.5)),
+		},
+
+		{"todate", true,
+			list(mustInt(bd, 1425599507), mustFloat(bd, 1425599507.5)),
+			[]string{`todate`, `todate(.)`, `todateiso8601`, `gmtime | todate`},
+			list(mustString(bd, "2015-03-05T23:51:47Z"), mustString(bd, "2015-03-05T23:51:47.5Z")),
+		},
+
+		{"gmtime", true,
+			list(mustInt(bd, 1425599507)),
+			[]string{
+				`gmtime`,
+				`gmtime(.)`,
+				`todate | strptime("%Y-%m-%dT%H:%M:%SZ")`,
+				`strftime("%s") | strptime("%s")`,
+			},
+			list(mustArray(bd,
+				mustInt(bd, 2015), mustInt(bd, 2), mustInt(bd, 5),
+				mustInt(bd, 23), mustInt(bd, 51), mustInt(bd, 47),
+				mustInt(bd, 4), mustInt(bd, 63),
+			)),
+		},
+
+		{"mktime", true,
+			list(
+				mustArray(bd,
+					mustInt(bd, 2015), mustInt(bd, 2), mustInt(bd, 5),
+					mustInt(bd, 23), mustInt(bd, 51), mustInt(bd, 47),
+					mustInt(bd, 4), mustInt(bd, 63),
+				),
+				mustArray(bd,
+					mustInt(bd, 2015), mustInt(bd, 1), mustInt(bd, 33),
+					mustInt(bd, 22), mustInt(bd, 111), mustInt(bd, 47),
+				),
+			),
+			[]string{`mktime`, `mktime(.)`, `todate | fromdate`},
+			list(mustInt(bd, 1425599507), mustInt(bd, 1425599507)),
+		},
+
+		{"strftime", true,
+			list(mustInt(bd, 1425599507)),
+			[]string{
+				`strftime("%a, %d %b %Y %T %z")`,
+				`strftime(., "%a, %d %b %Y %T %z")`,
+				`gmtime | strftime("%a, %d %b %Y %H:%M:%S %z")`,
+			},
+			list(mustString(bd, "Thu, 05 Mar 2015 23:51:47 +0000")),
+		},
+
+		{"strptime", true,
+			list(mustString(bd, "Thursday March  5 2015 11:51:47 PM -01:00")),
+			[]string{
+				`strptime("%A %B %e %Y %I:%M:%S %p %z") | mktime`,
+				`strptime(., "%a %b %d %Y %r %z") | mktime`,
+			},
+			list(mustInt(bd, 1425603107)),
+		},
+
+		{"duration", true,
+			list(mustString(bd, "5m"), mustString(bd, "1.5s")),
+			[]string{`duration`, `duration(.)`},
+			list(mustInt(bd, 300), mustFloat(bd, 1.5)),
+		},
+
+		{"select recent times", true,
+			list(
+				mustObject(bd, map[string]msg.Msg{"ts": mustString(bd, "2015-03-05T23:50:00Z")}),
+				mustObject(bd, map[string]msg.Msg{"ts": mustString(bd, "2015-03-05T23:40:00Z")}),
+			),
+			[]string{
+				`select(fromdateiso8601(.ts) > now - duration("5m")) | .ts`,
+				`select(.ts | fromdate > now - 300) | .ts`,
+				`select(.ts > datesub(todate(now), "5m")) | .ts`,
+			},
+			list(mustString(bd, "2015-03-05T23:50:00Z")),
+		},
+
+		{"dateadd and datesub of dates", true,
+			list(mustObject(bd, map[string]msg.Msg{"d": mustString(bd, "2015-03-05T23:51:47Z")})),
+			[]string{
+				`dateadd(.d, "1h")`,
+				`dateadd(.d, 3600)`,
+				`datesub(.d, "-1h")`,
+				`.d | dateadd("30m") | dateadd(1800)`,
+			},
+			list(mustString(bd, "2015-03-06T00:51:47Z")),
+		},
+
+		{"dateadd and datesub of numbers", true,
+			list(mustInt(bd, 1425599507)),
+			[]string{`dateadd("1h")`, `dateadd(., 3600)`, `datesub(0 - 3600)`, `dateadd(1800.5) | dateadd("1799.5s")`},
+			list(mustInt(bd, 1425603107)),
+		},
+
+		{"time functions skip invalid times and formats", false,
+			list(
+				mustString(bd, "not a date"),
+				mustString(bd, "2015-03-05T23:51:47Z"),
+			),
+			[]string{
+				`fromdate`,
+				`dateadd("0s") | fromdate`,
+				`strptime("%Y-%m-%dT%TZ") | mktime`,
+			},
+			list(mustInt(bd, 1425599507)),
+		},
+
+		{"strftime skips unknown directives", false,
+			list(mustInt(bd, 1425599507)),
+			[]string{`strftime("%Q")`, `strftime("100%")`},
+			list(),
+		},
+
+		{"duration skips invalid durations", false,
+			list(mustString(bd, "5 minutes"), mustString(bd, "5m")),
+			[]string{`duration`, `dateadd(0, .)`},
+			list(mustInt(bd, 300)),
+		},
+
+		{"hash and xxhash", true,
+			list(mustString(bd, "abc")),
+			[]string{`hash`, `hash(.)`, `xxhash`, `xxhash(.)`},
+			list(mustInt(bd, 4952883123889572249)),
+		},
+
+		{"fnv", true,
+			list(mustString(bd, "abc")),
+			[]string{`fnv`, `fnv(.)`},
+			list(mustInt(bd, 7430836138530658123)),
+		},
+
+		{"crc32", true,
+			list(mustString(bd, "abc")),
+			[]string{`crc32`, `crc32(.)`},
+			list(mustInt(bd, 891568578)),
+		},
+
+		{"sha256", true,
+			list(mustString(bd, "abc")),
+			[]string{`sha256`, `sha256(.)`},
+			list(mustString(bd, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad")),
+		},
+
+		{"md5", true,
+			list(mustString(bd, "abc")),
+			[]string{`md5`, `md5(.)`},
+			list(mustString(bd, "900150983cd24fb0d6963f7d28e17f72")),
+		},
+
+		{"hashes of the canonical encoding", true,
+			list(
+				mustOrderedObject(bd, []string{"b", "a"}, mustArray(bd, mustBool(bd, true), mustNull(bd)), mustInt(bd, 1)),
+				mustOrderedObject(bd, []string{"a", "b"}, mustFloat(bd, 1), mustArray(bd, mustBool(bd, true), mustNull(bd))),
+			),
+			[]string{
+				`sha256`,
+				`sha256(.)`,
+				`select(md5 == "455c54e0c2289d0a89fb0b5f3b86f3d4") | sha256`,
+				`select(crc32 == 2634766188 && fnv == 518008878010672771) | sha256`,
+			},
+			list(
+				mustString(bd, "2f012f64b027e5cea3dd47865ac40f4d022ff8c3fa26c9fe270aee627a099585"),
+				mustString(bd, "2f012f64b027e5cea3dd47865ac40f4d022ff8c3fa26c9fe270aee627a099585"),
+			),
+		},
+
+		{"hashes of strings and of other messages differ", true,
+			list(mustInt(bd, 1), mustString(bd, "1")),
+			[]string{
+				`hash("1") != hash(1)`,
+				`(hash == hash("1")) != (hash == hash(1))`,
+				`(sha256 == sha256("1")) != (md5 == md5(1))`,
+			},
+			list(mustBool(bd, true), mustBool(bd, true)),
+		},
+
+		{"hash to partitions", true,
+			list(
+				mustObject(bd, map[string]msg.Msg{"customer_id": mustString(bd, "abc")}),
+				mustObject(bd, map[string]msg.Msg{"customer_id": mustInt(bd, -1)}),
+				mustObject(bd, map[string]msg.Msg{"customer_id": mustNull(bd)}),
+			),
+			[]string{`hash(.customer_id) % 64 >= 0`, `.customer_id | xxhash % 64 < 64`},
+			list(mustBool(bd, true), mustBool(bd, true), mustBool(bd, true)),
+		},
+
+		{"ip_parse", true,
+			list(mustString(bd, "10.0.0.1"), mustString(bd, "2001:DB8:0:0::1"), mustString(bd, "fe80::1%eth0")),
+			[]string{`ip_parse`, `ip_parse(.)`, `ip_parse | ip_parse`},
+			list(mustString(bd, "10.0.0.1"), mustString(bd, "2001:db8::1"), mustString(bd, "fe80::1%eth0")),
+		},
+
+		{"ip_version", true,
+			list(mustString(bd, "10.0.0.1"), mustString(bd, "2001:db8::1"), mustString(bd, "::ffff:10.0.0.1")),
+			[]string{`ip_version`, `ip_version(.)`},
+			list(mustInt(bd, 4), mustInt(bd, 6), mustInt(bd, 6)),
+		},
+
+		{"in_cidr", true,
+			list(
+				mustObject(bd, map[string]msg.Msg{"client_ip": mustString(bd, "10.1.2.3")}),
+				mustObject(bd, map[string]msg.Msg{"client_ip": mustString(bd, "11.1.2.3")}),
+				mustObject(bd, map[string]msg.Msg{"client_ip": mustString(bd, "::ffff:10.1.2.3")}),
+				mustObject(bd, map[string]msg.Msg{"client_ip": mustString(bd, "2001:db8::1")}),
+			),
+			[]string{
+				`select(in_cidr(.client_ip, "10.0.0.0/8")) | .client_ip`,
+				`select(.client_ip | in_cidr("10.0.0.0/8")) | .client_ip`,
+			},
+			list(mustString(bd, "10.1.2.3"), mustString(bd, "::ffff:10.1.2.3")),
+		},
+
+		{"in_cidr of IPv6", true,
+			list(mustString(bd, "2001:db8::1"), mustString(bd, "2001:db9::1"), mustString(bd, "10.1.2.3")),
+			[]string{`in_cidr("2001:db8::/32")`, `in_cidr(., "2001:0db8::/32")`},
+			list(mustBool(bd, true), mustBool(bd, false), mustBool(bd, false)),
+		},
+
+		{"is_private", true,
+			list(
+				mustString(bd, "10.0.0.1"),
+				mustString(bd, "172.16.5.4"),
+				mustString(bd, "192.168.1.1"),
+				mustString(bd, "fd00::1"),
+				mustString(bd, "::ffff:192.168.1.1"),
+				mustString(bd, "8.8.8.8"),
+				mustString(bd, "127.0.0.1"),
+				mustString(bd, "2001:db8::1"),
+			),
+			[]string{`is_private`, `is_private(.)`},
+			list(
+				mustBool(bd, true), mustBool(bd, true), mustBool(bd, true), mustBool(bd, true),
+				mustBool(bd, true), mustBool(bd, false), mustBool(bd, false), mustBool(bd, false),
+			),
+		},
+
+		{"is_loopback", true,
+			list(mustString(bd, "127.0.0.1"), mustString(bd, "127.255.0.1"), mustString(bd, "::1"), mustString(bd, "10.0.0.1")),
+			[]string{`is_loopback`, `is_loopback(.)`},
+			list(mustBool(bd, true), mustBool(bd, true), mustBool(bd, true), mustBool(bd, false)),
+		},
+
+		{"network functions skip invalid addresses", false,
+			list(mustString(bd, "10.0.0.256"), mustString(bd, "example.com"), mustString(bd, "10.0.0.1")),
+			[]string{
+				`ip_parse`,
+				`select(is_private) | ip_parse`,
+				`select(ip_version == 4) | ip_parse`,
+				`select(in_cidr("10.0.0.0/8")) | .`,
+			},
+			list(mustString(bd, "10.0.0.1")),
+		},
+
+		{"in_cidr skips invalid CIDRs", false,
+			list(mustString(bd, "10.0.0.1")),
+			[]string{`in_cidr("10.0.0.0/33")`, `in_cidr("10.0.0.0")`, `in_cidr(., "")`},
+			list(),
+		},
+
+		{"url_parse", true,
+			list(
+				mustString(bd, "https://example.com:8080/api/v%31?a=1&b=x+y#top%21"),
+				mustString(bd, "/health"),
+			),
+			[]string{`url_parse`, `url_parse(.)`},
+			list(
+				mustOrderedObject(bd,
+					[]string{"scheme", "host", "port", "path", "query", "fragment"},
+					mustString(bd, "https"),
+					mustString(bd, "example.com"),
+					mustInt(bd, 8080),
+					mustString(bd, "/api/v1"),
+					mustString(bd, "a=1&b=x+y"),
+					mustString(bd, "top!"),
+				),
+				mustOrderedObject(bd,
+					[]string{"scheme", "host", "port", "path", "query", "fragment"},
+					mustString(bd, ""),
+					mustString(bd, ""),
+					mustNull(bd),
+					mustString(bd, "/health"),
+					mustString(bd, ""),
+					mustString(bd, ""),
+				),
+			),
+		},
+
+		{"select URLs by path", true,
+			list(
+				mustObject(bd, map[string]msg.Msg{"url": mustString(bd, "http://[::1]:80/api/users")}),
+				mustObject(bd, map[string]msg.Msg{"url": mustString(bd, "http://example.com/static/app.js")}),
+			),
+			[]string{
+				`select(url_parse(.url) | .path | startswith("/api")) | url_parse(.url) | .host`,
+				`url_parse(.url) | select(startswith(.path, "/api")) | select(.port == 80) | .host`,
+			},
+			list(mustString(bd, "::1")),
+		},
+
+		{"query_parse", true,
+			list(mustString(bd, "?a=1&b=x+y&a=2&c&%3D=%26")),
+			[]string{`query_parse`, `query_parse(.)`, `"http://example.com/?" + . | url_parse | .query | query_parse`},
+			list(mustOrderedObject(bd,
+				[]string{"a", "b", "c", "="},
+				mustArray(bd, mustString(bd, "1"), mustString(bd, "2")),
+				mustString(bd, "x y"),
+				mustString(bd, ""),
+				mustString(bd, "&"),
+			)),
+		},
+
+		{"url_encode and url_decode", true,
+			list(mustString(bd, "a b&c=d/é")),
+			[]string{`url_encode`, `url_encode(.)`, `url_encode | url_decode | url_encode`},
+			list(mustString(bd, "a+b%26c%3Dd%2F%C3%A9")),
+		},
+
+		{"url_decode", true,
+			list(mustString(bd, "a+b%26c%3Dd%2F%C3%A9")),
+			[]string{`url_decode`, `url_decode(.)`},
+			list(mustString(bd, "a b&c=d/é")),
+		},
+
+		{"URL functions skip what isn't encoded properly", false,
+			list(mustString(bd, "%zz"), mustString(bd, "http://a b/%"), mustString(bd, "x=%41")),
+			[]string{`query_parse | .x`, `url_decode | query_parse | .x`},
+			list(mustString(bd, "A")),
+		},
+
+		{"url_parse skips invalid URLs", false,
+			list(mustString(bd, "%zz"), mustString(bd, "http://a b/"), mustString(bd, "http://ok/")),
+			[]string{`url_parse | .host`, `url_parse(.) | .host`},
+			list(mustString(bd, "ok")),
+		},
+
+		{"regexp family skips invalid patterns", false,
+			list(
+				mustObject(bd, map[string]msg.Msg{"s": mustString(bd, "a"), "pattern": mustString(bd, "a(")}),
+				mustObject(bd, map[string]msg.Msg{"s": mustString(bd, "a"), "pattern": mustString(bd, "a(")}),
+				mustObject(bd, map[string]msg.Msg{"s": mustString(bd, "b"), "pattern": mustString(bd, "b")}),
+			),
+			[]string{
+				`test(.s, .pattern)`,
+				`match(.s, .pattern) | .string == "b"`,
+				`scan(.s, .pattern) == "b"`,
+			},
+			list(mustBool(bd, true)),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, query := range tt.query {
+				t.Logf("query=\t%s", query)
+				tree, err := grammar.Parse(strings.NewReader(query))
+				if err != nil {
+					t.Fatal(err)
+				}
+				vm := mkVM(tree, &vm.Options{
+					Strict: tt.strict,
+					Clock:  func() time.Time { return now },
+				})
+
+				var got []msg.Msg
+				err = vm.Run(bd, ArraySource(tt.input), func(m msg.Msg) error {
+					if m == nil {
+						panic("derpo")
+					}
+					got = append(got, m)
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want, got := tt.want, got; !reflect.DeepEqual(want, got) {
+					t.Errorf("want=%#v", want)
+					t.Errorf(" got=%#v", got)
+				}
+			}
+		})
+	}
+}
+
+func list(allOfThem ...msg.Msg) []msg.Msg { return allOfThem }
+
+// keyless is an array of objects named by n, some of which have no x.
+func keyless(bd msg.Builder) msg.Msg {
+	return mustArray(bd,
+		mustObject(bd, map[string]msg.Msg{"n": mustString(bd, "a"), "x": mustInt(bd, 2)}),
+		mustObject(bd, map[string]msg.Msg{"n": mustString(bd, "b")}),
+		mustObject(bd, map[string]msg.Msg{"n": mustString(bd, "c"), "x": mustInt(bd, 1)}),
+		mustObject(bd, map[string]msg.Msg{"n": mustString(bd, "d"), "x": mustNull(bd)}),
+	)
+}
+
+type arraySource struct {
+	data []msg.Msg
+}
+
+func ArraySource(data []msg.Msg) msg.Source {
+	n := len(data)
+	i := 0
+	return func() (msg.Msg, bool, error) {
+		i++
+		if i > n {
+			return nil, false, nil
+		}
+		return data[i-1], i <= n, nil
+	}
+}
+
+func mustObject(bd msg.Builder, obj map[string]msg.Msg) msg.Msg {
+	// add the members in a stable order, otherwise two objects built
+	// from the same map won't be deeply equal
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return mustMsg(bd.Object(func(ob msg.ObjectBuilder) error {
+		for _, k := range keys {
+			v := obj[k]
+			err := ob.AddMember(k, func(_ msg.Builder) (msg.Msg, error) {
+				return v, nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+func mustOrderedObject(bd msg.Builder, keys []string, vals ...msg.Msg) msg.Msg {
 	return mustMsg(bd.Object(func(ob msg.ObjectBuilder) error {
-		for k, v := range obj {
+		for i, k := range keys {
+			v := vals[i]
 			err := ob.AddMember(k, func(_ msg.Builder) (msg.Msg, error) {
 				return v, nil
 			})
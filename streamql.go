@@ -3,14 +3,29 @@ package streamql
 import (
 	"strings"
 
+	"github.com/aybabtme/streamql/lang/ast"
+	"github.com/aybabtme/streamql/lang/check"
 	"github.com/aybabtme/streamql/lang/grammar"
+	"github.com/aybabtme/streamql/lang/optimize"
+	"github.com/aybabtme/streamql/lang/projection"
 	"github.com/aybabtme/streamql/lang/vm"
 	"github.com/aybabtme/streamql/lang/vm/astvm"
 )
 
+// A Query is a compiled query. It's a VM that executes the query.
+type Query struct {
+	vm.VM
+	paths projection.Set
+}
+
+// Paths returns the paths of the parts of messages that the query
+// reads. The other parts of the messages given to the query don't
+// need to be decoded.
+func (q *Query) Paths() projection.Set { return q.paths }
+
 // MustCompile is like Compile but panics if the query is
 // invalid.
-func MustCompile(query string) vm.VM {
+func MustCompile(query string) *Query {
 	eng, err := Compile(query)
 	if err != nil {
 		panic(err)
@@ -20,12 +35,54 @@ func MustCompile(query string) vm.VM {
 
 // Compile parses the query and returns an error
 // if the syntax is invalid or if the query doesn't
-// have exactly 1 filter. It returns a VM that can
-// execute the query.
-func Compile(query string) (vm.VM, error) {
-	tree, err := grammar.Parse(strings.NewReader(query))
+// have exactly 1 filter. It also returns an error
+// if the query calls functions that don't exist or
+// misuses literals. It returns the query, optimized,
+// along with the parts of messages that it reads.
+func Compile(query string) (*Query, error) {
+	return CompileWithLimits(query, nil)
+}
+
+// CompileWithLimits is like Compile, but also returns an error if the
+// query goes past the limits, unless they are nil. It's meant for
+// queries that can't be trusted to be reasonable: one that is nested
+// too deeply or has too many nodes is rejected while it's parsed,
+// before all of it is.
+func CompileWithLimits(query string, limits *check.Limits) (*Query, error) {
+	tree, err := parse(query, limits)
 	if err != nil {
 		return nil, err
 	}
-	return astvm.Interpreter(tree, &vm.Options{}), nil
+	return compile(tree, limits)
+}
+
+// CompileTree is like CompileWithLimits, but for a query that was
+// already parsed, like one decoded by ast.Unmarshal. It returns an
+// error if the tree isn't valid.
+func CompileTree(tree *ast.AST, limits *check.Limits) (*Query, error) {
+	if err := ast.Validate(tree); err != nil {
+		return nil, err
+	}
+	return compile(tree, limits)
+}
+
+// parse parses the query, giving up on it as soon as it goes past the
+// limits on its depth and its nodes, if they're set.
+func parse(query string, limits *check.Limits) (*ast.AST, error) {
+	if limits == nil {
+		return grammar.Parse(strings.NewReader(query))
+	}
+	return grammar.ParseWithLimits(strings.NewReader(query), limits.MaxDepth, limits.MaxNodes)
+}
+
+func compile(tree *ast.AST, limits *check.Limits) (*Query, error) {
+	conf := &check.Config{Funcs: astvm.Builtin, Limits: limits}
+	if _, err := conf.Check(tree); err != nil {
+		return nil, err
+	}
+	tree = optimize.Optimize(tree)
+	return &Query{
+		VM:    astvm.Interpreter(tree, &vm.Options{}),
+		paths: projection.Paths(tree, astvm.Builtin),
+	}, nil
 }
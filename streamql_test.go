@@ -0,0 +1,51 @@
+package streamql
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aybabtme/streamql/lang/check"
+	"github.com/aybabtme/streamql/lang/grammar"
+	"github.com/aybabtme/streamql/lang/vm/astvm"
+)
+
+func TestCompileWithLimitsWhileParsing(t *testing.T) {
+	query := strings.Repeat("!", 1000000) + "true"
+	_, err := CompileWithLimits(query, &check.Limits{MaxDepth: 10})
+	if want := "1:11: expression is nested 11 deep, more than the limit of 10"; err == nil || err.Error() != want {
+		t.Errorf("want=%s", want)
+		t.Errorf(" got=%v", err)
+	}
+}
+
+// TestParseWithLimitsAgreesWithCheck checks that the limits that are
+// checked while parsing never reject a query that the checker accepts.
+func TestParseWithLimitsAgreesWithCheck(t *testing.T) {
+	queries := []string{
+		`.a | .b | .c`,
+		`(.a + 1) * 2`,
+		`1 - 2 - 3 - 4`,
+		`!!(.a && -.b > 1)`,
+		`.a[.b[.c]]["d"][1:.e]`,
+		`select(.a != null && length(.b) > 2) | join(split(upper(.x), ","), ".")`,
+	}
+	for _, query := range queries {
+		if _, err := Compile(query); err != nil {
+			t.Fatal(err)
+		}
+		for max := 1; max < 8; max++ {
+			limits := &check.Limits{MaxDepth: max, MaxNodes: 4 * max}
+			if _, err := grammar.ParseWithLimits(strings.NewReader(query), limits.MaxDepth, limits.MaxNodes); err == nil {
+				continue
+			}
+			tree, err := grammar.Parse(strings.NewReader(query))
+			if err != nil {
+				t.Fatal(err)
+			}
+			conf := &check.Config{Funcs: astvm.Builtin, Limits: limits}
+			if _, err := conf.Check(tree); err == nil {
+				t.Errorf("%s: rejected while parsing with limits %+v, but accepted by the checker", query, *limits)
+			}
+		}
+	}
+}